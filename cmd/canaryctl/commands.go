@@ -0,0 +1,392 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/util/retry"
+)
+
+// addMicroServiceFlag adds the flag naming the MicroService of an App target.
+func addMicroServiceFlag(cmd *cobra.Command, name *string) {
+	cmd.Flags().StringVarP(name, "microservice", "m", "", "The microservice of the App to operate on, by its name in the App.")
+}
+
+// appVersionSpec returns the spec of the MicroService of app with the name,
+// which must not take part in the release of app.
+func appVersionSpec(app *appv1.App, name string) (*appv1.MicroServiceSpec, error) {
+	if name == "" {
+		return nil, fmt.Errorf("name the microservice of app %q with --microservice", app.Name)
+	}
+	if err := checkNotInRelease(app, name); err != nil {
+		return nil, err
+	}
+	return appMicroService(app, name)
+}
+
+func newSetWeightCommand(o *options) *cobra.Command {
+	var microService string
+	cmd := &cobra.Command{
+		Use:   "set-weight TARGET VERSION WEIGHT",
+		Short: "Set the share of the traffic, in percent, a canary version receives",
+		Long: `Set the share of the traffic, in percent, a canary version receives.
+A weight of 0 removes the canary settings of the version.`,
+		Example: `  canaryctl set-weight microservice/web v2 20
+  canaryctl set-weight app/shop -m web v2 20`,
+		Args: cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			t, err := parseTarget(args[0])
+			if err != nil {
+				return err
+			}
+			version := args[1]
+			weight, err := strconv.Atoi(args[2])
+			if err != nil {
+				return fmt.Errorf("weight %q is not a number", args[2])
+			}
+			if err := o.init(); err != nil {
+				return err
+			}
+
+			change := historyEntry{Changes: []versionChange{{MicroService: microService, Version: version, Weight: &weight}}}
+			return o.apply(setWeightAction, t, mutation{
+				microService: func(ms *appv1.MicroService) (historyEntry, error) {
+					return change, setWeight(&ms.Spec, version, weight)
+				},
+				app: func(app *appv1.App) (historyEntry, error) {
+					spec, err := appVersionSpec(app, microService)
+					if err != nil {
+						return change, err
+					}
+					return change, setWeight(spec, version, weight)
+				},
+			})
+		},
+	}
+	addMicroServiceFlag(cmd, &microService)
+	return cmd
+}
+
+func newPromoteCommand(o *options) *cobra.Command {
+	var microService string
+	cmd := &cobra.Command{
+		Use:   "promote TARGET [VERSION]",
+		Short: "Make a canary version the current version",
+		Long: `Make a canary version the current version, it then receives all the traffic.
+Without VERSION the only canary version is promoted. On an App without
+--microservice, the target versions of the release are promoted and the
+release is removed.`,
+		Example: `  canaryctl promote microservice/web v2
+  canaryctl promote app/shop -m web
+  canaryctl promote app/shop`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			t, err := parseTarget(args[0])
+			if err != nil {
+				return err
+			}
+			var version string
+			if len(args) > 1 {
+				version = args[1]
+			}
+			if err := o.init(); err != nil {
+				return err
+			}
+
+			return o.apply(promoteAction, t, mutation{
+				microService: func(ms *appv1.MicroService) (historyEntry, error) {
+					return promoteVersion(&ms.Spec, "", version)
+				},
+				app: func(app *appv1.App) (historyEntry, error) {
+					if microService == "" && version == "" {
+						var entry historyEntry
+						if app.Spec.Release != nil {
+							entry.Release = app.Spec.Release.Name
+						}
+						changes, err := promoteRelease(app)
+						entry.Changes = changes
+						return entry, err
+					}
+					spec, err := appVersionSpec(app, microService)
+					if err != nil {
+						return historyEntry{}, err
+					}
+					return promoteVersion(spec, microService, version)
+				},
+			})
+		},
+	}
+	addMicroServiceFlag(cmd, &microService)
+	return cmd
+}
+
+// promoteVersion promotes the version of spec and describes the change.
+func promoteVersion(spec *appv1.MicroServiceSpec, microService, version string) (historyEntry, error) {
+	if version == "" {
+		if canaries := canaryVersions(spec); len(canaries) == 1 {
+			version = canaries[0]
+		}
+	}
+	previous, err := promote(spec, version)
+	return historyEntry{Changes: []versionChange{{MicroService: microService, Version: version, Previous: previous}}}, err
+}
+
+func newAbortCommand(o *options) *cobra.Command {
+	var microService string
+	cmd := &cobra.Command{
+		Use:   "abort TARGET",
+		Short: "Send all the traffic back to the current version",
+		Long: `Send all the traffic back to the current version by removing the canary
+settings of the canary versions. On an App without --microservice, the release
+is rolled back.`,
+		Example: `  canaryctl abort microservice/web
+  canaryctl abort app/shop`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			t, err := parseTarget(args[0])
+			if err != nil {
+				return err
+			}
+			if err := o.init(); err != nil {
+				return err
+			}
+
+			abortVersions := func(spec *appv1.MicroServiceSpec, microService string) (historyEntry, error) {
+				var entry historyEntry
+				versions, err := abort(spec)
+				for _, version := range versions {
+					entry.Changes = append(entry.Changes, versionChange{MicroService: microService, Version: version})
+				}
+				return entry, err
+			}
+			if t.kind == appKind && microService == "" {
+				release, err := o.abortRelease(t.name)
+				if err != nil {
+					return err
+				}
+				// the release status is updated already, only the history is recorded
+				return o.apply(abortAction, t, mutation{
+					app: func(app *appv1.App) (historyEntry, error) {
+						return historyEntry{Release: release}, nil
+					},
+				})
+			}
+			return o.apply(abortAction, t, mutation{
+				microService: func(ms *appv1.MicroService) (historyEntry, error) {
+					return abortVersions(&ms.Spec, "")
+				},
+				app: func(app *appv1.App) (historyEntry, error) {
+					spec, err := appVersionSpec(app, microService)
+					if err != nil {
+						return historyEntry{}, err
+					}
+					return abortVersions(spec, microService)
+				},
+			})
+		},
+	}
+	addMicroServiceFlag(cmd, &microService)
+	return cmd
+}
+
+// abortRelease rolls back the progressing release of the App with the name, the
+// App controller then removes the Canary of the participants. It returns the
+// name of the release.
+func (o *options) abortRelease(name string) (string, error) {
+	var release string
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		app := &appv1.App{}
+		if err := o.get(name, app); err != nil {
+			return err
+		}
+		status := app.Status.Release
+		if app.Spec.Release == nil || status == nil || status.Name != app.Spec.Release.Name {
+			return fmt.Errorf("app %q has no started release, name the microservice with --microservice", name)
+		}
+		if status.Phase != appv1.ReleaseProgressing {
+			return fmt.Errorf("release %q is %s already", status.Name, status.Phase)
+		}
+		release = status.Name
+		status.Phase = appv1.ReleaseRolledBack
+		status.Message = "Aborted with canaryctl."
+		return o.client.Status().Update(context.TODO(), app)
+	})
+	return release, err
+}
+
+func newRollbackCommand(o *options) *cobra.Command {
+	var microService string
+	cmd := &cobra.Command{
+		Use:   "rollback TARGET [VERSION]",
+		Short: "Make a previous version the current version again",
+		Long: `Make a previous version the current version again and remove the canary
+settings of every version. Without VERSION the version that was current before
+the latest promote or rollback is used. On an App without --microservice, every
+microservice changed by the latest promote or rollback is rolled back.`,
+		Example: `  canaryctl rollback microservice/web
+  canaryctl rollback app/shop -m web v1
+  canaryctl rollback app/shop`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			t, err := parseTarget(args[0])
+			if err != nil {
+				return err
+			}
+			var version string
+			if len(args) > 1 {
+				version = args[1]
+			}
+			if err := o.init(); err != nil {
+				return err
+			}
+
+			rollbackVersion := func(spec *appv1.MicroServiceSpec, microService, version string, history []historyEntry) (versionChange, error) {
+				if version == "" {
+					version = previousVersion(history, microService)
+				}
+				previous, err := rollback(spec, version)
+				return versionChange{MicroService: microService, Version: version, Previous: previous}, err
+			}
+			return o.apply(rollbackAction, t, mutation{
+				microService: func(ms *appv1.MicroService) (historyEntry, error) {
+					history, err := readHistory(ms)
+					if err != nil {
+						return historyEntry{}, err
+					}
+					change, err := rollbackVersion(&ms.Spec, "", version, history)
+					return historyEntry{Changes: []versionChange{change}}, err
+				},
+				app: func(app *appv1.App) (historyEntry, error) {
+					var entry historyEntry
+					history, err := readHistory(app)
+					if err != nil {
+						return entry, err
+					}
+					if microService != "" {
+						spec, err := appVersionSpec(app, microService)
+						if err != nil {
+							return entry, err
+						}
+						change, err := rollbackVersion(spec, microService, version, history)
+						entry.Changes = append(entry.Changes, change)
+						return entry, err
+					}
+					if version != "" {
+						return entry, fmt.Errorf("name the microservice of version %q with --microservice", version)
+					}
+
+					last := lastPromotion(history, "*")
+					if last == nil {
+						return entry, fmt.Errorf("app %q has no promote to roll back", app.Name)
+					}
+					for _, promoted := range last.Changes {
+						spec, err := appVersionSpec(app, promoted.MicroService)
+						if err != nil {
+							return entry, err
+						}
+						change, err := rollbackVersion(spec, promoted.MicroService, promoted.Previous, history)
+						if err != nil {
+							return entry, fmt.Errorf("microservice %q: %v", promoted.MicroService, err)
+						}
+						entry.Changes = append(entry.Changes, change)
+					}
+					return entry, nil
+				},
+			})
+		},
+	}
+	addMicroServiceFlag(cmd, &microService)
+	return cmd
+}
+
+// newPauseCommand returns the pause command, or the resume command when pause is false.
+func newPauseCommand(o *options, pause bool) *cobra.Command {
+	use, short, action := "resume TARGET", "Let the release of an App move on to its next steps", resumeAction
+	if pause {
+		use, short, action = "pause TARGET", "Keep the release of an App on its current step", pauseAction
+	}
+	return &cobra.Command{
+		Use:     use,
+		Short:   short,
+		Example: "  canaryctl " + action + " app/shop",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			t, err := parseTarget(args[0])
+			if err != nil {
+				return err
+			}
+			if err := o.init(); err != nil {
+				return err
+			}
+
+			return o.apply(action, t, mutation{
+				app: func(app *appv1.App) (historyEntry, error) {
+					release := app.Spec.Release
+					if release == nil {
+						return historyEntry{}, fmt.Errorf("app %q has no release", app.Name)
+					}
+					if release.Paused == pause {
+						return historyEntry{}, fmt.Errorf("release %q is already %sd", release.Name, action)
+					}
+					release.Paused = pause
+					return historyEntry{Release: release.Name}, nil
+				},
+			})
+		},
+	}
+}
+
+func newHistoryCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:     "history TARGET",
+		Short:   "List the release operations made on a MicroService or an App",
+		Example: "  canaryctl history microservice/web",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			t, err := parseTarget(args[0])
+			if err != nil {
+				return err
+			}
+			if err := o.init(); err != nil {
+				return err
+			}
+
+			obj, err := o.getTarget(t)
+			if err != nil {
+				return err
+			}
+			history, err := readHistory(obj)
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(o.out, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "TIME\tACTION\tDETAILS")
+			for i := range history {
+				entry := &history[i]
+				fmt.Fprintf(w, "%s\t%s\t%s\n", entry.Time.Format("2006-01-02 15:04:05"), entry.Action, entry.details())
+			}
+			return w.Flush()
+		},
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"canary-crd/pkg/compose"
+	"canary-crd/pkg/render"
+
+	"github.com/spf13/cobra"
+)
+
+func newImportComposeCommand(o *options) *cobra.Command {
+	var file string
+	opts := compose.Options{}
+	cmd := &cobra.Command{
+		Use:   "import-compose -f FILE",
+		Short: "Convert a docker-compose file into an App",
+		Long: `Convert a docker-compose version 3 file into an App.
+
+Each compose service becomes a MicroService with a single version. Its ports
+and exposed ports become the ports of a Service named after the compose
+service, and with --ingress-domain its published ports are served by an
+Ingress on <service>.<domain>. Warnings about the keys without an equivalent
+are written to the standard error.`,
+		Example: `  canaryctl import-compose -f docker-compose.yml --app voting > app.yaml
+  canaryctl import-compose -f docker-compose.yml --app voting --ingress-domain voting.example.com`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var data []byte
+			var err error
+			if file == "-" {
+				data, err = ioutil.ReadAll(os.Stdin)
+			} else {
+				data, err = ioutil.ReadFile(file)
+			}
+			if err != nil {
+				return err
+			}
+
+			opts.Namespace = o.namespace
+			app, warnings, err := compose.Convert(data, opts)
+			for _, warning := range warnings {
+				fmt.Fprintln(cmd.ErrOrStderr(), "Warning:", warning)
+			}
+			if err != nil {
+				return err
+			}
+			return render.Encode(o.out, o.scheme, []render.Object{app})
+		},
+	}
+	cmd.Flags().StringVarP(&file, "filename", "f", "docker-compose.yml", "The compose file, - reads the standard input.")
+	cmd.Flags().StringVar(&opts.Name, "app", "", "The name of the App, defaults to the top-level name of the compose file.")
+	cmd.Flags().StringVar(&opts.Version, "version-name", "v1", "The name of the version of every MicroService.")
+	cmd.Flags().StringVar(&opts.IngressDomain, "ingress-domain", "", "Serve the published ports of the services with Ingresses on subdomains of this domain.")
+	cmd.Flags().StringVar(&opts.IngressClassName, "ingress-class", "", "The ingress class of the generated Ingresses.")
+	return cmd
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// historyAnnotation holds the release operations made with canaryctl on a
+// MicroService or an App, as a JSON list of historyEntry.
+const historyAnnotation = "app.o0w0o.cn/release-history"
+
+// historyLimit is the number of history entries kept on an object.
+const historyLimit = 10
+
+// The actions of the history entries, named after the commands.
+const (
+	setWeightAction = "set-weight"
+	promoteAction   = "promote"
+	abortAction     = "abort"
+	rollbackAction  = "rollback"
+	pauseAction     = "pause"
+	resumeAction    = "resume"
+)
+
+// historyEntry is one release operation.
+type historyEntry struct {
+	Time    metav1.Time     `json:"time"`
+	Action  string          `json:"action"`
+	Release string          `json:"release,omitempty"`
+	Changes []versionChange `json:"changes,omitempty"`
+}
+
+// versionChange is the change an operation made to one version.
+type versionChange struct {
+	// MicroService is the name of the MicroService in the App, empty on a MicroService.
+	MicroService string `json:"microService,omitempty"`
+	Version      string `json:"version"`
+	// Weight is the canary weight set on the version.
+	// +optional
+	Weight *int `json:"weight,omitempty"`
+	// Previous is the version that was current before a promote or rollback.
+	// +optional
+	Previous string `json:"previous,omitempty"`
+}
+
+// details describes the changes of e.
+func (e *historyEntry) details() string {
+	var parts []string
+	if e.Release != "" {
+		parts = append(parts, "release "+e.Release)
+	}
+	for _, change := range e.Changes {
+		part := change.Version
+		if change.MicroService != "" {
+			part = change.MicroService + "/" + part
+		}
+		if change.Weight != nil {
+			part += fmt.Sprintf(" weight %d", *change.Weight)
+		}
+		if change.Previous != "" {
+			part += " (was " + change.Previous + ")"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// readHistory returns the history of obj, oldest first.
+func readHistory(obj metav1.Object) ([]historyEntry, error) {
+	value, ok := obj.GetAnnotations()[historyAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	var history []historyEntry
+	if err := json.Unmarshal([]byte(value), &history); err != nil {
+		return nil, fmt.Errorf("unable to read annotation %s: %v", historyAnnotation, err)
+	}
+	return history, nil
+}
+
+// recordHistory appends entry to the history of obj and drops the oldest entries
+// beyond historyLimit.
+func recordHistory(obj metav1.Object, entry historyEntry) error {
+	history, err := readHistory(obj)
+	if err != nil {
+		return err
+	}
+	history = append(history, entry)
+	if len(history) > historyLimit {
+		history = history[len(history)-historyLimit:]
+	}
+	value, err := json.Marshal(history)
+	if err != nil {
+		return err
+	}
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[historyAnnotation] = string(value)
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+// lastPromotion returns the latest promote or rollback in history that changed
+// the MicroService with the name, any MicroService when the name is "*".
+func lastPromotion(history []historyEntry, name string) *historyEntry {
+	for i := len(history) - 1; i >= 0; i-- {
+		entry := &history[i]
+		if entry.Action != promoteAction && entry.Action != rollbackAction {
+			continue
+		}
+		for _, change := range entry.Changes {
+			if name == "*" || change.MicroService == name {
+				return entry
+			}
+		}
+	}
+	return nil
+}
+
+// previousVersion returns the version that was current before the latest promote
+// or rollback of the MicroService with the name, empty when there is none.
+func previousVersion(history []historyEntry, name string) string {
+	entry := lastPromotion(history, name)
+	if entry == nil {
+		return ""
+	}
+	for _, change := range entry.Changes {
+		if change.MicroService == name {
+			return change.Previous
+		}
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+
+	"github.com/onsi/gomega"
+)
+
+func TestHistory(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	ms := &appv1.MicroService{}
+	history, err := readHistory(ms)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(history).To(gomega.BeEmpty())
+
+	weight := 20
+	g.Expect(recordHistory(ms, historyEntry{Action: setWeightAction, Changes: []versionChange{{Version: "v2", Weight: &weight}}})).To(gomega.Succeed())
+	g.Expect(recordHistory(ms, historyEntry{Action: promoteAction, Changes: []versionChange{{Version: "v2", Previous: "v1"}}})).To(gomega.Succeed())
+	g.Expect(recordHistory(ms, historyEntry{Action: abortAction, Changes: []versionChange{{Version: "v3"}}})).To(gomega.Succeed())
+	history, err = readHistory(ms)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(history).To(gomega.HaveLen(3))
+	g.Expect(history[0].details()).To(gomega.Equal("v2 weight 20"))
+	g.Expect(history[1].details()).To(gomega.Equal("v2 (was v1)"))
+	g.Expect(previousVersion(history, "")).To(gomega.Equal("v1"))
+	g.Expect(previousVersion(history, "web")).To(gomega.BeEmpty())
+
+	for i := 0; i < historyLimit; i++ {
+		g.Expect(recordHistory(ms, historyEntry{Action: setWeightAction, Release: fmt.Sprint(i)})).To(gomega.Succeed())
+	}
+	history, err = readHistory(ms)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(history).To(gomega.HaveLen(historyLimit))
+	g.Expect(history[0].Release).To(gomega.Equal("0"))
+	g.Expect(previousVersion(history, "")).To(gomega.BeEmpty())
+}
+
+func TestParseTarget(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	target, err := parseTarget("ms/web")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(target.String()).To(gomega.Equal("microservice/web"))
+	target, err = parseTarget("App/shop")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(target.String()).To(gomega.Equal("app/shop"))
+
+	for _, arg := range []string{"web", "app/", "deployment/web"} {
+		_, err = parseTarget(arg)
+		g.Expect(err).To(gomega.HaveOccurred())
+	}
+}
@@ -0,0 +1,234 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	"github.com/onsi/gomega"
+)
+
+const testImport = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web-v1
+  namespace: prod
+spec:
+  selector:
+    matchLabels:
+      app: web
+      version: v1
+  template:
+    metadata:
+      labels:
+        app: web
+        version: v1
+    spec:
+      containers:
+      - name: web
+        image: web:v1
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web-next
+  namespace: prod
+spec:
+  selector:
+    matchLabels:
+      app: web
+      version: v2
+  template:
+    metadata:
+      labels:
+        app: web
+        version: v2
+    spec:
+      containers:
+      - name: web
+        image: web:v2
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: web
+    namespace: prod
+  spec:
+    clusterIP: 10.0.0.10
+    selector:
+      app: web
+      version: v1
+    ports:
+    - port: 80
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: web-canary
+    namespace: prod
+  spec:
+    selector:
+      app: web
+      version: v2
+    ports:
+    - port: 80
+---
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: web
+  namespace: prod
+  annotations:
+    kubernetes.io/ingress.class: nginx
+    cert-manager.io/cluster-issuer: letsencrypt
+    nginx.ingress.kubernetes.io/rewrite-target: /
+spec:
+  tls:
+  - hosts:
+    - web.example.com
+    secretName: web-tls
+  rules:
+  - host: web.example.com
+    http:
+      paths:
+      - path: /
+        backend:
+          serviceName: web
+          servicePort: 80
+---
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: web-canary
+  namespace: prod
+  annotations:
+    nginx.ingress.kubernetes.io/canary: "true"
+    nginx.ingress.kubernetes.io/canary-weight: "20"
+    nginx.ingress.kubernetes.io/canary-by-header: X-Canary
+spec:
+  rules:
+  - host: web.example.com
+    http:
+      paths:
+      - path: /
+        pathType: Prefix
+        backend:
+          service:
+            name: web-canary
+            port:
+              number: 80
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+  namespace: prod
+`
+
+func TestImport(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	im := newImporter(configv1alpha1.Default(), "app", "version")
+	g.Expect(readStream(strings.NewReader(testImport), im.add)).To(gomega.Succeed())
+	microServices, err := im.microServices()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(microServices).To(gomega.HaveLen(1))
+
+	ms := microServices[0]
+	g.Expect(ms.Name).To(gomega.Equal("web"))
+	g.Expect(ms.Namespace).To(gomega.Equal("prod"))
+	g.Expect(ms.Spec.CurrentVersionName).To(gomega.Equal("v1"))
+	g.Expect(ms.Spec.Versions).To(gomega.HaveLen(2))
+	g.Expect(ms.Spec.Versions[0].Canary).To(gomega.BeNil())
+
+	canary := ms.Spec.Versions[1]
+	g.Expect(canary.ServiceName).To(gomega.Equal("web-canary"))
+	g.Expect(canary.Canary).NotTo(gomega.BeNil())
+	g.Expect(canary.Canary.Weight).To(gomega.Equal(20))
+	g.Expect(canary.Canary.Header).To(gomega.Equal("X-Canary"))
+	g.Expect(canary.Canary.CanaryIngressName).To(gomega.Equal("web-canary"))
+
+	lb := ms.Spec.LoadBalance
+	g.Expect(lb.Service.Name).To(gomega.Equal("web"))
+	g.Expect(lb.Service.Spec.Selector).To(gomega.BeEmpty())
+	g.Expect(lb.Service.Spec.ClusterIP).To(gomega.BeEmpty())
+	g.Expect(lb.Services).To(gomega.BeEmpty())
+	g.Expect(lb.Ingress.Name).To(gomega.Equal("web"))
+	g.Expect(*lb.Ingress.Spec.IngressClassName).To(gomega.Equal("nginx"))
+	g.Expect(lb.Ingress.TLS.ClusterIssuer).To(gomega.Equal("letsencrypt"))
+	g.Expect(lb.Ingress.TLS.SecretName).To(gomega.Equal("web-tls"))
+	g.Expect(lb.Ingresses).To(gomega.BeEmpty())
+
+	warnings := strings.Join(im.warnings, "\n")
+	g.Expect(warnings).To(gomega.ContainSubstring("skipped ConfigMap"))
+	g.Expect(warnings).To(gomega.ContainSubstring("Deployment web-next is created again as web-v2"))
+	g.Expect(warnings).To(gomega.ContainSubstring("nginx.ingress.kubernetes.io/rewrite-target"))
+}
+
+func TestImportApp(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	im := newImporter(configv1alpha1.Default(), "app", "version")
+	im.app = "shop"
+	g.Expect(readStream(strings.NewReader(testImport), im.add)).To(gomega.Succeed())
+	_, err := im.microServices()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// the workloads of the MicroServices of an App are named after the App
+	warnings := strings.Join(im.warnings, "\n")
+	g.Expect(warnings).To(gomega.ContainSubstring("Deployment web-v1 is created again as shop-web-v1, delete it once microservice shop-web is running"))
+	g.Expect(warnings).To(gomega.ContainSubstring("Deployment web-next is created again as shop-web-v2"))
+}
+
+func TestImportCurrentVersion(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "canaryctl")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer os.RemoveAll(dir)
+	// without Services neither version is known to be the current one
+	deployments := strings.SplitN(testImport, "---\n", 3)
+	file := filepath.Join(dir, "deployments.yaml")
+	g.Expect(ioutil.WriteFile(file, []byte(deployments[0]+"---\n"+deployments[1]), 0644)).To(gomega.Succeed())
+
+	importCommand := func(args ...string) (string, error) {
+		out := &bytes.Buffer{}
+		cmd := newImportCommand(&options{out: out, scheme: newScheme()})
+		cmd.SilenceUsage, cmd.SilenceErrors = true, true
+		cmd.SetOutput(&bytes.Buffer{})
+		cmd.SetArgs(append([]string{"-f", file}, args...))
+		err := cmd.Execute()
+		return out.String(), err
+	}
+
+	_, err = importCommand()
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("choose it with --current")))
+
+	out, err := importCommand("--current", "v2", "--app", "shop")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(out).To(gomega.ContainSubstring("kind: App"))
+	g.Expect(out).To(gomega.ContainSubstring("currentVersionName: v2"))
+}
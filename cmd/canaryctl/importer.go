@@ -0,0 +1,479 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	networkingv1 "canary-crd/pkg/networking/v1"
+	"canary-crd/pkg/render"
+
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// lastAppliedAnnotation is written by kubectl apply, it is not worth a warning
+// when it is dropped.
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// importer converts Deployments, StatefulSets, Services and Ingresses into the
+// MicroServices that create them.
+type importer struct {
+	config *configv1alpha1.ManagerConfig
+	// serviceLabel groups the workloads into MicroServices, named after its value.
+	serviceLabel string
+	// versionLabel names the versions of the workloads of a MicroService.
+	versionLabel string
+	// current is the current version of the MicroServices where it is ambiguous.
+	current string
+	// app is the App holding the MicroServices, which prefixes the names of their objects.
+	app string
+
+	workloads []*importedWorkload
+	services  []*corev1.Service
+	ingresses []*networkingv1.Ingress
+	// used records the Services and Ingresses imported into a MicroService.
+	used     map[metav1.Object]bool
+	warnings []string
+}
+
+// importedWorkload is a Deployment or a StatefulSet turned into a version.
+type importedWorkload struct {
+	meta    metav1.ObjectMeta
+	kind    appv1.WorkloadKind
+	version appv1.DeployVersion
+}
+
+func newImporter(cfg *configv1alpha1.ManagerConfig, serviceLabel, versionLabel string) *importer {
+	return &importer{
+		config:       cfg,
+		serviceLabel: serviceLabel,
+		versionLabel: versionLabel,
+		used:         make(map[metav1.Object]bool),
+	}
+}
+
+func (im *importer) warnf(format string, args ...interface{}) {
+	im.warnings = append(im.warnings, fmt.Sprintf(format, args...))
+}
+
+// add decodes one document. Kinds other than workloads, Services and Ingresses
+// are skipped with a warning.
+func (im *importer) add(doc []byte) error {
+	meta := &metav1.TypeMeta{}
+	if err := yaml.Unmarshal(doc, meta); err != nil {
+		return err
+	}
+
+	switch {
+	case meta.APIVersion == "apps/v1" && meta.Kind == "Deployment":
+		deploy := &appsv1.Deployment{}
+		if err := yaml.Unmarshal(doc, deploy); err != nil {
+			return err
+		}
+		im.workloads = append(im.workloads, &importedWorkload{
+			meta:    deploy.ObjectMeta,
+			kind:    appv1.DeploymentKind,
+			version: appv1.DeployVersion{Template: deploy.Spec},
+		})
+	case meta.APIVersion == "apps/v1" && meta.Kind == "StatefulSet":
+		sts := &appsv1.StatefulSet{}
+		if err := yaml.Unmarshal(doc, sts); err != nil {
+			return err
+		}
+		im.workloads = append(im.workloads, &importedWorkload{
+			meta:    sts.ObjectMeta,
+			kind:    appv1.StatefulSetKind,
+			version: appv1.DeployVersion{Kind: appv1.StatefulSetKind, StatefulSetTemplate: &sts.Spec},
+		})
+	case meta.APIVersion == "v1" && meta.Kind == "Service":
+		svc := &corev1.Service{}
+		if err := yaml.Unmarshal(doc, svc); err != nil {
+			return err
+		}
+		im.services = append(im.services, svc)
+	case meta.APIVersion == networkingv1.SchemeGroupVersion.String() && meta.Kind == "Ingress":
+		ingress := &networkingv1.Ingress{}
+		if err := yaml.Unmarshal(doc, ingress); err != nil {
+			return err
+		}
+		if class, ok := ingress.Annotations[networkingv1.IngressClassAnnotation]; ok && ingress.Spec.IngressClassName == nil {
+			ingress.Spec.IngressClassName = &class
+			delete(ingress.Annotations, networkingv1.IngressClassAnnotation)
+		}
+		im.ingresses = append(im.ingresses, ingress)
+	case meta.APIVersion == "extensions/v1beta1" && meta.Kind == "Ingress":
+		ingress := &extensionsv1beta1.Ingress{}
+		if err := yaml.Unmarshal(doc, ingress); err != nil {
+			return err
+		}
+		im.ingresses = append(im.ingresses, networkingv1.ConvertFromV1beta1Ingress(ingress))
+	default:
+		im.warnf("skipped %s of %s, only Deployments, StatefulSets, Services and Ingresses are imported", meta.Kind, meta.APIVersion)
+	}
+	return nil
+}
+
+// microServices groups the workloads into MicroServices, in the order the
+// workloads were read.
+func (im *importer) microServices() ([]*appv1.MicroService, error) {
+	var microServices []*appv1.MicroService
+	groups := make(map[string]*appv1.MicroService)
+	for _, workload := range im.workloads {
+		name := labelValue(workload, im.serviceLabel)
+		if name == "" {
+			return nil, fmt.Errorf("%s %s has no label %s, choose the label naming the microservices with --service-label", workload.kind, workload.meta.Name, im.serviceLabel)
+		}
+		version := labelValue(workload, im.versionLabel)
+		if version == "" {
+			return nil, fmt.Errorf("%s %s has no label %s, choose the label naming the versions with --version-label", workload.kind, workload.meta.Name, im.versionLabel)
+		}
+
+		key := workload.meta.Namespace + "/" + name
+		ms, ok := groups[key]
+		if !ok {
+			ms = &appv1.MicroService{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: workload.meta.Namespace}}
+			groups[key] = ms
+			microServices = append(microServices, ms)
+		}
+		if _, err := findVersion(&ms.Spec, version); err == nil {
+			return nil, fmt.Errorf("microservice %s has two workloads of version %s", name, version)
+		}
+
+		workload.version.Name = version
+		ms.Spec.Versions = append(ms.Spec.Versions, workload.version)
+		// The MicroServices of an App are named after the App
+		msName := name
+		if im.app != "" {
+			msName = im.config.Name(im.app, name)
+		}
+		if expected := im.config.Name(msName, version); expected != workload.meta.Name {
+			im.warnf("%s %s is created again as %s, delete it once microservice %s is running", workload.kind, workload.meta.Name, expected, msName)
+		}
+	}
+
+	for _, ms := range microServices {
+		if err := im.importLoadBalance(ms); err != nil {
+			return nil, fmt.Errorf("microservice %s: %v", ms.Name, err)
+		}
+	}
+	for _, svc := range im.services {
+		if !im.used[svc] {
+			im.warnf("skipped Service %s, it selects the pods of no imported workload", svc.Name)
+		}
+	}
+	for _, ingress := range im.ingresses {
+		if !im.used[ingress] {
+			im.warnf("skipped Ingress %s, it routes to no imported Service", ingress.Name)
+		}
+	}
+	return microServices, nil
+}
+
+// labelValue returns the value of the label of the workload, or of its pods.
+func labelValue(workload *importedWorkload, label string) string {
+	if value, ok := workload.meta.Labels[label]; ok {
+		return value
+	}
+	if template := workload.version.PodTemplate(); template != nil {
+		return template.Labels[label]
+	}
+	return ""
+}
+
+// importLoadBalance maps the Services selecting the pods of ms and the Ingresses
+// routing to them into its LoadBalance. The Services and canary Ingresses of a
+// single version become the Service name and the Canary of that version, and
+// the current version is the one the other Services select.
+func (im *importer) importLoadBalance(ms *appv1.MicroService) error {
+	// selected maps the Services selecting pods of ms to the versions they select
+	selected := make(map[string][]string)
+	var services []*corev1.Service
+	for _, svc := range im.services {
+		if svc.Namespace != ms.Namespace || len(svc.Spec.Selector) == 0 {
+			continue
+		}
+		for _, version := range ms.Spec.Versions {
+			if selects(svc.Spec.Selector, version.PodTemplate().Labels) {
+				selected[svc.Name] = append(selected[svc.Name], version.Name)
+			}
+		}
+		if len(selected[svc.Name]) != 0 {
+			services = append(services, svc)
+			im.used[svc] = true
+		}
+	}
+
+	// canary Ingresses routing to a Service of a single version
+	canaryServices := make(map[string]bool)
+	for _, ingress := range im.ingresses {
+		if ingress.Namespace != ms.Namespace || ingress.Annotations[im.config.NginxAnnotation("canary")] != "true" {
+			continue
+		}
+		for _, name := range backendServices(&ingress.Spec) {
+			if len(selected[name]) != 1 {
+				continue
+			}
+			version, _ := findVersion(&ms.Spec, selected[name][0])
+			version.Canary = im.importCanary(ingress)
+			version.ServiceName = name
+			canaryServices[name] = true
+			im.used[ingress] = true
+			break
+		}
+	}
+
+	lb := &appv1.LoadBalance{}
+	for _, svc := range services {
+		if canaryServices[svc.Name] {
+			continue
+		}
+		if len(selected[svc.Name]) == 1 && ms.Spec.CurrentVersionName == "" {
+			ms.Spec.CurrentVersionName = selected[svc.Name][0]
+		}
+		spec := svc.Spec.DeepCopy()
+		// the controller selects the pods of the current version
+		spec.Selector = nil
+		if spec.ClusterIP != corev1.ClusterIPNone {
+			spec.ClusterIP = ""
+		}
+		svcLB := appv1.ServiceLoadBalance{Name: svc.Name, Spec: *spec}
+		if lb.Service == nil {
+			lb.Service = &svcLB
+		} else {
+			lb.Services = append(lb.Services, svcLB)
+		}
+	}
+
+	for _, ingress := range im.ingresses {
+		if ingress.Namespace != ms.Namespace || im.used[ingress] {
+			continue
+		}
+		routed := false
+		for _, name := range backendServices(&ingress.Spec) {
+			if len(selected[name]) != 0 && !canaryServices[name] {
+				routed = true
+			}
+		}
+		if !routed {
+			continue
+		}
+		im.used[ingress] = true
+		ingressLB := im.importIngress(ingress)
+		if lb.Ingress == nil {
+			lb.Ingress = &ingressLB
+		} else {
+			lb.Ingresses = append(lb.Ingresses, ingressLB)
+		}
+	}
+	if lb.Service != nil || len(lb.Services) != 0 || lb.Ingress != nil || len(lb.Ingresses) != 0 {
+		ms.Spec.LoadBalance = lb
+	}
+
+	if ms.Spec.CurrentVersionName == "" {
+		var stable []string
+		for _, version := range ms.Spec.Versions {
+			if version.Canary == nil {
+				stable = append(stable, version.Name)
+			}
+		}
+		switch {
+		case len(stable) == 1:
+			ms.Spec.CurrentVersionName = stable[0]
+		case im.current != "":
+			if _, err := findVersion(&ms.Spec, im.current); err != nil {
+				return err
+			}
+			ms.Spec.CurrentVersionName = im.current
+		default:
+			return fmt.Errorf("the current version is one of %s, choose it with --current", strings.Join(stable, ", "))
+		}
+	}
+	// the current version gets all the traffic the canary versions leave
+	if version, _ := findVersion(&ms.Spec, ms.Spec.CurrentVersionName); version.Canary != nil {
+		im.warnf("dropped the canary settings of version %s of microservice %s, it is the current version", version.Name, ms.Name)
+		version.Canary = nil
+	}
+	return nil
+}
+
+// importCanary maps the nginx canary annotations of ingress to a Canary.
+func (im *importer) importCanary(ingress *networkingv1.Ingress) *appv1.Canary {
+	annotations := ingress.Annotations
+	canary := &appv1.Canary{
+		CanaryIngressName: ingress.Name,
+		Header:            annotations[im.config.NginxAnnotation("canary-by-header")],
+		HeaderValue:       annotations[im.config.NginxAnnotation("canary-by-header-value")],
+		Cookie:            annotations[im.config.NginxAnnotation("canary-by-cookie")],
+	}
+	weight, err := strconv.Atoi(annotations[im.config.NginxAnnotation("canary-weight")])
+	if err != nil || weight < 1 {
+		im.warnf("canary Ingress %s has no canary weight, the imported Canary sends 1%% of the traffic to its version", ingress.Name)
+		weight = 1
+	}
+	canary.Weight = weight
+	return canary
+}
+
+// importIngress maps ingress to an IngressLoadBalance. The cert-manager issuer
+// annotations become its TLS settings, other annotations are dropped.
+func (im *importer) importIngress(ingress *networkingv1.Ingress) appv1.IngressLoadBalance {
+	ingressLB := appv1.IngressLoadBalance{Name: ingress.Name, Spec: *ingress.Spec.DeepCopy()}
+	var dropped []string
+	for key, value := range ingress.Annotations {
+		switch key {
+		case render.CertManagerIssuerAnnotation:
+			ingressLB.TLS = importTLS(ingressLB.TLS, &ingressLB.Spec)
+			ingressLB.TLS.Issuer = value
+		case render.CertManagerClusterIssuerAnnotation:
+			ingressLB.TLS = importTLS(ingressLB.TLS, &ingressLB.Spec)
+			ingressLB.TLS.ClusterIssuer = value
+		case lastAppliedAnnotation:
+		default:
+			dropped = append(dropped, key)
+		}
+	}
+	if len(dropped) != 0 {
+		sort.Strings(dropped)
+		im.warnf("dropped the annotations %s of Ingress %s", strings.Join(dropped, ", "), ingress.Name)
+	}
+	return ingressLB
+}
+
+// importTLS returns tls, or new TLS settings keeping the certificate Secret of spec.
+func importTLS(tls *appv1.IngressTLS, spec *networkingv1.IngressSpec) *appv1.IngressTLS {
+	if tls != nil {
+		return tls
+	}
+	tls = &appv1.IngressTLS{}
+	if len(spec.TLS) != 0 {
+		tls.SecretName = spec.TLS[0].SecretName
+	}
+	return tls
+}
+
+// selects reports whether selector selects the pods with labels.
+func selects(selector, labels map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// backendServices returns the names of the Services spec routes to.
+func backendServices(spec *networkingv1.IngressSpec) []string {
+	var names []string
+	add := func(backend *networkingv1.IngressBackend) {
+		if backend != nil && backend.Service != nil && !containsString(names, backend.Service.Name) {
+			names = append(names, backend.Service.Name)
+		}
+	}
+	add(spec.DefaultBackend)
+	for _, rule := range spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for i := range rule.HTTP.Paths {
+			add(&rule.HTTP.Paths[i].Backend)
+		}
+	}
+	return names
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func newImportCommand(o *options) *cobra.Command {
+	var files []string
+	im := newImporter(nil, "", "")
+	cmd := &cobra.Command{
+		Use:   "import -f FILE",
+		Short: "Convert Deployments, Services and Ingresses into MicroServices or an App",
+		Long: `Convert plain Deployments, StatefulSets, Services and Ingresses into the
+MicroServices that create them, or into an App holding those MicroServices.
+
+Workloads are grouped into MicroServices by --service-label and into versions
+by --version-label. The Services selecting the pods of the current version
+become the LoadBalance Services, and the Ingresses routing to them the
+LoadBalance Ingresses. An nginx canary Ingress routing to the Service of a
+single version becomes the Canary of that version, keeping the names of the
+Ingress and of the Service. Warnings about what is not imported are written to
+the standard error.`,
+		Example: `  kubectl get deployments,services,ingresses -l app=web -o yaml | canaryctl import -f -
+  canaryctl import -f manifests/ --app shop --version-label track`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.loadConfig(); err != nil {
+				return err
+			}
+			im.config = o.config
+			if err := readDocuments(files, im.add); err != nil {
+				return err
+			}
+			microServices, err := im.microServices()
+			for _, warning := range im.warnings {
+				fmt.Fprintln(cmd.ErrOrStderr(), "Warning:", warning)
+			}
+			if err != nil {
+				return err
+			}
+			if len(microServices) == 0 {
+				return fmt.Errorf("found no Deployment or StatefulSet to import")
+			}
+
+			var objects []render.Object
+			if im.app != "" {
+				imported := &appv1.App{ObjectMeta: metav1.ObjectMeta{Name: im.app, Namespace: microServices[0].Namespace}}
+				for _, ms := range microServices {
+					if ms.Namespace != imported.Namespace {
+						return fmt.Errorf("microservices %s and %s of app %s are in different namespaces", microServices[0].Name, ms.Name, im.app)
+					}
+					imported.Spec.MicroServices = append(imported.Spec.MicroServices, appv1.MicroServiceTemplate{Name: ms.Name, Spec: ms.Spec})
+				}
+				objects = append(objects, imported)
+			} else {
+				for _, ms := range microServices {
+					objects = append(objects, ms)
+				}
+			}
+			return render.Encode(o.out, o.scheme, objects)
+		},
+	}
+	addFileFlag(cmd, &files, "Files containing the Deployments, StatefulSets, Services and Ingresses to import.")
+	cmd.Flags().StringVar(&im.app, "app", "", "Emit an App with this name holding the MicroServices, instead of the MicroServices.")
+	cmd.Flags().StringVar(&im.serviceLabel, "service-label", "app", "The label whose value names the MicroService of a workload.")
+	cmd.Flags().StringVar(&im.versionLabel, "version-label", "version", "The label whose value names the version of a workload.")
+	cmd.Flags().StringVar(&im.current, "current", "", "The current version of the MicroServices whose Services select the pods of several versions.")
+	return cmd
+}
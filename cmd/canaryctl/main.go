@@ -0,0 +1,264 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// canaryctl operates the releases of MicroServices and Apps: it shows the
+// versions and their weights, and changes them by name instead of by position
+// in the spec. It also renders the objects the controllers create for Apps and
+// MicroServices, compares them with the cluster, and imports plain workloads,
+// Services and Ingresses or docker-compose files into MicroServices and Apps.
+// Installed as kubectl-canary, it runs as the kubectl plugin "kubectl canary".
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"canary-crd/pkg/apis"
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+func main() {
+	if err := newRootCommand(filepath.Base(os.Args[0]), os.Stdout).Execute(); err != nil {
+		if err != errDiffer {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// options are the flags shared by all the commands and the clients built from them.
+type options struct {
+	kubeconfig  string
+	context     string
+	namespace   string
+	configFile  string
+	out         io.Writer
+	scheme      *runtime.Scheme
+	restConfig  *rest.Config
+	client      client.Client
+	mapper      meta.RESTMapper
+	config      *configv1alpha1.ManagerConfig
+	initialized bool
+}
+
+// newRootCommand returns the canaryctl command, named after the binary when it
+// runs as a kubectl plugin.
+func newRootCommand(binary string, out io.Writer) *cobra.Command {
+	o := &options{out: out, scheme: newScheme()}
+	use := "canaryctl"
+	if strings.HasPrefix(binary, "kubectl-") {
+		use = binary
+	}
+	cmd := &cobra.Command{
+		Use:           use,
+		Short:         "Operate the releases of MicroServices and Apps",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	flags := cmd.PersistentFlags()
+	flags.StringVar(&o.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file.")
+	flags.StringVar(&o.context, "context", "", "The kubeconfig context to use.")
+	flags.StringVarP(&o.namespace, "namespace", "n", "", "The namespace of the MicroService or App, defaults to the namespace of the context.")
+	flags.StringVar(&o.configFile, "config", "", "The manager configuration file the objects are named by, the defaults are used when empty.")
+
+	cmd.AddCommand(
+		newStatusCommand(o),
+		newSetWeightCommand(o),
+		newPromoteCommand(o),
+		newAbortCommand(o),
+		newRollbackCommand(o),
+		newPauseCommand(o, true),
+		newPauseCommand(o, false),
+		newHistoryCommand(o),
+		newRenderCommand(o),
+		newDiffCommand(o),
+		newImportCommand(o),
+		newImportComposeCommand(o),
+	)
+	return cmd
+}
+
+// init builds the client from the flags.
+func (o *options) init() error {
+	if o.initialized {
+		return nil
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: o.context}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+	var err error
+	if o.restConfig, err = clientConfig.ClientConfig(); err != nil {
+		return err
+	}
+	if o.namespace == "" {
+		if o.namespace, _, err = clientConfig.Namespace(); err != nil {
+			return err
+		}
+	}
+	if o.mapper, err = apiutil.NewDiscoveryRESTMapper(o.restConfig); err != nil {
+		return err
+	}
+	if o.client, err = client.New(o.restConfig, client.Options{Scheme: o.scheme, Mapper: o.mapper}); err != nil {
+		return err
+	}
+	if err := o.loadConfig(); err != nil {
+		return err
+	}
+	o.initialized = true
+	return nil
+}
+
+// loadConfig loads the manager configuration the objects are named by.
+func (o *options) loadConfig() error {
+	o.config = configv1alpha1.Default()
+	if o.configFile == "" {
+		return nil
+	}
+	var err error
+	o.config, err = configv1alpha1.Load(o.configFile)
+	return err
+}
+
+// newScheme returns the scheme of the objects canaryctl reads and renders.
+func newScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	_ = kubescheme.AddToScheme(scheme)
+	_ = apis.AddToScheme(scheme)
+	return scheme
+}
+
+// Kinds of the targets of the commands.
+const (
+	microServiceKind = "microservice"
+	appKind          = "app"
+)
+
+// target is the MicroService or App a command operates on.
+type target struct {
+	kind string
+	name string
+}
+
+func (t target) String() string {
+	return t.kind + "/" + t.name
+}
+
+// parseTarget parses a target of the form microservice/NAME or app/NAME.
+func parseTarget(arg string) (target, error) {
+	parts := strings.SplitN(arg, "/", 2)
+	if len(parts) == 2 && parts[1] != "" {
+		switch strings.ToLower(parts[0]) {
+		case "microservice", "microservices", "ms":
+			return target{kind: microServiceKind, name: parts[1]}, nil
+		case "app", "apps":
+			return target{kind: appKind, name: parts[1]}, nil
+		}
+	}
+	return target{}, fmt.Errorf("%q is neither microservice/NAME nor app/NAME", arg)
+}
+
+// mutation changes a MicroService or an App, and returns the history entry
+// describing the change. A nil function means the operation does not apply
+// to that kind.
+type mutation struct {
+	microService func(ms *appv1.MicroService) (historyEntry, error)
+	app          func(app *appv1.App) (historyEntry, error)
+}
+
+// apply gets the target, changes it with m, records the change in its history
+// and updates it, retrying on conflicts.
+func (o *options) apply(action string, t target, m mutation) error {
+	var entry historyEntry
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		var obj runtime.Object
+		var err error
+		switch t.kind {
+		case microServiceKind:
+			if m.microService == nil {
+				return fmt.Errorf("%s does not apply to microservices", action)
+			}
+			ms := &appv1.MicroService{}
+			if err := o.get(t.name, ms); err != nil {
+				return err
+			}
+			if err := checkNotOwnedByApp(o.config, ms); err != nil {
+				return err
+			}
+			entry, err = m.microService(ms)
+			obj = ms
+		case appKind:
+			if m.app == nil {
+				return fmt.Errorf("%s does not apply to apps", action)
+			}
+			app := &appv1.App{}
+			if err := o.get(t.name, app); err != nil {
+				return err
+			}
+			entry, err = m.app(app)
+			obj = app
+		}
+		if err != nil {
+			return err
+		}
+
+		entry.Action = action
+		entry.Time = metav1.Now()
+		if err := recordHistory(obj.(metav1.Object), entry); err != nil {
+			return err
+		}
+		return o.client.Update(context.TODO(), obj)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(o.out, "%s %s: %s\n", t, action, entry.details())
+	return nil
+}
+
+// checkNotOwnedByApp fails when ms is one of the MicroServices of an App, whose
+// controller would revert the change to the spec of ms.
+func checkNotOwnedByApp(cfg *configv1alpha1.ManagerConfig, ms *appv1.MicroService) error {
+	owner := metav1.GetControllerOf(ms)
+	if owner == nil || owner.Kind != "App" {
+		return nil
+	}
+	name := strings.TrimPrefix(ms.Name, cfg.Name(owner.Name, ""))
+	return fmt.Errorf("microservice %q belongs to app %q, which would revert the change, operate on app/%s -m %s instead", ms.Name, owner.Name, owner.Name, name)
+}
+
+// get reads the object with the name from the namespace of o.
+func (o *options) get(name string, obj runtime.Object) error {
+	return o.client.Get(context.TODO(), types.NamespacedName{Namespace: o.namespace, Name: name}, obj)
+}
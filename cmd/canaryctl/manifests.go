@@ -0,0 +1,249 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	appv2 "canary-crd/pkg/apis/app/v2"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	"canary-crd/pkg/render"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// manifests are the objects read from the files given with -f.
+type manifests struct {
+	apps          []*appv1.App
+	microServices []*appv1.MicroService
+	// inputs are the ConfigMaps and Secrets of the files, the configuration
+	// the MicroServices reference.
+	inputs render.Inputs
+}
+
+// readManifests reads Apps, MicroServices, ConfigMaps and Secrets from the files.
+// Objects without a namespace are put in namespace.
+func readManifests(files []string, namespace string) (*manifests, error) {
+	m := &manifests{inputs: render.Inputs{
+		ConfigMaps: make(map[string]*corev1.ConfigMap),
+		Secrets:    make(map[string]*corev1.Secret),
+	}}
+	err := readDocuments(files, func(doc []byte) error {
+		return m.add(doc, namespace)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// add decodes one document into m. v2 objects are converted to v1.
+func (m *manifests) add(doc []byte, namespace string) error {
+	meta := &metav1.TypeMeta{}
+	if err := yaml.Unmarshal(doc, meta); err != nil {
+		return err
+	}
+	group := strings.SplitN(meta.APIVersion, "/", 2)[0]
+
+	var obj metav1.Object
+	switch {
+	case meta.APIVersion == appv1.SchemeGroupVersion.String() && meta.Kind == "App":
+		app := &appv1.App{}
+		if err := yaml.UnmarshalStrict(doc, app); err != nil {
+			return err
+		}
+		m.apps = append(m.apps, app)
+		obj = app
+	case meta.APIVersion == appv2.SchemeGroupVersion.String() && meta.Kind == "App":
+		src, app := &appv2.App{}, &appv1.App{}
+		if err := yaml.UnmarshalStrict(doc, src); err != nil {
+			return err
+		}
+		if err := src.ConvertTo(app); err != nil {
+			return err
+		}
+		m.apps = append(m.apps, app)
+		obj = app
+	case meta.APIVersion == appv1.SchemeGroupVersion.String() && meta.Kind == "MicroService":
+		ms := &appv1.MicroService{}
+		if err := yaml.UnmarshalStrict(doc, ms); err != nil {
+			return err
+		}
+		m.microServices = append(m.microServices, ms)
+		obj = ms
+	case meta.APIVersion == appv2.SchemeGroupVersion.String() && meta.Kind == "MicroService":
+		src, ms := &appv2.MicroService{}, &appv1.MicroService{}
+		if err := yaml.UnmarshalStrict(doc, src); err != nil {
+			return err
+		}
+		if err := src.ConvertTo(ms); err != nil {
+			return err
+		}
+		m.microServices = append(m.microServices, ms)
+		obj = ms
+	case meta.APIVersion == "v1" && meta.Kind == "ConfigMap":
+		cm := &corev1.ConfigMap{}
+		if err := yaml.UnmarshalStrict(doc, cm); err != nil {
+			return err
+		}
+		m.inputs.ConfigMaps[cm.Name] = cm
+		obj = cm
+	case meta.APIVersion == "v1" && meta.Kind == "Secret":
+		secret := &corev1.Secret{}
+		if err := yaml.UnmarshalStrict(doc, secret); err != nil {
+			return err
+		}
+		// the API server moves stringData into data on write
+		for k, v := range secret.StringData {
+			if secret.Data == nil {
+				secret.Data = make(map[string][]byte)
+			}
+			secret.Data[k] = []byte(v)
+		}
+		secret.StringData = nil
+		m.inputs.Secrets[secret.Name] = secret
+		obj = secret
+	case group == appv1.SchemeGroupVersion.Group:
+		return fmt.Errorf("unsupported kind %s of %s", meta.Kind, meta.APIVersion)
+	default:
+		// other objects of the manifests are not rendered by the controllers
+		return nil
+	}
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(namespace)
+	}
+	return nil
+}
+
+// selectedByNetworkPolicy reports whether the NetworkPolicies rendered for the
+// files select the pods of ms, microServices being all the MicroServices rendered.
+func (m *manifests) selectedByNetworkPolicy(cfg *configv1alpha1.ManagerConfig, ms *appv1.MicroService, microServices []*appv1.MicroService) bool {
+	var app *appv1.App
+	if name, ok := ms.Labels[cfg.AppLabel()]; ok {
+		for _, a := range m.apps {
+			if a.Name == name && a.Namespace == ms.Namespace {
+				app = a
+			}
+		}
+	}
+	return render.SelectedByNetworkPolicy(cfg, ms, app, microServices)
+}
+
+// addFileFlag adds the flag naming the manifest files, usage describes their content.
+func addFileFlag(cmd *cobra.Command, files *[]string, usage string) {
+	cmd.Flags().StringSliceVarP(files, "filename", "f", nil, usage+" Directories are read file by file, - reads the standard input.")
+	_ = cmd.MarkFlagRequired("filename")
+}
+
+// readDocuments calls fn with every document of the YAML or JSON files. The files
+// of a directory with a .yaml, .yml or .json extension are read, "-" reads the
+// standard input. The items of a List are passed one by one.
+func readDocuments(files []string, fn func(doc []byte) error) error {
+	for _, file := range files {
+		if file == "-" {
+			if err := readStream(os.Stdin, fn); err != nil {
+				return fmt.Errorf("stdin: %v", err)
+			}
+			continue
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		paths := []string{file}
+		if info.IsDir() {
+			entries, err := ioutil.ReadDir(file)
+			if err != nil {
+				return err
+			}
+			paths = nil
+			for _, entry := range entries {
+				switch filepath.Ext(entry.Name()) {
+				case ".yaml", ".yml", ".json":
+					if !entry.IsDir() {
+						paths = append(paths, filepath.Join(file, entry.Name()))
+					}
+				}
+			}
+		}
+		for _, path := range paths {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			err = readStream(f, fn)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// readStream calls fn with every document of r.
+func readStream(r io.Reader, fn func(doc []byte) error) error {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		if err := readDocument(doc, fn); err != nil {
+			return err
+		}
+	}
+}
+
+// readDocument calls fn with doc, or with each of its items when it is a List.
+func readDocument(doc []byte, fn func(doc []byte) error) error {
+	list := &struct {
+		metav1.TypeMeta `json:",inline"`
+		Items           []runtime.RawExtension `json:"items"`
+	}{}
+	if err := yaml.Unmarshal(doc, list); err != nil {
+		return err
+	}
+	if list.APIVersion != "v1" || list.Kind != "List" {
+		return fn(doc)
+	}
+	for _, item := range list.Items {
+		if err := fn(item.Raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+)
+
+// findVersion returns the version of spec with the name.
+func findVersion(spec *appv1.MicroServiceSpec, name string) (*appv1.DeployVersion, error) {
+	for i := range spec.Versions {
+		if spec.Versions[i].Name == name {
+			return &spec.Versions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("version %q not found", name)
+}
+
+// canaryVersions returns the names of the versions of spec that have a Canary.
+func canaryVersions(spec *appv1.MicroServiceSpec) []string {
+	var names []string
+	for _, version := range spec.Versions {
+		if version.Name != spec.CurrentVersionName && version.Canary != nil {
+			names = append(names, version.Name)
+		}
+	}
+	return names
+}
+
+// setWeight sets the canary weight of the version of spec with the name. A weight
+// of 0 removes the Canary of the version so that it receives no traffic.
+func setWeight(spec *appv1.MicroServiceSpec, name string, weight int) error {
+	if weight < 0 || weight > 100 {
+		return fmt.Errorf("weight %d is not between 0 and 100", weight)
+	}
+	if name == spec.CurrentVersionName {
+		return fmt.Errorf("version %q is the current version, it receives the traffic the canary versions leave", name)
+	}
+	version, err := findVersion(spec, name)
+	if err != nil {
+		return err
+	}
+
+	total := weight
+	for _, other := range spec.Versions {
+		if other.Name != name && other.Name != spec.CurrentVersionName && other.Canary != nil {
+			total += other.Canary.Weight
+		}
+	}
+	if total > 100 {
+		return fmt.Errorf("the canary weights would add up to %d", total)
+	}
+
+	if weight == 0 {
+		version.Canary = nil
+		return nil
+	}
+	if version.Canary == nil {
+		version.Canary = &appv1.Canary{}
+	}
+	version.Canary.Weight = weight
+	return nil
+}
+
+// promote makes the version with the name the current version of spec and removes
+// its Canary. An empty name promotes the only canary version. It returns the
+// version that was current before.
+func promote(spec *appv1.MicroServiceSpec, name string) (string, error) {
+	if name == "" {
+		canaries := canaryVersions(spec)
+		if len(canaries) != 1 {
+			return "", fmt.Errorf("there are %d canary versions, name the version to promote", len(canaries))
+		}
+		name = canaries[0]
+	}
+	if name == spec.CurrentVersionName {
+		return "", fmt.Errorf("version %q is already the current version", name)
+	}
+	version, err := findVersion(spec, name)
+	if err != nil {
+		return "", err
+	}
+
+	previous := spec.CurrentVersionName
+	spec.CurrentVersionName = name
+	version.Canary = nil
+	return previous, nil
+}
+
+// abort removes the Canary of every canary version of spec, so that the current
+// version receives all the traffic. It returns the names of those versions.
+func abort(spec *appv1.MicroServiceSpec) ([]string, error) {
+	canaries := canaryVersions(spec)
+	if len(canaries) == 0 {
+		return nil, fmt.Errorf("there is no canary version to abort")
+	}
+	for _, name := range canaries {
+		version, _ := findVersion(spec, name)
+		version.Canary = nil
+	}
+	return canaries, nil
+}
+
+// rollback makes the version with the name the current version of spec again
+// and removes the Canary of every version. It returns the version that was
+// current before.
+func rollback(spec *appv1.MicroServiceSpec, name string) (string, error) {
+	if name == "" {
+		return "", fmt.Errorf("there is no previous version to roll back to, name the version")
+	}
+	if name == spec.CurrentVersionName {
+		return "", fmt.Errorf("version %q is already the current version", name)
+	}
+	if _, err := findVersion(spec, name); err != nil {
+		return "", err
+	}
+
+	previous := spec.CurrentVersionName
+	spec.CurrentVersionName = name
+	for i := range spec.Versions {
+		spec.Versions[i].Canary = nil
+	}
+	return previous, nil
+}
+
+// appMicroService returns the spec of the MicroService of app with the name.
+func appMicroService(app *appv1.App, name string) (*appv1.MicroServiceSpec, error) {
+	for i := range app.Spec.MicroServices {
+		if app.Spec.MicroServices[i].Name == name {
+			return &app.Spec.MicroServices[i].Spec, nil
+		}
+	}
+	return nil, fmt.Errorf("microservice %q not found in app %q", name, app.Name)
+}
+
+// checkNotInRelease fails when the MicroService of app with the name takes part
+// in the release of app, whose steps drive its Canary.
+func checkNotInRelease(app *appv1.App, name string) error {
+	release := app.Spec.Release
+	if release == nil {
+		return nil
+	}
+	for _, p := range release.Participants {
+		if p.MicroService == name {
+			return fmt.Errorf("microservice %q takes part in release %q, operate on the release instead", name, release.Name)
+		}
+	}
+	return nil
+}
+
+// promoteRelease makes the target version of every participant of the release
+// of app the current version and removes the release from app.
+func promoteRelease(app *appv1.App) ([]versionChange, error) {
+	release := app.Spec.Release
+	if release == nil {
+		return nil, fmt.Errorf("app %q has no release, name the microservice", app.Name)
+	}
+	var changes []versionChange
+	for _, p := range release.Participants {
+		spec, err := appMicroService(app, p.MicroService)
+		if err != nil {
+			return nil, err
+		}
+		previous, err := promote(spec, p.Version)
+		if err != nil {
+			return nil, fmt.Errorf("microservice %q: %v", p.MicroService, err)
+		}
+		changes = append(changes, versionChange{MicroService: p.MicroService, Version: p.Version, Previous: previous})
+	}
+	app.Spec.Release = nil
+	return changes, nil
+}
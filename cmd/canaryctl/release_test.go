@@ -0,0 +1,163 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	"github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newSpec() *appv1.MicroServiceSpec {
+	return &appv1.MicroServiceSpec{
+		CurrentVersionName: "v1",
+		Versions: []appv1.DeployVersion{
+			{Name: "v1"},
+			{Name: "v2", Canary: &appv1.Canary{Weight: 20, Header: "canary"}},
+			{Name: "v3"},
+		},
+	}
+}
+
+func TestSetWeight(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	spec := newSpec()
+	g.Expect(setWeight(spec, "v2", 50)).NotTo(gomega.HaveOccurred())
+	g.Expect(spec.Versions[1].Canary).To(gomega.Equal(&appv1.Canary{Weight: 50, Header: "canary"}))
+	g.Expect(setWeight(spec, "v3", 30)).NotTo(gomega.HaveOccurred())
+	g.Expect(spec.Versions[2].Canary).To(gomega.Equal(&appv1.Canary{Weight: 30}))
+	g.Expect(spec.Weights()).To(gomega.Equal(map[string]int{"v1": 20, "v2": 50, "v3": 30}))
+
+	g.Expect(setWeight(spec, "v3", 60)).To(gomega.HaveOccurred())
+	g.Expect(setWeight(spec, "v1", 10)).To(gomega.HaveOccurred())
+	g.Expect(setWeight(spec, "v4", 10)).To(gomega.HaveOccurred())
+	g.Expect(setWeight(spec, "v2", 101)).To(gomega.HaveOccurred())
+
+	g.Expect(setWeight(spec, "v3", 0)).NotTo(gomega.HaveOccurred())
+	g.Expect(spec.Versions[2].Canary).To(gomega.BeNil())
+}
+
+func TestPromoteAbortRollback(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	spec := newSpec()
+	previous, err := promote(spec, "")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(previous).To(gomega.Equal("v1"))
+	g.Expect(spec.CurrentVersionName).To(gomega.Equal("v2"))
+	g.Expect(spec.Versions[1].Canary).To(gomega.BeNil())
+	_, err = promote(spec, "v2")
+	g.Expect(err).To(gomega.HaveOccurred())
+	_, err = abort(spec)
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	g.Expect(setWeight(spec, "v3", 10)).NotTo(gomega.HaveOccurred())
+	previous, err = rollback(spec, "v1")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(previous).To(gomega.Equal("v2"))
+	g.Expect(spec.CurrentVersionName).To(gomega.Equal("v1"))
+	g.Expect(canaryVersions(spec)).To(gomega.BeEmpty())
+	_, err = rollback(spec, "")
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	spec = newSpec()
+	aborted, err := abort(spec)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(aborted).To(gomega.Equal([]string{"v2"}))
+	g.Expect(spec.CurrentVersionName).To(gomega.Equal("v1"))
+	g.Expect(canaryVersions(spec)).To(gomega.BeEmpty())
+}
+
+func TestPromoteRelease(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app := &appv1.App{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop"},
+		Spec: appv1.AppSpec{
+			MicroServices: []appv1.MicroServiceTemplate{
+				{Name: "web", Spec: *newSpec()},
+				{Name: "api", Spec: *newSpec()},
+			},
+			Release: &appv1.AppRelease{
+				Name:         "r1",
+				Participants: []appv1.ReleaseParticipant{{MicroService: "web", Version: "v3"}},
+				Steps:        []appv1.ReleaseStep{{Weight: 50}},
+			},
+		},
+	}
+	_, err := appVersionSpec(app, "web")
+	g.Expect(err).To(gomega.HaveOccurred())
+	_, err = appVersionSpec(app, "api")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	changes, err := promoteRelease(app)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(changes).To(gomega.Equal([]versionChange{{MicroService: "web", Version: "v3", Previous: "v1"}}))
+	g.Expect(app.Spec.Release).To(gomega.BeNil())
+	g.Expect(app.Spec.MicroServices[0].Spec.CurrentVersionName).To(gomega.Equal("v3"))
+	g.Expect(app.Spec.MicroServices[1].Spec.CurrentVersionName).To(gomega.Equal("v1"))
+
+	_, err = promoteRelease(app)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestAppOwnedMicroService(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := newScheme()
+	isController := true
+	owned := &appv1.MicroService{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop-web", Namespace: "default", OwnerReferences: []metav1.OwnerReference{
+			{APIVersion: appv1.SchemeGroupVersion.String(), Kind: "App", Name: "shop", UID: "1", Controller: &isController},
+		}},
+		Spec: *newSpec(),
+	}
+	standalone := &appv1.MicroService{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}, Spec: *newSpec()}
+	o := &options{
+		out:         &bytes.Buffer{},
+		scheme:      scheme,
+		namespace:   "default",
+		client:      fake.NewFakeClientWithScheme(scheme, owned, standalone),
+		config:      configv1alpha1.Default(),
+		initialized: true,
+	}
+	setWeight := func(target string) error {
+		cmd := newSetWeightCommand(o)
+		cmd.SilenceUsage, cmd.SilenceErrors = true, true
+		cmd.SetArgs([]string{target, "v2", "50"})
+		return cmd.Execute()
+	}
+
+	// The App controller would revert the change, the App is pointed to instead
+	err := setWeight("microservice/shop-web")
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(err.Error()).To(gomega.ContainSubstring("app/shop -m web"))
+	ms := &appv1.MicroService{}
+	g.Expect(o.get("shop-web", ms)).NotTo(gomega.HaveOccurred())
+	g.Expect(ms.Spec.Versions[1].Canary.Weight).To(gomega.Equal(20))
+
+	g.Expect(setWeight("microservice/web")).NotTo(gomega.HaveOccurred())
+	g.Expect(o.get("web", ms)).NotTo(gomega.HaveOccurred())
+	g.Expect(ms.Spec.Versions[1].Canary.Weight).To(gomega.Equal(50))
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	appcontroller "canary-crd/pkg/controller/app"
+	microservicecontroller "canary-crd/pkg/controller/microservice"
+	"canary-crd/pkg/render"
+
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// errDiffer is returned by diff when the cluster differs from the manifests,
+// canaryctl then exits with status 1 like kubectl diff.
+var errDiffer = errors.New("the cluster differs from the manifests")
+
+func newRenderCommand(o *options) *cobra.Command {
+	var files []string
+	cmd := &cobra.Command{
+		Use:   "render -f FILE",
+		Short: "Print the objects the controllers create for Apps and MicroServices, without a cluster",
+		Long: `Print the objects the controllers create for the Apps and MicroServices of
+the files, without a cluster. The MicroServices of an App are printed with
+the objects they create in turn. Objects without a namespace are rendered in
+the namespace given with -n, or in the default namespace.`,
+		Example: "  canaryctl render -f config/samples/app_v1_app.yaml",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.loadConfig(); err != nil {
+				return err
+			}
+			namespace := o.namespace
+			if namespace == "" {
+				namespace = metav1.NamespaceDefault
+			}
+			m, err := readManifests(files, namespace)
+			if err != nil {
+				return err
+			}
+
+			var objects []render.Object
+			microServices := m.microServices
+			for _, app := range m.apps {
+				appObjects, err := render.App(o.config, app)
+				if err != nil {
+					return fmt.Errorf("app %s: %v", app.Name, err)
+				}
+				objects = append(objects, appObjects.Objects()...)
+				microServices = append(microServices, appObjects.MicroServices...)
+			}
+			for _, ms := range microServices {
+				inputs := m.inputs
+				inputs.SelectedByNetworkPolicy = m.selectedByNetworkPolicy(o.config, ms, microServices)
+				msObjects, err := render.MicroService(o.config, ms, &inputs)
+				if err != nil {
+					return fmt.Errorf("microservice %s: %v", ms.Name, err)
+				}
+				objects = append(objects, msObjects.Objects()...)
+			}
+			return render.Encode(o.out, o.scheme, objects)
+		},
+	}
+	addFileFlag(cmd, &files, "Files containing Apps and MicroServices, and the ConfigMaps and Secrets they reference.")
+	return cmd
+}
+
+func newDiffCommand(o *options) *cobra.Command {
+	var files []string
+	cmd := &cobra.Command{
+		Use:   "diff -f FILE",
+		Short: "Compare the objects rendered for Apps and MicroServices with the cluster",
+		Long: `Compare the objects the controllers would create for the Apps and MicroServices
+of the files with the objects in the cluster, the way a MicroService in
+ReportOnly mode does: the MicroServices of the Apps, and the workloads,
+Services and Ingresses of the MicroServices are compared, nothing is changed.
+ConfigMaps and Secrets of the files take precedence over those in the cluster.
+canaryctl exits with status 1 when the cluster differs.`,
+		Example: "  canaryctl diff -f app.yaml",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := o.init(); err != nil {
+				return err
+			}
+			m, err := readManifests(files, o.namespace)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(o.out, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "NAMESPACE\tKIND\tNAME\tACTION\tFIELDS")
+			differ := false
+			write := func(namespace string, drift []appv1.DriftedObject) {
+				for _, d := range drift {
+					differ = true
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", namespace, d.Kind, d.Name, d.Action, strings.Join(d.Fields, ","))
+				}
+			}
+
+			microServices := m.microServices
+			for _, app := range m.apps {
+				if err := o.copyUID(app); err != nil {
+					return err
+				}
+				appObjects, err := render.App(o.config, app)
+				if err != nil {
+					return fmt.Errorf("app %s: %v", app.Name, err)
+				}
+				drift, err := appcontroller.Drift(o.client, o.scheme, o.config, app, appObjects)
+				if err != nil {
+					return err
+				}
+				write(app.Namespace, drift)
+				microServices = append(microServices, appObjects.MicroServices...)
+			}
+			for _, ms := range microServices {
+				if err := o.copyUID(ms); err != nil {
+					return err
+				}
+				inputs, err := microservicecontroller.ReadInputs(o.client, o.config, ms)
+				if err != nil {
+					return err
+				}
+				if m.selectedByNetworkPolicy(o.config, ms, microServices) {
+					inputs.SelectedByNetworkPolicy = true
+				}
+				for name, cm := range m.inputs.ConfigMaps {
+					inputs.ConfigMaps[name] = cm
+				}
+				for name, secret := range m.inputs.Secrets {
+					inputs.Secrets[name] = secret
+				}
+				msObjects, err := render.MicroService(o.config, ms, inputs)
+				if err != nil {
+					return fmt.Errorf("microservice %s: %v", ms.Name, err)
+				}
+				drift, err := microservicecontroller.Drift(o.client, o.mapper, o.scheme, o.config, ms, msObjects)
+				if err != nil {
+					return err
+				}
+				write(ms.Namespace, drift)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if differ {
+				return errDiffer
+			}
+			return nil
+		},
+	}
+	addFileFlag(cmd, &files, "Files containing Apps and MicroServices, and the ConfigMaps and Secrets they reference.")
+	return cmd
+}
+
+// copyUID sets the UID of the object in the cluster on obj, so that the owner
+// references of the objects rendered for it match those in the cluster.
+func (o *options) copyUID(obj render.Object) error {
+	live := obj.DeepCopyObject().(render.Object)
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	err := o.client.Get(context.TODO(), key, live)
+	if err != nil && apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	obj.SetUID(live.GetUID())
+	return nil
+}
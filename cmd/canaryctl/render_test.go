@@ -0,0 +1,154 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	networkingv1 "canary-crd/pkg/networking/v1"
+	"canary-crd/pkg/render"
+
+	"github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const testManifests = `apiVersion: app.o0w0o.cn/v2
+kind: MicroService
+metadata:
+  name: web
+spec:
+  currentVersionName: v1
+  versions:
+  - name: v1
+    template:
+      selector:
+        matchLabels:
+          app: web
+      template:
+        metadata:
+          labels:
+            app: web
+        spec:
+          containers:
+          - name: web
+            image: web:v1
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: web
+  namespace: prod
+stringData:
+  token: abc
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: other
+`
+
+func TestReadManifests(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	dir, err := ioutil.TempDir("", "canaryctl")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "manifests.yaml")
+	g.Expect(ioutil.WriteFile(file, []byte(testManifests), 0644)).To(gomega.Succeed())
+
+	m, err := readManifests([]string{file}, "default")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(m.apps).To(gomega.BeEmpty())
+	g.Expect(m.microServices).To(gomega.HaveLen(1))
+	g.Expect(m.microServices[0].Namespace).To(gomega.Equal("default"))
+	g.Expect(m.microServices[0].Spec.Versions[0].Template.Template.Spec.Containers[0].Image).To(gomega.Equal("web:v1"))
+	g.Expect(m.inputs.Secrets).To(gomega.HaveKey("web"))
+	g.Expect(m.inputs.Secrets["web"].Namespace).To(gomega.Equal("prod"))
+	g.Expect(m.inputs.Secrets["web"].Data).To(gomega.Equal(map[string][]byte{"token": []byte("abc")}))
+
+	g.Expect(ioutil.WriteFile(file, []byte("apiVersion: app.o0w0o.cn/v1\nkind: Release\n"), 0644)).To(gomega.Succeed())
+	_, err = readManifests([]string{file}, "default")
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestDiff(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	sample := "../../config/samples/app_v1_microservice.yaml"
+	scheme := newScheme()
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(networkingv1.SchemeGroupVersion.WithKind("Ingress"), meta.RESTScopeNamespace)
+	newOptions := func(objects ...runtime.Object) (*options, *bytes.Buffer) {
+		out := &bytes.Buffer{}
+		return &options{
+			out:         out,
+			scheme:      scheme,
+			namespace:   "default",
+			client:      fake.NewFakeClientWithScheme(scheme, objects...),
+			mapper:      mapper,
+			config:      configv1alpha1.Default(),
+			initialized: true,
+		}, out
+	}
+	diff := func(o *options) error {
+		cmd := newDiffCommand(o)
+		cmd.SilenceUsage, cmd.SilenceErrors = true, true
+		cmd.SetArgs([]string{"-f", sample})
+		return cmd.Execute()
+	}
+
+	// nothing exists yet
+	o, out := newOptions()
+	g.Expect(diff(o)).To(gomega.Equal(errDiffer))
+	g.Expect(out.String()).To(gomega.MatchRegexp(`Deployment +voting-web-v1 +Create`))
+	g.Expect(out.String()).To(gomega.MatchRegexp(`Ingress +voting-web-v2-canary +Create`))
+
+	// the cluster holds what the controller would create
+	m, err := readManifests([]string{sample}, "default")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	ms := m.microServices[0]
+	objects, err := render.MicroService(o.config, ms, &m.inputs)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	ms.UID = "voting-web-uid"
+	existing := []runtime.Object{ms}
+	for _, obj := range objects.Objects() {
+		g.Expect(controllerutil.SetControllerReference(ms, obj, scheme)).To(gomega.Succeed())
+		existing = append(existing, obj)
+	}
+	o, out = newOptions(existing...)
+	g.Expect(diff(o)).To(gomega.Succeed())
+	g.Expect(strings.Count(out.String(), "\n")).To(gomega.Equal(1))
+
+	// a canary weight edited in the cluster
+	for _, obj := range existing {
+		if obj.(render.Object).GetName() == "voting-web-v2-canary" {
+			obj.(render.Object).GetAnnotations()[o.config.NginxAnnotation("canary-weight")] = "50"
+		}
+	}
+	o, out = newOptions(existing...)
+	g.Expect(diff(o)).To(gomega.Equal(errDiffer))
+	g.Expect(out.String()).To(gomega.MatchRegexp(`Ingress +voting-web-v2-canary +Update +metadata.annotations.nginx.ingress.kubernetes.io/canary-weight\n`))
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	"canary-crd/pkg/workload"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newStatusCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status TARGET",
+		Short: "Show the versions of a MicroService or an App with their weights and readiness",
+		Example: `  canaryctl status microservice/web
+  canaryctl status app/shop`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			t, err := parseTarget(args[0])
+			if err != nil {
+				return err
+			}
+			if err := o.init(); err != nil {
+				return err
+			}
+
+			obj, err := o.getTarget(t)
+			if err != nil {
+				return err
+			}
+			switch obj := obj.(type) {
+			case *appv1.MicroService:
+				return o.microServiceStatus(obj)
+			case *appv1.App:
+				return o.appStatus(obj)
+			}
+			return nil
+		},
+	}
+}
+
+// getTarget reads the MicroService or the App t.
+func (o *options) getTarget(t target) (metav1.Object, error) {
+	if t.kind == appKind {
+		app := &appv1.App{}
+		return app, o.get(t.name, app)
+	}
+	ms := &appv1.MicroService{}
+	return ms, o.get(t.name, ms)
+}
+
+func (o *options) microServiceStatus(ms *appv1.MicroService) error {
+	w := tabwriter.NewWriter(o.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tKIND\tCURRENT\tWEIGHT\tREADY")
+	if err := o.writeVersions(w, "", ms); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// appStatus shows the release of app and the versions of the MicroServices it
+// created. The weights come from those MicroServices, as the release sets them.
+func (o *options) appStatus(app *appv1.App) error {
+	if release := app.Spec.Release; release != nil {
+		fmt.Fprintf(o.out, "Release %s: %s\n", release.Name, releaseSummary(release, app.Status.Release))
+	}
+
+	w := tabwriter.NewWriter(o.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "MICROSERVICE\tVERSION\tKIND\tCURRENT\tWEIGHT\tREADY")
+	for _, template := range app.Spec.MicroServices {
+		ms := &appv1.MicroService{}
+		err := o.get(o.config.Name(app.Name, template.Name), ms)
+		if err != nil && errors.IsNotFound(err) {
+			ms.Name = o.config.Name(app.Name, template.Name)
+			ms.Spec = template.Spec
+		} else if err != nil {
+			return err
+		}
+		if err := o.writeVersions(w, template.Name+"\t", ms); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// releaseSummary describes the progress of release.
+func releaseSummary(release *appv1.AppRelease, status *appv1.AppReleaseStatus) string {
+	if status == nil || status.Name != release.Name {
+		return "Pending"
+	}
+	summary := string(status.Phase)
+	if int(status.CurrentStep) < len(release.Steps) {
+		summary += fmt.Sprintf(", step %d/%d at weight %d", status.CurrentStep+1, len(release.Steps), release.Steps[status.CurrentStep].Weight)
+	}
+	if release.Paused {
+		summary += ", paused"
+	}
+	if status.Message != "" {
+		summary += ", " + status.Message
+	}
+	return summary
+}
+
+// writeVersions writes a row for every version of ms, each starting with prefix.
+func (o *options) writeVersions(w io.Writer, prefix string, ms *appv1.MicroService) error {
+	weights := ms.Spec.Weights()
+	for i := range ms.Spec.Versions {
+		version := &ms.Spec.Versions[i]
+		status, err := workload.Read(o.client, o.config, ms, version)
+		if err != nil {
+			return err
+		}
+		ready := "-"
+		if status.Created {
+			ready = fmt.Sprintf("%d/%d", status.ReadyReplicas, status.Replicas)
+		}
+		current := ""
+		if version.Name == ms.Spec.CurrentVersionName {
+			current = "*"
+		}
+		fmt.Fprintf(w, "%s%s\t%s\t%s\t%d\t%s\n", prefix, version.Name, version.GetKind(), current, weights[version.Name], ready)
+	}
+	return nil
+}
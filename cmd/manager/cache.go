@@ -0,0 +1,73 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	networkingv1 "canary-crd/pkg/networking/v1"
+	"canary-crd/pkg/scopedcache"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// cacheOptions returns the cache options for the comma-separated namespaces and
+// the label selector of the MicroServices and Apps. The workloads, Services and
+// Ingresses are only cached when they were created for a MicroService, which
+// all carry the service label of the configured label domain. The ReplicaSets
+// and ControllerRevisions are only read for the versions mounting config
+// snapshots, whose pod templates carry the service label as well.
+// MicroServices created by an App carry the labels of the App, so a selector on
+// the App labels selects them as well.
+func cacheOptions(managerConfig *configv1alpha1.ManagerConfig, namespaces, selector string) (scopedcache.Options, error) {
+	var opts scopedcache.Options
+	for _, namespace := range strings.Split(namespaces, ",") {
+		if namespace = strings.TrimSpace(namespace); namespace != "" {
+			opts.Namespaces = append(opts.Namespaces, namespace)
+		}
+	}
+
+	selected, err := labels.Parse(selector)
+	if err != nil {
+		return opts, err
+	}
+	owned, err := labels.Parse(managerConfig.ServiceLabel())
+	if err != nil {
+		return opts, err
+	}
+	opts.SelectorsByObject = map[runtime.Object]labels.Selector{
+		&appv1.App{}:                                  selected,
+		&appv1.MicroService{}:                         selected,
+		&appsv1.Deployment{}:                          owned,
+		&appsv1.StatefulSet{}:                         owned,
+		&appsv1.ReplicaSet{}:                          owned,
+		&appsv1.ControllerRevision{}:                  owned,
+		&corev1.Service{}:                             owned,
+		&extensionsv1beta1.Ingress{}:                  owned,
+		&networkingv1.Ingress{}:                       owned,
+		&autoscalingv2beta2.HorizontalPodAutoscaler{}: owned,
+		&policyv1beta1.PodDisruptionBudget{}:          owned,
+	}
+	return opts, nil
+}
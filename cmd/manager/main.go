@@ -19,10 +19,16 @@ package main
 import (
 	"flag"
 	"k8s.io/apimachinery/pkg/runtime"
+	"net/http"
 	"os"
 
 	"canary-crd/pkg/apis"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
 	"canary-crd/pkg/controller"
+	"canary-crd/pkg/dashboard"
+	"canary-crd/pkg/leaderelection"
+	networkingv1 "canary-crd/pkg/networking/v1"
+	"canary-crd/pkg/scopedcache"
 	"canary-crd/pkg/webhook"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -44,19 +50,38 @@ func init() {
 	_ = corev1.AddToScheme(scheme)
 	_ = appsv1.AddToScheme(scheme)
 	_ = extensionsv1beta1.AddToScheme(scheme)
+	_ = networkingv1.AddToScheme(scheme)
 	_ = apis.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
 // main.go: 这是项目的主入口文件。它负责启动整个应用，包括设置日志记录器，
-// 获取与 Kubernetes API 服务器通信的配置，创建管理器，将所有控制器添加到管理器，设置 webhooks，并启动管理器。
+// 获取与 Kubernetes API 服务器通信的配置，创建管理器，将所有控制器添加到管理器，设置 webhooks 和只读的 dashboard，并启动管理器。
 func main() {
-	var metricsAddr string
+	var metricsAddr, dashboardAddr, probeAddr, configFile, namespaces, selector string
+	var leaderElection leaderelection.Options
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&dashboardAddr, "dashboard-addr", "0", "The address the read-only release API and dashboard bind to, 0 disables them. They read the cache of the manager, which then also caches the Events of the watched namespaces.")
+	flag.StringVar(&probeAddr, "health-probe-addr", ":8081", "The address the /healthz and /readyz endpoints bind to.")
+	flag.StringVar(&configFile, "config", "", "The path of the manager configuration file, the defaults are used when empty.")
+	flag.StringVar(&namespaces, "namespaces", "", "Comma-separated namespaces the manager watches, defaults to all namespaces.")
+	flag.StringVar(&selector, "selector", "", "Label selector of the MicroServices and Apps the manager reconciles. MicroServices created by an App carry the labels of the App.")
+	leaderElection.AddFlags(flag.CommandLine)
 	flag.Parse()
 	logf.SetLogger(logf.ZapLogger(false))
 	log := logf.Log.WithName("entrypoint")
 
+	managerConfig := configv1alpha1.Default()
+	if configFile != "" {
+		log.Info("loading manager configuration", "path", configFile)
+		loaded, err := configv1alpha1.Load(configFile)
+		if err != nil {
+			log.Error(err, "unable to load the manager configuration")
+			os.Exit(1)
+		}
+		managerConfig = loaded
+	}
+
 	// Get a config to talk to the apiserver
 	log.Info("setting up client for manager")
 	cfg, err := config.GetConfig()
@@ -67,7 +92,16 @@ func main() {
 
 	// Create a new Cmd to provide shared dependencies and start components
 	log.Info("setting up manager")
-	mgr, err := manager.New(cfg, manager.Options{MetricsBindAddress: metricsAddr})
+	cacheOpts, err := cacheOptions(managerConfig, namespaces, selector)
+	if err != nil {
+		log.Error(err, "unable to parse the label selector")
+		os.Exit(1)
+	}
+	mgr, err := manager.New(cfg, manager.Options{
+		MetricsBindAddress: metricsAddr,
+		SyncPeriod:         &managerConfig.SyncPeriod.Duration,
+		NewCache:           scopedcache.New(cacheOpts),
+	})
 	if err != nil {
 		log.Error(err, "unable to set up overall controller manager")
 		os.Exit(1)
@@ -84,20 +118,74 @@ func main() {
 
 	// Setup all Controllers
 	log.Info("Setting up controller")
-	if err := controller.AddToManager(mgr); err != nil {
+	if err := controller.AddToManager(mgr, managerConfig); err != nil {
 		log.Error(err, "unable to register controllers to the manager")
 		os.Exit(1)
 	}
 
+	// Every replica serves the webhooks, the standby replicas as well, so they run in
+	// a manager of their own that is started outside the leader election
 	log.Info("setting up webhooks")
-	if err := webhook.AddToManager(mgr); err != nil {
+	webhookMgr, err := manager.New(cfg, manager.Options{
+		MetricsBindAddress: "0",
+		Namespace:          os.Getenv("POD_NAMESPACE"),
+	})
+	if err != nil {
+		log.Error(err, "unable to set up webhook manager")
+		os.Exit(1)
+	}
+	if err := webhook.AddToManager(webhookMgr); err != nil {
 		log.Error(err, "unable to register webhooks to the manager")
 		os.Exit(1)
 	}
 
-	// Start the Cmd
-	log.Info("Starting the Cmd.")
-	if err := mgr.Start(signals.SetupSignalHandler()); err != nil {
+	if dashboardAddr != "0" {
+		log.Info("setting up dashboard")
+		server := dashboard.New(mgr.GetCache(), managerConfig)
+		if err := mgr.Add(server); err != nil {
+			log.Error(err, "unable to register the dashboard to the manager")
+			os.Exit(1)
+		}
+		go func() {
+			log.Info("serving dashboard", "address", dashboardAddr)
+			if err := http.ListenAndServe(dashboardAddr, server.Handler()); err != nil {
+				log.Error(err, "unable to serve the dashboard")
+				os.Exit(1)
+			}
+		}()
+	}
+
+	health := &probes{}
+	go func() {
+		log.Info("serving health probes", "address", probeAddr)
+		if err := http.ListenAndServe(probeAddr, health.handler()); err != nil {
+			log.Error(err, "unable to serve health probes")
+			os.Exit(1)
+		}
+	}()
+
+	stop := signals.SetupSignalHandler()
+	go func() {
+		log.Info("Starting the webhook server.")
+		if err := webhookMgr.Start(stop); err != nil {
+			log.Error(err, "unable to run the webhook server")
+			os.Exit(1)
+		}
+	}()
+
+	// Start the Cmd once this replica holds the leader lock
+	health.setReady(true)
+	err = leaderelection.Run(cfg, mgr.GetRecorder("canary-crd-leader-election"), leaderElection, stop, func(stop <-chan struct{}) error {
+		log.Info("Starting the Cmd.")
+		health.setReady(false)
+		go func() {
+			if mgr.GetCache().WaitForCacheSync(stop) {
+				health.setReady(true)
+			}
+		}()
+		return mgr.Start(stop)
+	})
+	if err != nil {
 		log.Error(err, "unable to run the manager")
 		os.Exit(1)
 	}
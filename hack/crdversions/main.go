@@ -0,0 +1,230 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// crdversions merges the per-version CRDs generated by controller-gen into one
+// multi-version CRD per kind. controller-gen writes config/crds/app_<version>_<kind>.yaml
+// for every API version, but the API server needs a single CRD that lists all of them.
+// The storage version keeps its file name and the other versions' files are removed.
+//
+// Webhook conversion requires preserveUnknownFields: false and structural schemas,
+// which controller-gen does not write, so the merged schemas are completed here.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	crdDir         = flag.String("dir", filepath.Join("config", "crds"), "The directory controller-gen writes CRDs to.")
+	storageVersion = flag.String("storage-version", "v1", "The API version stored in etcd.")
+	servedVersions = []string{"v1", "v2"}
+	kinds          = []string{"app", "microservice"}
+)
+
+func main() {
+	flag.Parse()
+	for _, kind := range kinds {
+		if err := mergeVersions(kind); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func crdPath(version, kind string) string {
+	return filepath.Join(*crdDir, fmt.Sprintf("app_%s_%s.yaml", version, kind))
+}
+
+func readCRD(path string) (*apiextensionsv1beta1.CustomResourceDefinition, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	crd := &apiextensionsv1beta1.CustomResourceDefinition{}
+	return crd, yaml.Unmarshal(data, crd)
+}
+
+func mergeVersions(kind string) error {
+	merged, err := readCRD(crdPath(*storageVersion, kind))
+	if err != nil {
+		return err
+	}
+	if len(merged.Spec.Versions) != 0 {
+		// Already merged
+		return nil
+	}
+
+	merged.Spec.Version = *storageVersion
+	merged.Spec.Validation = nil
+	for _, version := range servedVersions {
+		crd, err := readCRD(crdPath(version, kind))
+		if err != nil {
+			return err
+		}
+		merged.Spec.Versions = append(merged.Spec.Versions, apiextensionsv1beta1.CustomResourceDefinitionVersion{
+			Name:    version,
+			Served:  true,
+			Storage: version == *storageVersion,
+			Schema:  crd.Spec.Validation,
+		})
+	}
+
+	data, err := structural(merged)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(crdPath(*storageVersion, kind), data, 0644); err != nil {
+		return err
+	}
+	for _, version := range servedVersions {
+		if version != *storageVersion {
+			if err := os.Remove(crdPath(version, kind)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// structural sets preserveUnknownFields: false and turns the version schemas into
+// structural schemas. The vendored apiextensions types have neither field, so the
+// CRD is edited as a map.
+func structural(crd *apiextensionsv1beta1.CustomResourceDefinition) ([]byte, error) {
+	data, err := yaml.Marshal(crd)
+	if err != nil {
+		return nil, err
+	}
+	obj := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	spec := obj["spec"].(map[string]interface{})
+	spec["preserveUnknownFields"] = false
+	for _, version := range spec["versions"].([]interface{}) {
+		schema := version.(map[string]interface{})["schema"].(map[string]interface{})
+		root := schema["openAPIV3Schema"].(map[string]interface{})
+		root["type"] = "object"
+		for name, property := range root["properties"].(map[string]interface{}) {
+			// The API server owns the metadata schema
+			if name != "metadata" {
+				completeSchema(property.(map[string]interface{}))
+			}
+		}
+		legacyIngressSpecs(root)
+	}
+	return yaml.Marshal(obj)
+}
+
+// legacyIngressSpecs adds the extensions/v1beta1 backend fields to the Ingress specs of
+// every LoadBalance. Stored objects may still hold v1beta1 shaped specs, which
+// IngressLoadBalance converts when it is decoded, so these fields must not be pruned.
+func legacyIngressSpecs(schema map[string]interface{}) {
+	properties, _ := schema["properties"].(map[string]interface{})
+	for name, property := range properties {
+		property := property.(map[string]interface{})
+		if name == "loadBalance" {
+			lbProperties, _ := property["properties"].(map[string]interface{})
+			if ingress, ok := lbProperties["ingress"].(map[string]interface{}); ok {
+				legacyIngressSpec(ingress)
+			}
+			if ingresses, ok := lbProperties["ingresses"].(map[string]interface{}); ok {
+				if items, ok := ingresses["items"].(map[string]interface{}); ok {
+					legacyIngressSpec(items)
+				}
+			}
+		}
+		legacyIngressSpecs(property)
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		legacyIngressSpecs(items)
+	}
+}
+
+// legacyIngressSpec adds the v1beta1 default backend to the spec of an IngressLoadBalance
+// schema, and the v1beta1 service fields to the backends of its paths.
+func legacyIngressSpec(ingress map[string]interface{}) {
+	spec, ok := nested(ingress, "spec")
+	if !ok {
+		return
+	}
+	spec["properties"].(map[string]interface{})["backend"] = map[string]interface{}{
+		"type":       "object",
+		"properties": legacyBackendProperties(),
+	}
+	if backend, ok := nested(spec, "rules", "[]", "http", "paths", "[]", "backend"); ok {
+		for name, property := range legacyBackendProperties() {
+			backend["properties"].(map[string]interface{})[name] = property
+		}
+	}
+}
+
+// legacyBackendProperties returns the schema of the service fields of a v1beta1 IngressBackend.
+func legacyBackendProperties() map[string]interface{} {
+	return map[string]interface{}{
+		"serviceName": map[string]interface{}{"type": "string"},
+		"servicePort": map[string]interface{}{"x-kubernetes-int-or-string": true},
+	}
+}
+
+// nested returns the schema at path below schema, "[]" stepping into the items of an array.
+func nested(schema map[string]interface{}, path ...string) (map[string]interface{}, bool) {
+	for _, name := range path {
+		var next interface{}
+		if name == "[]" {
+			next = schema["items"]
+		} else {
+			properties, _ := schema["properties"].(map[string]interface{})
+			next = properties[name]
+		}
+		var ok bool
+		if schema, ok = next.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	_, ok := schema["properties"].(map[string]interface{})
+	return schema, ok
+}
+
+// completeSchema marks IntOrString fields and keeps the unknown fields of objects
+// controller-gen leaves without properties, such as pod templates, from being pruned.
+func completeSchema(schema map[string]interface{}) {
+	if _, ok := schema["anyOf"]; ok && schema["type"] == nil {
+		delete(schema, "anyOf")
+		schema["x-kubernetes-int-or-string"] = true
+		return
+	}
+	properties, hasProperties := schema["properties"].(map[string]interface{})
+	_, hasAdditional := schema["additionalProperties"]
+	if schema["type"] == "object" && !hasProperties && !hasAdditional {
+		schema["x-kubernetes-preserve-unknown-fields"] = true
+	}
+	for _, property := range properties {
+		completeSchema(property.(map[string]interface{}))
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		completeSchema(items)
+	}
+	if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+		completeSchema(additional)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	networkingv1 "canary-crd/pkg/networking/v1"
+
+	"github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+)
+
+const legacyMicroService = `apiVersion: app.o0w0o.cn/v1
+kind: MicroService
+metadata:
+  name: voting-web
+spec:
+  unknownField: pruned
+  loadBalance:
+    ingress:
+      name: voting-web
+      spec:
+        backend:
+          serviceName: voting-web
+          servicePort: 80
+        rules:
+        - host: voting.o0w0o.cn
+          http:
+            paths:
+            - path: /
+              backend:
+                serviceName: voting-web
+                servicePort: 80
+  versions:
+  - name: v1
+    template:
+      selector:
+        matchLabels:
+          app: voting-web
+`
+
+// prune drops the fields of value its structural schema does not know, the way the
+// API server does for CRDs with preserveUnknownFields: false.
+func prune(value interface{}, schema map[string]interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		additional, _ := schema["additionalProperties"].(map[string]interface{})
+		preserve, _ := schema["x-kubernetes-preserve-unknown-fields"].(bool)
+		for name, field := range value {
+			if property, ok := properties[name].(map[string]interface{}); ok {
+				prune(field, property)
+			} else if additional != nil {
+				prune(field, additional)
+			} else if !preserve {
+				delete(value, name)
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for _, item := range value {
+				prune(item, items)
+			}
+		}
+	}
+}
+
+// versionSchema returns the spec schema of version in the generated CRD file. The
+// metadata is not pruned by the CRD schema, so only the spec is checked.
+func versionSchema(t *testing.T, file, version string) map[string]interface{} {
+	g := gomega.NewGomegaWithT(t)
+	data, err := ioutil.ReadFile(filepath.Join("..", "..", "config", "crds", file))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	crd := map[string]interface{}{}
+	g.Expect(yaml.Unmarshal(data, &crd)).To(gomega.Succeed())
+	g.Expect(crd["spec"].(map[string]interface{})["preserveUnknownFields"]).To(gomega.Equal(false))
+	for _, v := range crd["spec"].(map[string]interface{})["versions"].([]interface{}) {
+		v := v.(map[string]interface{})
+		if v["name"] == version {
+			root := v["schema"].(map[string]interface{})["openAPIV3Schema"].(map[string]interface{})
+			return root["properties"].(map[string]interface{})["spec"].(map[string]interface{})
+		}
+	}
+	t.Fatalf("version %s not found in %s", version, file)
+	return nil
+}
+
+func TestLegacyIngressSpecSurvivesPruning(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	backend := &networkingv1.IngressServiceBackend{Name: "voting-web", Port: networkingv1.ServiceBackendPort{Number: 80}}
+	for _, version := range servedVersions {
+		obj := map[string]interface{}{}
+		g.Expect(yaml.Unmarshal([]byte(legacyMicroService), &obj)).To(gomega.Succeed())
+		prune(obj["spec"], versionSchema(t, "app_v1_microservice.yaml", version))
+		g.Expect(obj["spec"]).NotTo(gomega.HaveKey("unknownField"))
+
+		data, err := json.Marshal(obj)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		ms := &appv1.MicroService{}
+		g.Expect(json.Unmarshal(data, ms)).To(gomega.Succeed())
+		spec := ms.Spec.LoadBalance.Ingress.Spec
+		g.Expect(spec.DefaultBackend.Service).To(gomega.Equal(backend))
+		g.Expect(spec.Rules[0].HTTP.Paths[0].Backend.Service).To(gomega.Equal(backend))
+		// the pod template is kept as well
+		g.Expect(ms.Spec.Versions[0].Template.Selector.MatchLabels).To(gomega.HaveKeyWithValue("app", "voting-web"))
+
+		// the same spec inside the MicroService template of an App
+		app := map[string]interface{}{}
+		g.Expect(yaml.Unmarshal([]byte(legacyMicroService), &app)).To(gomega.Succeed())
+		app["kind"] = "App"
+		app["spec"] = map[string]interface{}{"microServices": []interface{}{
+			map[string]interface{}{"name": "web", "spec": app["spec"]},
+		}}
+		prune(app["spec"], versionSchema(t, "app_v1_app.yaml", version))
+		data, err = json.Marshal(app)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		decoded := &appv1.App{}
+		g.Expect(json.Unmarshal(data, decoded)).To(gomega.Succeed())
+		g.Expect(decoded.Spec.MicroServices[0].Spec.LoadBalance.Ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service).To(gomega.Equal(backend))
+	}
+}
@@ -0,0 +1,26 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apis
+
+import (
+	"canary-crd/pkg/networking/v1"
+)
+
+func init() {
+	// Register the networking.k8s.io/v1 Ingress types, which the vendored client-go scheme does not know about
+	AddToSchemes = append(AddToSchemes, v1.SchemeBuilder.AddToScheme)
+}
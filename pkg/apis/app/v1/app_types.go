@@ -28,11 +28,81 @@ import (
 type MicroServiceTemplate struct {
 	Name string           `json:"name"`
 	Spec MicroServiceSpec `json:"spec,omitempty"`
+
+	// DependsOn lists the names of other MicroServices in this App that must
+	// report Available before this one is created or updated.
+	// +optional
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// ReleaseParticipant names the target version of one MicroService taking part in a release.
+type ReleaseParticipant struct {
+	MicroService string `json:"microService"`
+	Version      string `json:"version"`
+}
+
+// ReleaseStep is one step of the shared weight schedule of a release.
+type ReleaseStep struct {
+	// +kubebuilder:validation:Maximum=100
+	// +kubebuilder:validation:Minimum=1
+	Weight int `json:"weight"`
+
+	// PauseSeconds is how long to stay on this step once every participant is ready.
+	// +optional
+	PauseSeconds int32 `json:"pauseSeconds,omitempty"`
+}
+
+// AppRelease drives the Canary settings of several MicroServices in one App together,
+// so that all of them route the same users to the new versions.
+type AppRelease struct {
+	Name         string               `json:"name"`
+	Participants []ReleaseParticipant `json:"participants"`
+	Steps        []ReleaseStep        `json:"steps"`
+
+	// +optional
+	Header string `json:"header,omitempty"`
+
+	// +optional
+	HeaderValue string `json:"headerValue,omitempty"`
+
+	// +optional
+	Cookie string `json:"cookie,omitempty"`
+
+	// Paused keeps the release on its current step until it is unset.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
 }
 
 // AppSpec defines the desired state of App
 type AppSpec struct {
 	MicroServices []MicroServiceTemplate `json:"microServices,omitempty"`
+
+	// +optional
+	Release *AppRelease `json:"release,omitempty"`
+
+	// DefaultDeny locks down the pods of every MicroService in the App, so that
+	// they only accept the traffic their NetworkPolicy allows.
+	// +optional
+	DefaultDeny bool `json:"defaultDeny,omitempty"`
+}
+
+type ReleasePhase string
+
+const (
+	ReleaseProgressing ReleasePhase = "Progressing"
+	ReleaseSucceeded   ReleasePhase = "Succeeded"
+	ReleaseRolledBack  ReleasePhase = "RolledBack"
+)
+
+// AppReleaseStatus is the observed state of the App release.
+type AppReleaseStatus struct {
+	Name        string       `json:"name"`
+	Phase       ReleasePhase `json:"phase"`
+	CurrentStep int32        `json:"currentStep"`
+	// The time the current step was entered.
+	LastStepTime metav1.Time `json:"lastStepTime,omitempty"`
+	// A human readable message indicating details about the phase.
+	Message string `json:"message,omitempty"`
 }
 
 // AppStatus defines the observed state of App
@@ -42,6 +112,13 @@ type AppStatus struct {
 	Conditions             []AppCondition `json:"conditions,omitempty"`
 	AvailableMicroServices int32          `json:"availableVersions,omitempty" protobuf:"varint,4,opt,name=availableMSs"`
 	TotalMicroServices     int32          `json:"totalVersions,omitempty" protobuf:"varint,4,opt,name=totalMSs"`
+
+	// RolloutOrder is the resolved order in which MicroServices are rolled out.
+	// +optional
+	RolloutOrder []string `json:"rolloutOrder,omitempty"`
+
+	// +optional
+	Release *AppReleaseStatus `json:"release,omitempty"`
 }
 
 type AppConditionType string
@@ -49,6 +126,7 @@ type AppConditionType string
 const (
 	AppAvailable   AppConditionType = "Available"
 	AppProgressing AppConditionType = "Progressing"
+	AppFailed      AppConditionType = "Failed"
 )
 
 type AppCondition struct {
@@ -0,0 +1,50 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	networkingv1 "canary-crd/pkg/networking/v1"
+	"encoding/json"
+
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+)
+
+//这个文件负责兼容旧版本的 IngressLoadBalance。早期的 MicroService 使用 extensions/v1beta1 格式的 IngressSpec，
+//反序列化时如果发现 backend 中使用了 serviceName/servicePort，会将其转换为 networking.k8s.io/v1 格式，
+//这样已经存储在集群中的旧 CR 不需要修改 YAML 也可以继续工作。
+
+// UnmarshalJSON decodes an IngressLoadBalance, converting extensions/v1beta1 shaped specs.
+func (in *IngressLoadBalance) UnmarshalJSON(data []byte) error {
+	type ingressLoadBalance IngressLoadBalance
+	out := ingressLoadBalance{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return err
+	}
+
+	legacy := struct {
+		Spec extensionsv1beta1.IngressSpec `json:"spec"`
+	}{}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	if networkingv1.IsV1beta1IngressSpec(&legacy.Spec) {
+		out.Spec = networkingv1.ConvertFromV1beta1IngressSpec(&legacy.Spec)
+	}
+
+	*in = IngressLoadBalance(out)
+	return nil
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+
+	networkingv1 "canary-crd/pkg/networking/v1"
+
+	"github.com/onsi/gomega"
+)
+
+func TestIngressLoadBalanceV1beta1Compat(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	legacy := `{"name":"voting-web","spec":{"rules":[{"host":"voting.o0w0o.cn","http":{"paths":[{"path":"/","backend":{"serviceName":"voting-web","servicePort":80}}]}}]}}`
+	lb := &IngressLoadBalance{}
+	g.Expect(json.Unmarshal([]byte(legacy), lb)).NotTo(gomega.HaveOccurred())
+	g.Expect(lb.Spec.Rules[0].HTTP.Paths[0].Backend.Service).To(gomega.Equal(&networkingv1.IngressServiceBackend{
+		Name: "voting-web",
+		Port: networkingv1.ServiceBackendPort{Number: 80},
+	}))
+
+	// networking.k8s.io/v1 shaped specs decode unchanged
+	data, err := json.Marshal(lb)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	decoded := &IngressLoadBalance{}
+	g.Expect(json.Unmarshal(data, decoded)).NotTo(gomega.HaveOccurred())
+	g.Expect(decoded).To(gomega.Equal(lb))
+}
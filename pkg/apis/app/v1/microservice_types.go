@@ -17,10 +17,13 @@ limitations under the License.
 package v1
 
 import (
+	networkingv1 "canary-crd/pkg/networking/v1"
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
 	corev1 "k8s.io/api/core/v1"
-	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	k8snetworkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 //这个文件定义了微服务的数据结构，包括 MicroService 和 MicroServiceList。MicroService 结构体包含了微服务的元数据、规格和状态，
@@ -44,15 +47,139 @@ type Canary struct {
 	Cookie string `json:"cookie,omitempty"`
 }
 
+// WorkloadKind is the kind of workload a DeployVersion runs as.
+type WorkloadKind string
+
+const (
+	DeploymentKind  WorkloadKind = "Deployment"
+	StatefulSetKind WorkloadKind = "StatefulSet"
+)
+
 type DeployVersion struct {
-	Name     string                `json:"name"`
-	Template appsv1.DeploymentSpec `json:"template"`
+	Name string `json:"name"`
+
+	// Kind of the workload, one of Deployment or StatefulSet. Defaults to Deployment.
+	// +kubebuilder:validation:Enum=Deployment,StatefulSet
+	// +optional
+	Kind WorkloadKind `json:"kind,omitempty"`
+
+	// Template is the workload spec when Kind is Deployment.
+	// +optional
+	Template appsv1.DeploymentSpec `json:"template,omitempty"`
+
+	// StatefulSetTemplate is the workload spec when Kind is StatefulSet.
+	// +optional
+	StatefulSetTemplate *appsv1.StatefulSetSpec `json:"statefulSetTemplate,omitempty"`
 
 	// +optional
 	ServiceName string `json:"serviceName,omitempty"`
 
 	// +optional
 	Canary *Canary `json:"canary,omitempty"`
+
+	// Overrides customizes the MicroService BaseTemplate for this version.
+	// +optional
+	Overrides *TemplateOverrides `json:"overrides,omitempty"`
+
+	// Autoscaling of the version, it overrides the Autoscaling of the MicroService.
+	// +optional
+	Autoscaling *Autoscaling `json:"autoscaling,omitempty"`
+
+	// DisruptionBudget of the version, it overrides the DisruptionBudget of the MicroService.
+	// +optional
+	DisruptionBudget *DisruptionBudget `json:"disruptionBudget,omitempty"`
+
+	// Config is copied into immutable ConfigMaps and Secrets owned by the version
+	// and mounted into its pods.
+	// +optional
+	Config []ConfigSnapshot `json:"config,omitempty"`
+
+	// RestartOnConfigChange rolls the pods of the version when the content of a
+	// ConfigMap or Secret referenced by its pod template changes.
+	// +optional
+	RestartOnConfigChange bool `json:"restartOnConfigChange,omitempty"`
+}
+
+// ConfigSnapshot is configuration the controller copies into a ConfigMap, or a Secret,
+// named after the hash of its content. A change of the content creates a new snapshot
+// and rolls the version. Old snapshots are kept while the ReplicaSets or ControllerRevisions
+// of their version still use them, and deleted once they do not or the version is removed.
+type ConfigSnapshot struct {
+	// Name of the snapshot, unique within the version.
+	Name string `json:"name"`
+
+	// MountPath is where the snapshot is mounted in the containers.
+	MountPath string `json:"mountPath"`
+
+	// Containers the snapshot is mounted in, all containers when empty.
+	// +optional
+	Containers []string `json:"containers,omitempty"`
+
+	// ConfigMapRef copies the data of an existing ConfigMap.
+	// +optional
+	ConfigMapRef *corev1.LocalObjectReference `json:"configMapRef,omitempty"`
+
+	// SecretRef copies the data of an existing Secret, the snapshot is a Secret.
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// Data is literal data, it takes precedence over the data of the references.
+	// +optional
+	Data map[string]string `json:"data,omitempty"`
+
+	// Secret stores the snapshot in a Secret instead of a ConfigMap.
+	// +optional
+	Secret bool `json:"secret,omitempty"`
+}
+
+// DisruptionBudget describes the PodDisruptionBudget the controller creates for a version.
+// At most one of MinAvailable and MaxUnavailable may be set, when neither is set
+// MaxUnavailable defaults to 1.
+type DisruptionBudget struct {
+	// +optional
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// Autoscaling describes the HorizontalPodAutoscaler the controller creates for a version.
+type Autoscaling struct {
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// Metrics used to calculate the desired replica count, see autoscaling/v2beta2.
+	// +optional
+	Metrics []autoscalingv2beta2.MetricSpec `json:"metrics,omitempty"`
+
+	// ScaleWithWeight scales MinReplicas and MaxReplicas of a canary version
+	// in proportion to its canary weight.
+	// +optional
+	ScaleWithWeight bool `json:"scaleWithWeight,omitempty"`
+}
+
+// TemplateOverrides are applied in order to the pod template of a version:
+// first the strategic merge patch, then the JSON patch, then the image override.
+type TemplateOverrides struct {
+	// Image replaces the image of the container named Container, or of the
+	// first container when Container is empty.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// +optional
+	Container string `json:"container,omitempty"`
+
+	// StrategicMergePatch is a strategic merge patch of a PodTemplateSpec, in JSON or YAML.
+	// +optional
+	StrategicMergePatch string `json:"strategicMergePatch,omitempty"`
+
+	// JSONPatch is an RFC 6902 JSON patch of a PodTemplateSpec, in JSON or YAML.
+	// +optional
+	JSONPatch string `json:"jsonPatch,omitempty"`
 }
 
 type ServiceLoadBalance struct {
@@ -61,8 +188,34 @@ type ServiceLoadBalance struct {
 }
 
 type IngressLoadBalance struct {
-	Name string                        `json:"name"`
-	Spec extensionsv1beta1.IngressSpec `json:"spec"`
+	Name string                   `json:"name"`
+	Spec networkingv1.IngressSpec `json:"spec"`
+
+	// TLS requests a certificate from cert-manager for the Ingress. Canary
+	// Ingresses of the versions reuse the certificate secret of this Ingress.
+	// +optional
+	TLS *IngressTLS `json:"tls,omitempty"`
+}
+
+// IngressTLS requests a cert-manager certificate for an Ingress by issuer name.
+// The controller annotates the Ingress for cert-manager's ingress-shim and adds
+// a TLS block for the hosts, the Certificate is named after the secret.
+type IngressTLS struct {
+	// Issuer is the name of a cert-manager Issuer in the namespace of the MicroService.
+	// +optional
+	Issuer string `json:"issuer,omitempty"`
+
+	// ClusterIssuer is the name of a cert-manager ClusterIssuer, used when Issuer is empty.
+	// +optional
+	ClusterIssuer string `json:"clusterIssuer,omitempty"`
+
+	// SecretName is the secret the certificate is stored in, defaults to <Ingress>-tls.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// Hosts covered by the certificate, defaults to the hosts of the Ingress rules.
+	// +optional
+	Hosts []string `json:"hosts,omitempty"`
 }
 
 type LoadBalance struct {
@@ -70,6 +223,75 @@ type LoadBalance struct {
 	Service *ServiceLoadBalance `json:"service,omitempty"`
 	// +optional
 	Ingress *IngressLoadBalance `json:"ingress,omitempty"`
+
+	// Services are additional Services, every version gets its own Service
+	// named <Service>-<Version> for each of them.
+	// +optional
+	Services []ServiceLoadBalance `json:"services,omitempty"`
+
+	// Ingresses are additional Ingresses, a canary Ingress is created for each of
+	// them whose backends reference a Service of the LoadBalance.
+	// +optional
+	Ingresses []IngressLoadBalance `json:"ingresses,omitempty"`
+}
+
+// Metrics declares how Prometheus scrapes the pods of a MicroService. The controller
+// generates a ServiceMonitor selecting the Services of every version, so that every
+// series carries the version label. It requires a Service in the LoadBalance.
+type Metrics struct {
+	// Port is the name of the Service port exposing the metrics.
+	Port string `json:"port"`
+
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// +optional
+	Interval string `json:"interval,omitempty"`
+
+	// Rules generates a PrometheusRule with per-version request and error rates.
+	// +optional
+	Rules *MetricsRules `json:"rules,omitempty"`
+}
+
+// MetricsRules configures the per-version recording rules.
+type MetricsRules struct {
+	// RequestsMetric is the counter of requests, defaults to http_requests_total.
+	// +optional
+	RequestsMetric string `json:"requestsMetric,omitempty"`
+
+	// ErrorSelector selects the failed requests of RequestsMetric, defaults to code=~"5..".
+	// +optional
+	ErrorSelector string `json:"errorSelector,omitempty"`
+}
+
+// NetworkPolicy describes the traffic allowed into the pods of a MicroService.
+type NetworkPolicy struct {
+	// FromMicroServices lists the MicroServices of the same App allowed to call
+	// this one, by their name in the App.
+	// +optional
+	FromMicroServices []string `json:"fromMicroServices,omitempty"`
+
+	// FromNamespaces selects namespaces whose pods are allowed to call this MicroService.
+	// +optional
+	FromNamespaces *metav1.LabelSelector `json:"fromNamespaces,omitempty"`
+
+	// FromIngressController selects the pods of the ingress controller.
+	// +optional
+	FromIngressController *NetworkPolicyPeer `json:"fromIngressController,omitempty"`
+
+	// Ports the traffic is allowed to, all ports when empty.
+	// +optional
+	Ports []k8snetworkingv1.NetworkPolicyPort `json:"ports,omitempty"`
+}
+
+// NetworkPolicyPeer selects pods, in the namespaces selected by NamespaceSelector
+// or in the namespace of the MicroService when it is nil.
+type NetworkPolicyPeer struct {
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
 }
 
 // MicroServiceSpec defines the desired state of MicroService
@@ -78,6 +300,68 @@ type MicroServiceSpec struct {
 	LoadBalance        *LoadBalance    `json:"loadBalance,omitempty"`
 	Versions           []DeployVersion `json:"versions"`
 	CurrentVersionName string          `json:"currentVersionName"`
+
+	// BaseTemplate is the pod template shared by all versions. When it is set, the
+	// pod template of each version is rendered from it: the labels and annotations
+	// of the version's own pod template are added and its Overrides are applied.
+	// +optional
+	BaseTemplate *corev1.PodTemplateSpec `json:"baseTemplate,omitempty"`
+
+	// Autoscaling is the default Autoscaling of the versions.
+	// +optional
+	Autoscaling *Autoscaling `json:"autoscaling,omitempty"`
+
+	// DisruptionBudget is the default DisruptionBudget of the versions.
+	// +optional
+	DisruptionBudget *DisruptionBudget `json:"disruptionBudget,omitempty"`
+
+	// NetworkPolicy restricts the traffic allowed into the pods of the MicroService.
+	// +optional
+	NetworkPolicy *NetworkPolicy `json:"networkPolicy,omitempty"`
+
+	// Metrics enables the ServiceMonitor and PrometheusRule of the MicroService.
+	// +optional
+	Metrics *Metrics `json:"metrics,omitempty"`
+
+	// Mode selects whether the controller applies the MicroService or only reports
+	// how the objects it owns drifted from it. Defaults to Apply.
+	// +kubebuilder:validation:Enum=Apply,ReportOnly
+	// +optional
+	Mode ReconcileMode `json:"mode,omitempty"`
+}
+
+// ReconcileMode is how the controller reconciles a MicroService.
+type ReconcileMode string
+
+const (
+	// ApplyMode creates, updates and deletes the objects of the MicroService.
+	ApplyMode ReconcileMode = "Apply"
+	// ReportOnlyMode changes nothing, the differences between the objects in the
+	// cluster and the MicroService are recorded in status.drift instead.
+	ReportOnlyMode ReconcileMode = "ReportOnly"
+)
+
+// ReportOnly returns true when the MicroService is reconciled in ReportOnly mode.
+func (in *MicroServiceSpec) ReportOnly() bool {
+	return in.Mode == ReportOnlyMode
+}
+
+// Weights returns the percentage of the traffic each version receives: the
+// canary versions their weight and the current version the rest.
+func (in *MicroServiceSpec) Weights() map[string]int {
+	weights := make(map[string]int, len(in.Versions))
+	current := 100
+	for _, version := range in.Versions {
+		if version.Name != in.CurrentVersionName && version.Canary != nil {
+			weights[version.Name] = version.Canary.Weight
+			current -= version.Canary.Weight
+		}
+	}
+	if current < 0 {
+		current = 0
+	}
+	weights[in.CurrentVersionName] = current
+	return weights
 }
 
 // MicroServiceStatus defines the observed state of MicroService
@@ -87,6 +371,62 @@ type MicroServiceStatus struct {
 	Conditions        []MicroServiceCondition `json:"conditions,omitempty"`
 	AvailableVersions int32                   `json:"availableVersions,omitempty" protobuf:"varint,4,opt,name=availableVersions"`
 	TotalVersions     int32                   `json:"totalVersions,omitempty" protobuf:"varint,4,opt,name=totalVersions"`
+
+	// Versions records the hash of the rendered pod template of each version.
+	Versions []VersionStatus `json:"versions,omitempty"`
+
+	// Certificates reports the readiness of the cert-manager certificates
+	// requested by the Ingresses of the LoadBalance.
+	// +optional
+	Certificates []CertificateStatus `json:"certificates,omitempty"`
+
+	// Drift lists the changes the controller would make in ReportOnly mode.
+	// +optional
+	Drift []DriftedObject `json:"drift,omitempty"`
+}
+
+// VersionStatus is the observed state of one DeployVersion.
+type VersionStatus struct {
+	Name string `json:"name"`
+
+	// TemplateHash is the hash of the rendered pod template of the version.
+	// +optional
+	TemplateHash string `json:"templateHash,omitempty"`
+}
+
+// DriftAction is the change the controller would make to a drifted object.
+type DriftAction string
+
+const (
+	DriftCreate DriftAction = "Create"
+	DriftUpdate DriftAction = "Update"
+	DriftDelete DriftAction = "Delete"
+)
+
+// DriftedObject is an object owned by the MicroService that differs from its spec.
+type DriftedObject struct {
+	Kind   string      `json:"kind"`
+	Name   string      `json:"name"`
+	Action DriftAction `json:"action"`
+
+	// Fields are the paths of the fields that would be updated.
+	// +optional
+	Fields []string `json:"fields,omitempty"`
+}
+
+// CertificateStatus is the observed state of the certificate of one Ingress.
+type CertificateStatus struct {
+	// Ingress is the name of the Ingress requesting the certificate.
+	Ingress string `json:"ingress"`
+
+	// SecretName is the secret and Certificate name.
+	SecretName string `json:"secretName"`
+
+	// Ready is true once cert-manager has issued the certificate.
+	Ready bool `json:"ready"`
+
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
 type MicroServiceConditionType string
@@ -94,6 +434,9 @@ type MicroServiceConditionType string
 const (
 	MicroServiceAvailable   MicroServiceConditionType = "Available"
 	MicroServiceProgressing MicroServiceConditionType = "Progressing"
+	// MicroServiceDrifted is maintained in ReportOnly mode, it is True while the
+	// objects in the cluster differ from the MicroService.
+	MicroServiceDrifted MicroServiceConditionType = "Drifted"
 )
 
 type ConditionStatus string
@@ -0,0 +1,63 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//这个文件为 DeployVersion 提供了与工作负载类型无关的访问方法，控制器通过这些方法获取版本的 Pod 选择器和 Pod 模板。
+
+// GetKind returns the workload kind of the version, defaulting to Deployment.
+func (in *DeployVersion) GetKind() WorkloadKind {
+	if in.Kind == "" {
+		return DeploymentKind
+	}
+	return in.Kind
+}
+
+// PodSelector returns the label selector of the pods of the version.
+func (in *DeployVersion) PodSelector() *metav1.LabelSelector {
+	if in.GetKind() == StatefulSetKind {
+		if in.StatefulSetTemplate == nil {
+			return nil
+		}
+		return in.StatefulSetTemplate.Selector
+	}
+	return in.Template.Selector
+}
+
+// PodLabels returns the labels the pods of the version are selected by.
+func (in *DeployVersion) PodLabels() map[string]string {
+	selector := in.PodSelector()
+	if selector == nil {
+		return nil
+	}
+	return selector.MatchLabels
+}
+
+// PodTemplate returns the pod template of the version.
+func (in *DeployVersion) PodTemplate() *corev1.PodTemplateSpec {
+	if in.GetKind() == StatefulSetKind {
+		if in.StatefulSetTemplate == nil {
+			return nil
+		}
+		return &in.StatefulSetTemplate.Template
+	}
+	return &in.Template.Template
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//这个文件定义了 v2 版本的 App。与 v1 相比，AppStatus 中 MicroService 计数的 JSON 字段名与其含义保持一致。
+
+type MicroServiceTemplate struct {
+	Name string           `json:"name"`
+	Spec MicroServiceSpec `json:"spec,omitempty"`
+
+	// DependsOn lists the names of other MicroServices in this App that must
+	// report Available before this one is created or updated.
+	// +optional
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// ReleaseParticipant names the target version of one MicroService taking part in a release.
+type ReleaseParticipant struct {
+	MicroService string `json:"microService"`
+	Version      string `json:"version"`
+}
+
+// ReleaseStep is one step of the shared weight schedule of a release.
+type ReleaseStep struct {
+	// +kubebuilder:validation:Maximum=100
+	// +kubebuilder:validation:Minimum=1
+	Weight int `json:"weight"`
+
+	// PauseSeconds is how long to stay on this step once every participant is ready.
+	// +optional
+	PauseSeconds int32 `json:"pauseSeconds,omitempty"`
+}
+
+// AppRelease drives the Canary settings of several MicroServices in one App together,
+// so that all of them route the same users to the new versions.
+type AppRelease struct {
+	Name         string               `json:"name"`
+	Participants []ReleaseParticipant `json:"participants"`
+	Steps        []ReleaseStep        `json:"steps"`
+
+	// +optional
+	Header string `json:"header,omitempty"`
+
+	// +optional
+	HeaderValue string `json:"headerValue,omitempty"`
+
+	// +optional
+	Cookie string `json:"cookie,omitempty"`
+
+	// Paused keeps the release on its current step until it is unset.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+}
+
+// AppSpec defines the desired state of App
+type AppSpec struct {
+	MicroServices []MicroServiceTemplate `json:"microServices,omitempty"`
+
+	// +optional
+	Release *AppRelease `json:"release,omitempty"`
+
+	// DefaultDeny locks down the pods of every MicroService in the App, so that
+	// they only accept the traffic their NetworkPolicy allows.
+	// +optional
+	DefaultDeny bool `json:"defaultDeny,omitempty"`
+}
+
+type ReleasePhase string
+
+const (
+	ReleaseProgressing ReleasePhase = "Progressing"
+	ReleaseSucceeded   ReleasePhase = "Succeeded"
+	ReleaseRolledBack  ReleasePhase = "RolledBack"
+)
+
+// AppReleaseStatus is the observed state of the App release.
+type AppReleaseStatus struct {
+	Name        string       `json:"name"`
+	Phase       ReleasePhase `json:"phase"`
+	CurrentStep int32        `json:"currentStep"`
+	// The time the current step was entered.
+	LastStepTime metav1.Time `json:"lastStepTime,omitempty"`
+	// A human readable message indicating details about the phase.
+	Message string `json:"message,omitempty"`
+}
+
+// AppStatus defines the observed state of App
+type AppStatus struct {
+	Conditions             []AppCondition `json:"conditions,omitempty"`
+	AvailableMicroServices int32          `json:"availableMicroServices,omitempty"`
+	TotalMicroServices     int32          `json:"totalMicroServices,omitempty"`
+
+	// RolloutOrder is the resolved order in which MicroServices are rolled out.
+	// +optional
+	RolloutOrder []string `json:"rolloutOrder,omitempty"`
+
+	// +optional
+	Release *AppReleaseStatus `json:"release,omitempty"`
+
+	// MicroServices records the names the controller derived for the versions of
+	// each MicroService template.
+	// +optional
+	MicroServices []MicroServiceTemplateStatus `json:"microServices,omitempty"`
+}
+
+// MicroServiceTemplateStatus records the objects the controller derived for one MicroService template.
+type MicroServiceTemplateStatus struct {
+	Name     string          `json:"name"`
+	Versions []VersionStatus `json:"versions,omitempty"`
+}
+
+type AppConditionType string
+
+const (
+	AppAvailable   AppConditionType = "Available"
+	AppProgressing AppConditionType = "Progressing"
+	AppFailed      AppConditionType = "Failed"
+)
+
+type AppCondition struct {
+	// Type of deployment condition.
+	Type AppConditionType `json:"type"`
+	// Status of the condition, one of True, False, Unknown.
+	Status ConditionStatus `json:"status"`
+	// The last time this condition was updated.
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+	// Last time the condition transitioned from one status to another.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// The reason for the condition's last transition.
+	Reason string `json:"reason,omitempty"`
+	// A human readable message indicating details about the transition.
+	Message string `json:"message,omitempty"`
+}
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// App is the Schema for the apps API
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+type App struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AppSpec   `json:"spec,omitempty"`
+	Status AppStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// AppList contains a list of App
+type AppList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []App `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&App{}, &AppList{})
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"encoding/json"
+
+	"canary-crd/pkg/apis/app/v1"
+)
+
+//这个文件定义了 v2 与 v1 之间的相互转换。v1 是存储版本（Hub），v2 的对象通过 ConvertTo 和 ConvertFrom 与 v1 相互转换。
+//两个版本中含义相同的字段使用相同的 JSON 名称，因此通过 JSON 进行转换，只有字段位置或名称不同的部分需要单独处理。
+
+// ConvertTo converts this MicroService to the v1 storage version.
+func (src *MicroService) ConvertTo(dst *v1.MicroService) error {
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	if err := convertJSON(&src.Spec, &dst.Spec); err != nil {
+		return err
+	}
+	if err := convertJSON(&src.Status, &dst.Status); err != nil {
+		return err
+	}
+
+	// v1 only records the template hash in the status of each version
+	dst.Status.Versions = nil
+	for _, status := range src.Status.Versions {
+		if status.TemplateHash != "" {
+			dst.Status.Versions = append(dst.Status.Versions, v1.VersionStatus{Name: status.Name, TemplateHash: status.TemplateHash})
+		}
+	}
+
+	setDerivedNames(dst.Spec.Versions, src.Status.Versions)
+	return nil
+}
+
+// ConvertFrom converts from the v1 storage version to this MicroService.
+func (dst *MicroService) ConvertFrom(src *v1.MicroService) error {
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	if err := convertJSON(&src.Spec, &dst.Spec); err != nil {
+		return err
+	}
+	if err := convertJSON(&src.Status, &dst.Status); err != nil {
+		return err
+	}
+
+	hashes := make(map[string]string, len(src.Status.Versions))
+	for _, status := range src.Status.Versions {
+		hashes[status.Name] = status.TemplateHash
+	}
+	dst.Status.Versions = versionStatuses(src.Spec.Versions, hashes)
+	return nil
+}
+
+// ConvertTo converts this App to the v1 storage version.
+func (src *App) ConvertTo(dst *v1.App) error {
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	if err := convertJSON(&src.Spec, &dst.Spec); err != nil {
+		return err
+	}
+	if err := convertJSON(&src.Status, &dst.Status); err != nil {
+		return err
+	}
+	dst.Status.AvailableMicroServices = src.Status.AvailableMicroServices
+	dst.Status.TotalMicroServices = src.Status.TotalMicroServices
+
+	for _, template := range src.Status.MicroServices {
+		for i := range dst.Spec.MicroServices {
+			if dst.Spec.MicroServices[i].Name == template.Name {
+				setDerivedNames(dst.Spec.MicroServices[i].Spec.Versions, template.Versions)
+			}
+		}
+	}
+	return nil
+}
+
+// ConvertFrom converts from the v1 storage version to this App. Names the controller
+// derived inside the MicroService templates are moved to the status of the App.
+func (dst *App) ConvertFrom(src *v1.App) error {
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	if err := convertJSON(&src.Spec, &dst.Spec); err != nil {
+		return err
+	}
+	if err := convertJSON(&src.Status, &dst.Status); err != nil {
+		return err
+	}
+	dst.Status.AvailableMicroServices = src.Status.AvailableMicroServices
+	dst.Status.TotalMicroServices = src.Status.TotalMicroServices
+
+	dst.Status.MicroServices = nil
+	for _, template := range src.Spec.MicroServices {
+		if versions := versionStatuses(template.Spec.Versions, nil); len(versions) != 0 {
+			dst.Status.MicroServices = append(dst.Status.MicroServices, MicroServiceTemplateStatus{Name: template.Name, Versions: versions})
+		}
+	}
+	return nil
+}
+
+// versionStatuses collects the names v1 keeps in the spec of each version, together
+// with the template hashes, into v2 version statuses.
+func versionStatuses(versions []v1.DeployVersion, hashes map[string]string) []VersionStatus {
+	var statuses []VersionStatus
+	for _, version := range versions {
+		status := VersionStatus{Name: version.Name, ServiceName: version.ServiceName, TemplateHash: hashes[version.Name]}
+		if version.Canary != nil {
+			status.CanaryIngressName = version.Canary.CanaryIngressName
+		}
+		if status.ServiceName == "" && status.CanaryIngressName == "" && status.TemplateHash == "" {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// setDerivedNames writes the names recorded in the v2 version statuses back into the
+// spec of each v1 version.
+func setDerivedNames(versions []v1.DeployVersion, statuses []VersionStatus) {
+	for i := range versions {
+		version := &versions[i]
+		for _, status := range statuses {
+			if status.Name != version.Name {
+				continue
+			}
+			version.ServiceName = status.ServiceName
+			if version.Canary != nil {
+				version.Canary.CanaryIngressName = status.CanaryIngressName
+			}
+		}
+	}
+}
+
+// convertJSON copies the fields of in to out that have the same JSON name.
+func convertJSON(in interface{}, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"canary-crd/pkg/apis/app/v1"
+
+	"github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func newV1MicroService() *v1.MicroService {
+	now := metav1.NewTime(time.Unix(1560000000, 0))
+	return &v1.MicroService{
+		ObjectMeta: metav1.ObjectMeta{Name: "voting-web", Namespace: "default", Labels: map[string]string{"app.o0w0o.cn/app": "voting"}},
+		Spec: v1.MicroServiceSpec{
+			LoadBalance: &v1.LoadBalance{
+				Service: &v1.ServiceLoadBalance{Name: "voting-web", Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 80}}}},
+			},
+			Versions: []v1.DeployVersion{
+				{Name: "v1", ServiceName: "voting-web-v1", Template: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "voting-web"}},
+				}},
+				{Name: "v2", ServiceName: "voting-web-v2", Canary: &v1.Canary{Weight: 10, CanaryIngressName: "voting-web-v2-canary"}},
+			},
+			CurrentVersionName: "v1",
+		},
+		Status: v1.MicroServiceStatus{
+			Conditions: []v1.MicroServiceCondition{{
+				Type:               v1.MicroServiceAvailable,
+				Status:             v1.ConditionTrue,
+				LastUpdateTime:     now,
+				LastTransitionTime: now,
+				Reason:             "All deploy have updated.",
+			}},
+			AvailableVersions: 2,
+			TotalVersions:     2,
+			Versions:          []v1.VersionStatus{{Name: "v2", TemplateHash: "5d8f9c7b4"}},
+		},
+	}
+}
+
+func TestMicroServiceRoundTrip(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	original := newV1MicroService()
+	converted := &MicroService{}
+	g.Expect(converted.ConvertFrom(original)).NotTo(gomega.HaveOccurred())
+	g.Expect(converted.Status.Versions).To(gomega.Equal([]VersionStatus{
+		{Name: "v1", ServiceName: "voting-web-v1"},
+		{Name: "v2", ServiceName: "voting-web-v2", CanaryIngressName: "voting-web-v2-canary", TemplateHash: "5d8f9c7b4"},
+	}))
+	g.Expect(converted.Spec.Versions[1].Canary).To(gomega.Equal(&Canary{Weight: 10}))
+
+	back := &v1.MicroService{}
+	g.Expect(converted.ConvertTo(back)).NotTo(gomega.HaveOccurred())
+	g.Expect(back).To(gomega.Equal(original))
+
+	// And the other way around
+	again := &MicroService{}
+	g.Expect(again.ConvertFrom(back)).NotTo(gomega.HaveOccurred())
+	g.Expect(again).To(gomega.Equal(converted))
+}
+
+func TestAppRoundTrip(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	ms := newV1MicroService()
+	original := &v1.App{
+		ObjectMeta: metav1.ObjectMeta{Name: "voting", Namespace: "default"},
+		Spec: v1.AppSpec{
+			MicroServices: []v1.MicroServiceTemplate{
+				{Name: "voting-web", Spec: ms.Spec, DependsOn: []string{"voting-result"}},
+				{Name: "voting-result", Spec: ms.Spec},
+			},
+		},
+		Status: v1.AppStatus{
+			AvailableMicroServices: 1,
+			TotalMicroServices:     2,
+			RolloutOrder:           []string{"voting-result", "voting-web"},
+		},
+	}
+
+	converted := &App{}
+	g.Expect(converted.ConvertFrom(original)).NotTo(gomega.HaveOccurred())
+	g.Expect(converted.Status.AvailableMicroServices).To(gomega.Equal(int32(1)))
+	g.Expect(converted.Status.TotalMicroServices).To(gomega.Equal(int32(2)))
+	versions := []VersionStatus{
+		{Name: "v1", ServiceName: "voting-web-v1"},
+		{Name: "v2", ServiceName: "voting-web-v2", CanaryIngressName: "voting-web-v2-canary"},
+	}
+	g.Expect(converted.Status.MicroServices).To(gomega.Equal([]MicroServiceTemplateStatus{
+		{Name: "voting-web", Versions: versions},
+		{Name: "voting-result", Versions: versions},
+	}))
+	g.Expect(converted.Spec.MicroServices[0].Spec.Versions[1].Canary).To(gomega.Equal(&Canary{Weight: 10}))
+
+	back := &v1.App{}
+	g.Expect(converted.ConvertTo(back)).NotTo(gomega.HaveOccurred())
+	g.Expect(back).To(gomega.Equal(original))
+
+	// And the other way around
+	again := &App{}
+	g.Expect(again.ConvertFrom(back)).NotTo(gomega.HaveOccurred())
+	g.Expect(again).To(gomega.Equal(converted))
+}
+
+func TestSamplesConvert(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	data, err := ioutil.ReadFile(filepath.Join("..", "..", "..", "..", "config", "samples", "app_v1_microservice.yaml"))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	original := &v1.MicroService{}
+	g.Expect(yaml.Unmarshal(data, original)).NotTo(gomega.HaveOccurred())
+
+	converted := &MicroService{}
+	g.Expect(converted.ConvertFrom(original)).NotTo(gomega.HaveOccurred())
+	back := &v1.MicroService{}
+	g.Expect(converted.ConvertTo(back)).NotTo(gomega.HaveOccurred())
+	back.TypeMeta = original.TypeMeta
+	g.Expect(back).To(gomega.Equal(original))
+}
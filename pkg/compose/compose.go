@@ -0,0 +1,435 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package compose converts docker-compose files into Apps.
+//
+// Each compose service becomes a MicroService of the App with a single
+// version, running the image of the service with its command, environment,
+// replicas, resources and healthcheck. The ports of a service become the
+// ports of its LoadBalance Service, named after the compose service so that
+// the other services keep reaching it by the same host name, and its published
+// ports optionally become Ingress rules. Keys without an equivalent, such as
+// volumes and networks, are reported as warnings.
+package compose
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	networkingv1 "canary-crd/pkg/networking/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// Options of the conversion.
+type Options struct {
+	// Name of the App. Defaults to the top-level name of the compose file.
+	Name string
+	// Namespace of the App.
+	Namespace string
+	// Version names the version of every MicroService. Defaults to v1.
+	Version string
+	// IngressDomain enables Ingresses for the published ports of the services:
+	// the first published port of a service is served on <service>.<domain>,
+	// the others on <service>-<port>.<domain>.
+	IngressDomain string
+	// IngressClassName of the generated Ingresses.
+	IngressClassName string
+}
+
+// Convert converts the docker-compose file data into an App. It returns the
+// App and warnings about what the App does not keep.
+func Convert(data []byte, opts Options) (*appv1.App, []string, error) {
+	f := &file{}
+	if err := yaml.Unmarshal(data, f); err != nil {
+		return nil, nil, err
+	}
+	if f.Version != "" && !strings.HasPrefix(f.Version, "3") {
+		return nil, nil, fmt.Errorf("compose file version %s is not supported, only version 3 is", f.Version)
+	}
+	if len(f.Services) == 0 {
+		return nil, nil, fmt.Errorf("the compose file has no services")
+	}
+
+	c := &converter{opts: opts}
+	if c.opts.Name == "" {
+		c.opts.Name = f.Name
+	}
+	if c.opts.Name == "" {
+		return nil, nil, fmt.Errorf("the compose file has no name, the App needs one")
+	}
+	if c.opts.Version == "" {
+		c.opts.Version = "v1"
+	}
+
+	var top map[string]json.RawMessage
+	if err := yaml.Unmarshal(data, &top); err != nil {
+		return nil, nil, err
+	}
+	for _, key := range sortedKeys(top) {
+		switch key {
+		case "version", "name", "services":
+		default:
+			c.warnf("ignored the top-level %s", key)
+		}
+	}
+
+	// the names of the MicroServices and of their Services, by compose service
+	names := make(map[string]string)
+	for name := range f.Services {
+		names[name] = serviceName(name)
+		if errs := validation.IsDNS1035Label(names[name]); len(errs) != 0 {
+			return nil, nil, fmt.Errorf("service %s cannot be named %s: %s", name, names[name], strings.Join(errs, ", "))
+		}
+		if names[name] != name {
+			c.warnf("service %s is reached as %s", name, names[name])
+		}
+	}
+
+	app := &appv1.App{
+		TypeMeta:   metav1.TypeMeta{APIVersion: appv1.SchemeGroupVersion.String(), Kind: "App"},
+		ObjectMeta: metav1.ObjectMeta{Name: c.opts.Name, Namespace: c.opts.Namespace},
+	}
+	for _, name := range sortedKeys(f.Services) {
+		ms, err := c.microService(names[name], f.Services[name], names)
+		if err != nil {
+			return nil, nil, fmt.Errorf("service %s: %v", name, err)
+		}
+		app.Spec.MicroServices = append(app.Spec.MicroServices, *ms)
+	}
+	return app, c.warnings, nil
+}
+
+type converter struct {
+	opts     Options
+	warnings []string
+}
+
+func (c *converter) warnf(format string, args ...interface{}) {
+	c.warnings = append(c.warnings, fmt.Sprintf(format, args...))
+}
+
+// microService converts the compose service raw into the MicroService name.
+func (c *converter) microService(name string, raw json.RawMessage, names map[string]string) (*appv1.MicroServiceTemplate, error) {
+	svc := &service{}
+	if err := json.Unmarshal(raw, svc); err != nil {
+		return nil, err
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return nil, err
+	}
+	for _, key := range sortedKeys(keys) {
+		if !serviceKeys[key] {
+			c.warnf("ignored %s of service %s", key, name)
+		}
+	}
+	if svc.Image == "" {
+		return nil, fmt.Errorf("no image, build and push the image and set it")
+	}
+	if svc.Build != nil {
+		c.warnf("service %s runs image %s instead of building it", name, svc.Image)
+	}
+
+	container := corev1.Container{
+		Name:       name,
+		Image:      svc.Image,
+		Command:    svc.Entrypoint,
+		Args:       svc.Command,
+		WorkingDir: svc.WorkingDir,
+	}
+	for _, env := range sortedKeys(svc.Environment) {
+		value := svc.Environment[env]
+		if value == nil {
+			c.warnf("variable %s of service %s takes its value from the host, set it in the App", env, name)
+			container.Env = append(container.Env, corev1.EnvVar{Name: env})
+			continue
+		}
+		container.Env = append(container.Env, corev1.EnvVar{Name: env, Value: *value})
+	}
+
+	labels := map[string]string{"app": name}
+	version := appv1.DeployVersion{
+		Name: c.opts.Version,
+		Template: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+			},
+		},
+	}
+	if svc.Deploy != nil {
+		for _, key := range sortedKeys(keysOf(keys["deploy"])) {
+			if !deployKeys[key] {
+				c.warnf("ignored deploy.%s of service %s", key, name)
+			}
+		}
+		version.Template.Replicas = svc.Deploy.Replicas
+		if svc.Deploy.Resources != nil {
+			var err error
+			if container.Resources.Limits, err = convertResources(svc.Deploy.Resources.Limits); err != nil {
+				return nil, err
+			}
+			if container.Resources.Requests, err = convertResources(svc.Deploy.Resources.Reservations); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if svc.Healthcheck != nil {
+		probe, err := readinessProbe(svc.Healthcheck)
+		if err != nil {
+			return nil, fmt.Errorf("healthcheck: %v", err)
+		}
+		container.ReadinessProbe = probe
+	}
+	version.Template.Template.Spec.Containers = []corev1.Container{container}
+
+	ms := &appv1.MicroServiceTemplate{
+		Name: name,
+		Spec: appv1.MicroServiceSpec{
+			Versions:           []appv1.DeployVersion{version},
+			CurrentVersionName: version.Name,
+		},
+	}
+	for _, dependency := range svc.DependsOn {
+		if _, ok := names[dependency]; !ok {
+			return nil, fmt.Errorf("depends on unknown service %s", dependency)
+		}
+		ms.DependsOn = append(ms.DependsOn, names[dependency])
+	}
+	sort.Strings(ms.DependsOn)
+
+	lb, err := c.loadBalance(name, svc)
+	if err != nil {
+		return nil, err
+	}
+	ms.Spec.LoadBalance = lb
+	return ms, nil
+}
+
+// loadBalance returns a Service with the ports and exposed ports of svc, and
+// an Ingress for its published ports when Options.IngressDomain is set.
+func (c *converter) loadBalance(name string, svc *service) (*appv1.LoadBalance, error) {
+	type servicePort struct {
+		port      int32
+		protocol  corev1.Protocol
+		published string
+	}
+	var ports []servicePort
+	seen := make(map[string]bool)
+	add := func(target, protocol, published string) error {
+		numbers, err := portRange(target)
+		if err != nil {
+			return err
+		}
+		proto := corev1.ProtocolTCP
+		switch strings.ToLower(protocol) {
+		case "", "tcp":
+		case "udp":
+			proto = corev1.ProtocolUDP
+		case "sctp":
+			proto = corev1.ProtocolSCTP
+		default:
+			return fmt.Errorf("unknown protocol %s", protocol)
+		}
+		for _, number := range numbers {
+			key := fmt.Sprintf("%s/%d", proto, number)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			ports = append(ports, servicePort{port: number, protocol: proto, published: published})
+		}
+		return nil
+	}
+	for _, p := range svc.Ports {
+		if err := add(p.Target, p.Protocol, p.Published); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range svc.Expose {
+		target := parseShortPort(string(p))
+		if err := add(target.Target, target.Protocol, ""); err != nil {
+			return nil, err
+		}
+	}
+	if len(ports) == 0 {
+		// compose services reach each other on any port, a Service only on its ports
+		c.warnf("service %s has no Service, list the ports the other services use in its expose", name)
+		return nil, nil
+	}
+
+	spec := corev1.ServiceSpec{}
+	ingress := &appv1.IngressLoadBalance{Name: name}
+	for _, p := range ports {
+		// the other services reach the container port, the published port only
+		// exists on the host
+		spec.Ports = append(spec.Ports, corev1.ServicePort{
+			Name:       fmt.Sprintf("%s-%d", strings.ToLower(string(p.protocol)), p.port),
+			Protocol:   p.protocol,
+			Port:       p.port,
+			TargetPort: intstr.FromInt(int(p.port)),
+		})
+		if p.published == "" || c.opts.IngressDomain == "" {
+			continue
+		}
+		if p.protocol != corev1.ProtocolTCP {
+			c.warnf("published port %d/%s of service %s is not served by the Ingress", p.port, p.protocol, name)
+			continue
+		}
+		host := name + "." + c.opts.IngressDomain
+		if len(ingress.Spec.Rules) != 0 {
+			host = name + "-" + strconv.Itoa(int(p.port)) + "." + c.opts.IngressDomain
+		}
+		pathType := networkingv1.PathTypePrefix
+		ingress.Spec.Rules = append(ingress.Spec.Rules, networkingv1.IngressRule{
+			Host: host,
+			IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+				Paths: []networkingv1.HTTPIngressPath{{
+					Path:     "/",
+					PathType: &pathType,
+					Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+						Name: name,
+						Port: networkingv1.ServiceBackendPort{Number: p.port},
+					}},
+				}},
+			}},
+		})
+	}
+
+	lb := &appv1.LoadBalance{Service: &appv1.ServiceLoadBalance{Name: name, Spec: spec}}
+	if len(ingress.Spec.Rules) != 0 {
+		if c.opts.IngressClassName != "" {
+			className := c.opts.IngressClassName
+			ingress.Spec.IngressClassName = &className
+		}
+		lb.Ingress = ingress
+	}
+	return lb, nil
+}
+
+// readinessProbe converts the healthcheck into a readiness probe, the pods of
+// a version only receive traffic once they are healthy.
+func readinessProbe(h *healthcheck) (*corev1.Probe, error) {
+	if h.Disable || len(h.Test) == 0 || h.Test[0] == "NONE" {
+		return nil, nil
+	}
+	probe := &corev1.Probe{}
+	switch h.Test[0] {
+	case "CMD":
+		probe.Exec = &corev1.ExecAction{Command: h.Test[1:]}
+	case "CMD-SHELL":
+		probe.Exec = &corev1.ExecAction{Command: []string{"/bin/sh", "-c", strings.Join(h.Test[1:], " ")}}
+	default:
+		return nil, fmt.Errorf("test must start with NONE, CMD or CMD-SHELL")
+	}
+	for _, d := range []struct {
+		value   string
+		seconds *int32
+	}{
+		{h.Interval, &probe.PeriodSeconds},
+		{h.Timeout, &probe.TimeoutSeconds},
+		{h.StartPeriod, &probe.InitialDelaySeconds},
+	} {
+		if d.value == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, err
+		}
+		*d.seconds = int32((duration + time.Second - 1) / time.Second)
+	}
+	if h.Retries != nil {
+		probe.FailureThreshold = *h.Retries
+	}
+	return probe, nil
+}
+
+// byteUnits maps the units of compose sizes to the suffixes of quantities.
+var byteUnits = map[string]string{"": "", "b": "", "k": "Ki", "kb": "Ki", "m": "Mi", "mb": "Mi", "g": "Gi", "gb": "Gi"}
+
+var sizePattern = regexp.MustCompile(`^([0-9]+)([a-z]*)$`)
+
+// convertResources converts compose limits or reservations into a ResourceList.
+func convertResources(list *resourceList) (corev1.ResourceList, error) {
+	if list == nil {
+		return nil, nil
+	}
+	resources := corev1.ResourceList{}
+	if list.CPUs != "" {
+		cpu, err := resource.ParseQuantity(string(list.CPUs))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpus %s", list.CPUs)
+		}
+		resources[corev1.ResourceCPU] = cpu
+	}
+	if list.Memory != "" {
+		match := sizePattern.FindStringSubmatch(strings.ToLower(string(list.Memory)))
+		if match == nil {
+			return nil, fmt.Errorf("invalid memory %s", list.Memory)
+		}
+		unit, ok := byteUnits[match[2]]
+		if !ok {
+			return nil, fmt.Errorf("invalid memory %s", list.Memory)
+		}
+		resources[corev1.ResourceMemory] = resource.MustParse(match[1] + unit)
+	}
+	if len(resources) == 0 {
+		return nil, nil
+	}
+	return resources, nil
+}
+
+// serviceName returns the Kubernetes name of a compose service.
+func serviceName(name string) string {
+	return strings.Replace(strings.ToLower(name), "_", "-", -1)
+}
+
+// keysOf returns the keys of the JSON object raw.
+func keysOf(raw json.RawMessage) map[string]json.RawMessage {
+	var keys map[string]json.RawMessage
+	json.Unmarshal(raw, &keys)
+	return keys
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]json.RawMessage:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case environment:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
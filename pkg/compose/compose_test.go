@@ -0,0 +1,156 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compose
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/onsi/gomega"
+)
+
+const testCompose = `version: "3.8"
+services:
+  vote:
+    image: voting-demo-voting:v1
+    command: python app.py --port "8000"
+    environment:
+      REDIS_HOST: redis
+      DEBUG:
+    ports:
+      - "8080:8000"
+      - 9000
+    depends_on:
+      - redis
+    deploy:
+      replicas: 2
+      resources:
+        limits:
+          cpus: "0.5"
+          memory: 512M
+      placement:
+        constraints: [node.role == worker]
+    healthcheck:
+      test: curl -f http://localhost:8000/
+      interval: 10s
+      timeout: 1500ms
+      retries: 3
+  redis:
+    image: redis:5
+    expose:
+      - "6379"
+  result_app:
+    image: voting-demo-result:v1
+    environment:
+      - PORT=80
+    ports:
+      - target: 80
+        published: 8081
+      - "127.0.0.1:5000-5001:5000-5001/udp"
+    volumes:
+      - ./result:/app
+volumes:
+  data: {}
+`
+
+func TestConvert(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app, warnings, err := Convert([]byte(testCompose), Options{Name: "voting", IngressDomain: "example.com", IngressClassName: "nginx"})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(app.Name).To(gomega.Equal("voting"))
+	g.Expect(app.Spec.MicroServices).To(gomega.HaveLen(3))
+
+	redis := app.Spec.MicroServices[0]
+	g.Expect(redis.Name).To(gomega.Equal("redis"))
+	g.Expect(redis.Spec.CurrentVersionName).To(gomega.Equal("v1"))
+	g.Expect(redis.Spec.LoadBalance.Service.Name).To(gomega.Equal("redis"))
+	g.Expect(redis.Spec.LoadBalance.Service.Spec.Ports).To(gomega.HaveLen(1))
+	g.Expect(redis.Spec.LoadBalance.Service.Spec.Ports[0].Port).To(gomega.Equal(int32(6379)))
+	g.Expect(redis.Spec.LoadBalance.Ingress).To(gomega.BeNil())
+
+	result := app.Spec.MicroServices[1]
+	g.Expect(result.Name).To(gomega.Equal("result-app"))
+	ports := result.Spec.LoadBalance.Service.Spec.Ports
+	g.Expect(ports).To(gomega.HaveLen(3))
+	g.Expect(ports[0].Name).To(gomega.Equal("tcp-80"))
+	g.Expect(ports[2].Name).To(gomega.Equal("udp-5001"))
+	g.Expect(result.Spec.LoadBalance.Ingress.Spec.Rules).To(gomega.HaveLen(1))
+	g.Expect(result.Spec.LoadBalance.Ingress.Spec.Rules[0].Host).To(gomega.Equal("result-app.example.com"))
+
+	vote := app.Spec.MicroServices[2]
+	g.Expect(vote.DependsOn).To(gomega.Equal([]string{"redis"}))
+	version := vote.Spec.Versions[0]
+	g.Expect(*version.Template.Replicas).To(gomega.Equal(int32(2)))
+	g.Expect(version.Template.Selector.MatchLabels).To(gomega.Equal(version.Template.Template.Labels))
+	container := version.Template.Template.Spec.Containers[0]
+	g.Expect(container.Image).To(gomega.Equal("voting-demo-voting:v1"))
+	g.Expect(container.Args).To(gomega.Equal([]string{"python", "app.py", "--port", "8000"}))
+	g.Expect(container.Env).To(gomega.Equal([]corev1.EnvVar{{Name: "DEBUG"}, {Name: "REDIS_HOST", Value: "redis"}}))
+	g.Expect(container.Resources.Limits[corev1.ResourceMemory]).To(gomega.Equal(resource.MustParse("512Mi")))
+	cpu := container.Resources.Limits[corev1.ResourceCPU]
+	g.Expect(cpu.MilliValue()).To(gomega.Equal(int64(500)))
+	g.Expect(container.ReadinessProbe.Exec.Command).To(gomega.Equal([]string{"/bin/sh", "-c", "curl -f http://localhost:8000/"}))
+	g.Expect(container.ReadinessProbe.PeriodSeconds).To(gomega.Equal(int32(10)))
+	g.Expect(container.ReadinessProbe.TimeoutSeconds).To(gomega.Equal(int32(2)))
+	g.Expect(container.ReadinessProbe.FailureThreshold).To(gomega.Equal(int32(3)))
+	rules := vote.Spec.LoadBalance.Ingress.Spec.Rules
+	g.Expect(rules).To(gomega.HaveLen(1))
+	g.Expect(rules[0].Host).To(gomega.Equal("vote.example.com"))
+	g.Expect(rules[0].HTTP.Paths[0].Backend.Service.Port.Number).To(gomega.Equal(int32(8000)))
+	g.Expect(*vote.Spec.LoadBalance.Ingress.Spec.IngressClassName).To(gomega.Equal("nginx"))
+
+	g.Expect(strings.Join(warnings, "\n")).To(gomega.And(
+		gomega.ContainSubstring("ignored the top-level volumes"),
+		gomega.ContainSubstring("ignored volumes of service result-app"),
+		gomega.ContainSubstring("ignored deploy.placement of service vote"),
+		gomega.ContainSubstring("variable DEBUG of service vote"),
+		gomega.ContainSubstring("service result_app is reached as result-app"),
+		gomega.ContainSubstring("published port 5000/UDP"),
+		gomega.Not(gomega.ContainSubstring("service redis has no Service")),
+	))
+
+	// without a domain no Ingress is generated
+	app, _, err = Convert([]byte(testCompose), Options{Name: "voting"})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(app.Spec.MicroServices[2].Spec.LoadBalance.Ingress).To(gomega.BeNil())
+
+	_, _, err = Convert([]byte("version: '2'\nservices:\n  web:\n    image: web\n"), Options{Name: "voting"})
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("only version 3")))
+	_, _, err = Convert([]byte("services:\n  web:\n    build: .\n"), Options{Name: "voting"})
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("service web: no image")))
+}
+
+func TestSplitCommand(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	for s, expected := range map[string][]string{
+		`nginx -g 'daemon off;'`: {"nginx", "-g", "daemon off;"},
+		`sh -c "echo \"$HOME\""`: {"sh", "-c", `echo "$HOME"`},
+		`echo a\ b  c`:           {"echo", "a b", "c"},
+		`echo ''`:                {"echo", ""},
+	} {
+		args, err := splitCommand(s)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(args).To(gomega.Equal(expected), s)
+	}
+	_, err := splitCommand(`echo "a`)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
@@ -0,0 +1,298 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compose
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// file is a docker-compose file. Only the keys the conversion reads are decoded,
+// the others are listed in the warnings.
+type file struct {
+	Version  string                     `json:"version,omitempty"`
+	Name     string                     `json:"name,omitempty"`
+	Services map[string]json.RawMessage `json:"services"`
+}
+
+// service is a service of a docker-compose file.
+type service struct {
+	Image       string           `json:"image,omitempty"`
+	Command     command          `json:"command,omitempty"`
+	Entrypoint  command          `json:"entrypoint,omitempty"`
+	Environment environment      `json:"environment,omitempty"`
+	WorkingDir  string           `json:"working_dir,omitempty"`
+	Ports       []port           `json:"ports,omitempty"`
+	Expose      []scalar         `json:"expose,omitempty"`
+	DependsOn   dependsOn        `json:"depends_on,omitempty"`
+	Deploy      *deploy          `json:"deploy,omitempty"`
+	Healthcheck *healthcheck     `json:"healthcheck,omitempty"`
+	Build       *json.RawMessage `json:"build,omitempty"`
+}
+
+// serviceKeys are the keys of a service the conversion reads. restart and
+// container_name have no equivalent and are dropped without a warning: pods
+// are always restarted, and their names are chosen by the workloads.
+var serviceKeys = map[string]bool{
+	"image":          true,
+	"command":        true,
+	"entrypoint":     true,
+	"environment":    true,
+	"working_dir":    true,
+	"ports":          true,
+	"expose":         true,
+	"depends_on":     true,
+	"deploy":         true,
+	"healthcheck":    true,
+	"build":          true,
+	"restart":        true,
+	"container_name": true,
+}
+
+type deploy struct {
+	Replicas  *int32     `json:"replicas,omitempty"`
+	Resources *resources `json:"resources,omitempty"`
+}
+
+// deployKeys are the keys of deploy the conversion reads.
+var deployKeys = map[string]bool{
+	"replicas":  true,
+	"resources": true,
+}
+
+type resources struct {
+	Limits       *resourceList `json:"limits,omitempty"`
+	Reservations *resourceList `json:"reservations,omitempty"`
+}
+
+type resourceList struct {
+	CPUs   scalar `json:"cpus,omitempty"`
+	Memory scalar `json:"memory,omitempty"`
+}
+
+type healthcheck struct {
+	Test        healthcheckTest `json:"test,omitempty"`
+	Interval    string          `json:"interval,omitempty"`
+	Timeout     string          `json:"timeout,omitempty"`
+	StartPeriod string          `json:"start_period,omitempty"`
+	Retries     *int32          `json:"retries,omitempty"`
+	Disable     bool            `json:"disable,omitempty"`
+}
+
+// scalar is a string or a number, compose accepts both for ports and sizes.
+type scalar string
+
+func (s *scalar) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*s = scalar(str)
+		return nil
+	}
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err != nil {
+		return fmt.Errorf("expected a string or a number, got %s", data)
+	}
+	*s = scalar(number.String())
+	return nil
+}
+
+// command is a list of arguments, or a string split the way a shell does.
+type command []string
+
+func (c *command) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return json.Unmarshal(data, (*[]string)(c))
+	}
+	args, err := splitCommand(str)
+	if err != nil {
+		return err
+	}
+	*c = args
+	return nil
+}
+
+// healthcheckTest is the test of a healthcheck. A string is run by a shell.
+type healthcheckTest []string
+
+func (t *healthcheckTest) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return json.Unmarshal(data, (*[]string)(t))
+	}
+	*t = healthcheckTest{"CMD-SHELL", str}
+	return nil
+}
+
+// environment is a list of NAME=VALUE or a map. A variable without a value
+// takes it from the environment of the host, its value is nil.
+type environment map[string]*string
+
+func (e *environment) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		var values map[string]*scalar
+		if err := json.Unmarshal(data, &values); err != nil {
+			return err
+		}
+		*e = make(environment, len(values))
+		for name, value := range values {
+			if value == nil {
+				(*e)[name] = nil
+				continue
+			}
+			str := string(*value)
+			(*e)[name] = &str
+		}
+		return nil
+	}
+	*e = make(environment, len(list))
+	for _, item := range list {
+		if i := strings.Index(item, "="); i >= 0 {
+			value := item[i+1:]
+			(*e)[item[:i]] = &value
+		} else {
+			(*e)[item] = nil
+		}
+	}
+	return nil
+}
+
+// dependsOn is a list of service names, or a map keyed by them.
+type dependsOn []string
+
+func (d *dependsOn) UnmarshalJSON(data []byte) error {
+	var conditions map[string]json.RawMessage
+	if err := json.Unmarshal(data, &conditions); err != nil {
+		return json.Unmarshal(data, (*[]string)(d))
+	}
+	*d = nil
+	for name := range conditions {
+		*d = append(*d, name)
+	}
+	return nil
+}
+
+// port is a port of a service, in the short syntax
+// [IP:][HOST[-HOST]:]CONTAINER[-CONTAINER][/PROTOCOL] or the long one.
+type port struct {
+	Target    string `json:"target"`
+	Published string `json:"published,omitempty"`
+	Protocol  string `json:"protocol,omitempty"`
+}
+
+func (p *port) UnmarshalJSON(data []byte) error {
+	var short scalar
+	if err := json.Unmarshal(data, &short); err == nil {
+		*p = parseShortPort(string(short))
+		return nil
+	}
+	var long struct {
+		Target    scalar `json:"target"`
+		Published scalar `json:"published,omitempty"`
+		Protocol  string `json:"protocol,omitempty"`
+	}
+	if err := json.Unmarshal(data, &long); err != nil {
+		return err
+	}
+	*p = port{Target: string(long.Target), Published: string(long.Published), Protocol: long.Protocol}
+	return nil
+}
+
+func parseShortPort(s string) port {
+	p := port{}
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		s, p.Protocol = s[:i], s[i+1:]
+	}
+	parts := strings.Split(s, ":")
+	p.Target = parts[len(parts)-1]
+	if len(parts) > 1 {
+		p.Published = parts[len(parts)-2]
+	}
+	return p
+}
+
+// portRange parses a port or a range of ports like 3000-3005.
+func portRange(s string) ([]int32, error) {
+	first, last := s, s
+	if i := strings.Index(s, "-"); i >= 0 {
+		first, last = s[:i], s[i+1:]
+	}
+	from, err := strconv.ParseInt(first, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", s)
+	}
+	to, err := strconv.ParseInt(last, 10, 32)
+	if err != nil || to < from || from < 1 || to > 65535 {
+		return nil, fmt.Errorf("invalid port %q", s)
+	}
+	var ports []int32
+	for port := from; port <= to; port++ {
+		ports = append(ports, int32(port))
+	}
+	return ports, nil
+}
+
+// splitCommand splits s into arguments the way a POSIX shell does, without
+// expanding anything.
+func splitCommand(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+			continue
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote in %q", s)
+			}
+			arg.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\"\\$`", s[i+1]) >= 0 {
+					i++
+				}
+				arg.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, fmt.Errorf("unterminated quote in %q", s)
+			}
+		case c == '\\' && i+1 < len(s):
+			i++
+			arg.WriteByte(s[i])
+		default:
+			arg.WriteByte(c)
+		}
+		inArg = true
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+// Default returns the configuration used when no configuration file is given.
+func Default() *ManagerConfig {
+	cfg := &ManagerConfig{}
+	SetDefaults(cfg)
+	return cfg
+}
+
+// SetDefaults fills the unset fields of cfg with their defaults.
+func SetDefaults(cfg *ManagerConfig) {
+	cfg.APIVersion = APIVersion
+	cfg.Kind = Kind
+	if cfg.LabelDomain == "" {
+		cfg.LabelDomain = "app.o0w0o.cn"
+	}
+	if cfg.Naming.Separator == "" {
+		cfg.Naming.Separator = "-"
+	}
+	if cfg.Naming.CanarySuffix == "" {
+		cfg.Naming.CanarySuffix = "-canary"
+	}
+	if cfg.Ingress.NginxAnnotationPrefix == "" {
+		cfg.Ingress.NginxAnnotationPrefix = "nginx.ingress.kubernetes.io"
+	}
+	if cfg.Concurrency.App == 0 {
+		cfg.Concurrency.App = 1
+	}
+	if cfg.Concurrency.MicroService == 0 {
+		cfg.Concurrency.MicroService = 1
+	}
+	if cfg.SyncPeriod.Duration == 0 {
+		cfg.SyncPeriod = metav1.Duration{Duration: 10 * time.Hour}
+	}
+	if cfg.StatefulSetProgressDeadline.Duration == 0 {
+		// the default progressDeadlineSeconds of Deployments
+		cfg.StatefulSetProgressDeadline = metav1.Duration{Duration: 10 * time.Minute}
+	}
+}
+
+// Load reads the configuration file at path, defaults the unset fields and
+// validates the result.
+func Load(path string) (*ManagerConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &ManagerConfig{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %v", path, err)
+	}
+	if cfg.APIVersion != APIVersion || cfg.Kind != Kind {
+		return nil, fmt.Errorf("%s is a %s %s, expected a %s %s", path, cfg.APIVersion, cfg.Kind, APIVersion, Kind)
+	}
+	SetDefaults(cfg)
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate returns an error describing the first invalid field of cfg.
+func (cfg *ManagerConfig) Validate() error {
+	if errs := validation.IsDNS1123Subdomain(cfg.LabelDomain); len(errs) > 0 {
+		return fmt.Errorf("labelDomain %q: %s", cfg.LabelDomain, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Subdomain(cfg.Ingress.NginxAnnotationPrefix); len(errs) > 0 {
+		return fmt.Errorf("ingress.nginxAnnotationPrefix %q: %s", cfg.Ingress.NginxAnnotationPrefix, strings.Join(errs, ", "))
+	}
+	// the separator and the suffix end up in object names, which only allow
+	// lower case alphanumeric characters, '-' and '.'
+	if errs := validation.IsDNS1123Subdomain("a" + cfg.Naming.Separator + "a" + cfg.Naming.CanarySuffix); len(errs) > 0 {
+		return fmt.Errorf("naming %q, %q: %s", cfg.Naming.Separator, cfg.Naming.CanarySuffix, strings.Join(errs, ", "))
+	}
+	if cfg.Concurrency.App < 0 || cfg.Concurrency.MicroService < 0 {
+		return fmt.Errorf("concurrency must be positive")
+	}
+	if cfg.SyncPeriod.Duration < 0 {
+		return fmt.Errorf("syncPeriod must be positive")
+	}
+	if cfg.StatefulSetProgressDeadline.Duration < 0 {
+		return fmt.Errorf("statefulSetProgressDeadline must be positive")
+	}
+	for feature := range cfg.FeatureGates {
+		switch feature {
+		case Monitoring, CertManager, NetworkPolicy:
+		default:
+			return fmt.Errorf("unknown feature gate %q", feature)
+		}
+	}
+	return nil
+}
+
+// Enabled returns whether the feature is on.
+func (cfg *ManagerConfig) Enabled(feature Feature) bool {
+	enabled, ok := cfg.FeatureGates[feature]
+	return !ok || enabled
+}
+
+// AppLabel is the label holding the name of the App of an object.
+func (cfg *ManagerConfig) AppLabel() string {
+	return cfg.LabelDomain + "/app"
+}
+
+// ServiceLabel is the label holding the name of the MicroService of an object.
+func (cfg *ManagerConfig) ServiceLabel() string {
+	return cfg.LabelDomain + "/service"
+}
+
+// VersionLabel is the label holding the name of the version of an object.
+func (cfg *ManagerConfig) VersionLabel() string {
+	return cfg.LabelDomain + "/version"
+}
+
+// SnapshotLabel marks the configuration snapshots of the versions.
+func (cfg *ManagerConfig) SnapshotLabel() string {
+	return cfg.LabelDomain + "/snapshot"
+}
+
+// NginxAnnotation returns the nginx ingress controller annotation with the name.
+func (cfg *ManagerConfig) NginxAnnotation(name string) string {
+	return cfg.Ingress.NginxAnnotationPrefix + "/" + name
+}
+
+// Name joins the names of an object and of its parts with the separator.
+func (cfg *ManagerConfig) Name(names ...string) string {
+	return strings.Join(names, cfg.Naming.Separator)
+}
+
+// CanaryName returns the name of the canary Ingress of name.
+func (cfg *ManagerConfig) CanaryName(name string) string {
+	return name + cfg.Naming.CanarySuffix
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+)
+
+func writeConfig(t *testing.T, data string) string {
+	dir, err := ioutil.TempDir("", "manager-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	path := writeConfig(t, `
+apiVersion: config.app.o0w0o.cn/v1alpha1
+kind: ManagerConfig
+labelDomain: apps.example.com
+ingress:
+  nginxAnnotationPrefix: nginx.example.com
+  defaultClass: internal
+concurrency:
+  microService: 4
+syncPeriod: 30m
+featureGates:
+  Monitoring: false
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	cfg, err := Load(path)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(cfg.ServiceLabel()).To(gomega.Equal("apps.example.com/service"))
+	g.Expect(cfg.NginxAnnotation("canary")).To(gomega.Equal("nginx.example.com/canary"))
+	g.Expect(cfg.Ingress.DefaultClass).To(gomega.Equal("internal"))
+	g.Expect(cfg.Concurrency).To(gomega.Equal(ConcurrencyConfig{App: 1, MicroService: 4}))
+	g.Expect(cfg.SyncPeriod.Duration).To(gomega.Equal(30 * time.Minute))
+	g.Expect(cfg.StatefulSetProgressDeadline.Duration).To(gomega.Equal(10 * time.Minute))
+	g.Expect(cfg.Enabled(Monitoring)).To(gomega.BeFalse())
+	g.Expect(cfg.Enabled(CertManager)).To(gomega.BeTrue())
+	// unset fields keep their defaults
+	g.Expect(cfg.CanaryName(cfg.Name("voting", "v2"))).To(gomega.Equal("voting-v2-canary"))
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"kind":         "apiVersion: config.app.o0w0o.cn/v1alpha1\nkind: Config\n",
+		"version":      "apiVersion: config.app.o0w0o.cn/v1\nkind: ManagerConfig\n",
+		"field":        "apiVersion: config.app.o0w0o.cn/v1alpha1\nkind: ManagerConfig\nlabelPrefix: example.com\n",
+		"domain":       "apiVersion: config.app.o0w0o.cn/v1alpha1\nkind: ManagerConfig\nlabelDomain: Example_com\n",
+		"separator":    "apiVersion: config.app.o0w0o.cn/v1alpha1\nkind: ManagerConfig\nnaming:\n  separator: _\n",
+		"feature gate": "apiVersion: config.app.o0w0o.cn/v1alpha1\nkind: ManagerConfig\nfeatureGates:\n  Tracing: true\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			g := gomega.NewGomegaWithT(t)
+			path := writeConfig(t, data)
+			defer os.RemoveAll(filepath.Dir(path))
+
+			_, err := Load(path)
+			g.Expect(err).To(gomega.HaveOccurred())
+		})
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v1alpha1 contains the v1alpha1 version of the manager configuration
+// file, which sets the label domain, the naming scheme of the created objects
+// and the other domain-wide constants the controllers use.
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// APIVersion is the apiVersion of the configuration file.
+	APIVersion = "config.app.o0w0o.cn/v1alpha1"
+	// Kind is the kind of the configuration file.
+	Kind = "ManagerConfig"
+)
+
+// Feature is the name of an optional feature turned on or off by FeatureGates.
+type Feature string
+
+const (
+	// Monitoring creates ServiceMonitors and PrometheusRules for MicroServices declaring metrics.
+	Monitoring Feature = "Monitoring"
+	// CertManager requests cert-manager certificates for the Ingresses declaring TLS.
+	CertManager Feature = "CertManager"
+	// NetworkPolicy creates the NetworkPolicies of MicroServices and the default deny policies of Apps.
+	NetworkPolicy Feature = "NetworkPolicy"
+)
+
+// ManagerConfig is the configuration file of the manager.
+type ManagerConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// LabelDomain is the domain of the labels set on the created objects,
+	// for example <LabelDomain>/service. Defaults to app.o0w0o.cn.
+	LabelDomain string `json:"labelDomain,omitempty"`
+
+	// Naming configures the names of the created objects.
+	Naming NamingConfig `json:"naming,omitempty"`
+
+	// Ingress configures the created Ingresses.
+	Ingress IngressConfig `json:"ingress,omitempty"`
+
+	// Concurrency is the number of reconciles each controller runs at once.
+	Concurrency ConcurrencyConfig `json:"concurrency,omitempty"`
+
+	// SyncPeriod is the interval at which every watched object is reconciled
+	// again. Defaults to 10h.
+	SyncPeriod metav1.Duration `json:"syncPeriod,omitempty"`
+
+	// StatefulSetProgressDeadline is how long a StatefulSet taking part in an
+	// App release may stay unready on a step before the release is rolled back.
+	// StatefulSets have no progressDeadlineSeconds of their own. Defaults to 10m.
+	StatefulSetProgressDeadline metav1.Duration `json:"statefulSetProgressDeadline,omitempty"`
+
+	// FeatureGates turns the optional features on or off. All features are on
+	// by default.
+	FeatureGates map[Feature]bool `json:"featureGates,omitempty"`
+}
+
+// NamingConfig configures the names of the created objects.
+type NamingConfig struct {
+	// Separator joins the name of an object and the name of its part, for
+	// example <app><Separator><microservice> and <microservice><Separator><version>.
+	// Defaults to "-".
+	Separator string `json:"separator,omitempty"`
+
+	// CanarySuffix is appended to the names of the canary Ingresses. Defaults
+	// to "-canary".
+	CanarySuffix string `json:"canarySuffix,omitempty"`
+}
+
+// IngressConfig configures the created Ingresses.
+type IngressConfig struct {
+	// NginxAnnotationPrefix is the prefix of the canary annotations read by the
+	// nginx ingress controller. Defaults to nginx.ingress.kubernetes.io.
+	NginxAnnotationPrefix string `json:"nginxAnnotationPrefix,omitempty"`
+
+	// DefaultClass is the ingress class of the Ingresses that do not set one.
+	// The class is left unset when empty.
+	DefaultClass string `json:"defaultClass,omitempty"`
+}
+
+// ConcurrencyConfig is the number of reconciles each controller runs at once.
+type ConcurrencyConfig struct {
+	// App defaults to 1.
+	App int `json:"app,omitempty"`
+	// MicroService defaults to 1.
+	MicroService int `json:"microService,omitempty"`
+}
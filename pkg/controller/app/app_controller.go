@@ -18,10 +18,14 @@ package app
 
 import (
 	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	"canary-crd/pkg/render"
 	"context"
 	"k8s.io/apimachinery/pkg/types"
 	"reflect"
+	"time"
 
+	k8snetworkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -41,20 +45,20 @@ var log = logf.Log.WithName("controller")
  */
 
 // Add 创建一个新的 App 控制器并将其添加到 Manager 中。Manager 会设置控制器的字段
-// 并在 Manager 启动时启动它。
-func Add(mgr manager.Manager) error {
-	return add(mgr, newReconciler(mgr))
+// 并在 Manager 启动时启动它。cfg 是 Manager 的配置文件。
+func Add(mgr manager.Manager, cfg *configv1alpha1.ManagerConfig) error {
+	return add(mgr, newReconciler(mgr, cfg), cfg)
 }
 
 // newReconciler 返回一个新的 reconcile.Reconciler
-func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileApp{Client: mgr.GetClient(), scheme: mgr.GetScheme()}
+func newReconciler(mgr manager.Manager, cfg *configv1alpha1.ManagerConfig) reconcile.Reconciler {
+	return &ReconcileApp{Client: mgr.GetClient(), scheme: mgr.GetScheme(), config: cfg}
 }
 
-// add 将新的 Controller 添加到 mgr 中，r 作为 reconcile.Reconciler
-func add(mgr manager.Manager, r reconcile.Reconciler) error {
+// add 将新的 Controller 添加到 mgr 中，r 作为 reconcile.Reconciler，并发数由 cfg 决定
+func add(mgr manager.Manager, r reconcile.Reconciler, cfg *configv1alpha1.ManagerConfig) error {
 	// 创建一个新的控制器
-	c, err := controller.New("app-controller", mgr, controller.Options{Reconciler: r})
+	c, err := controller.New("app-controller", mgr, controller.Options{Reconciler: r, MaxConcurrentReconciles: cfg.Concurrency.App})
 	if err != nil {
 		return err
 	}
@@ -74,6 +78,15 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// 监视由 App 创建的默认拒绝 NetworkPolicy
+	err = c.Watch(&source.Kind{Type: &k8snetworkingv1.NetworkPolicy{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &appv1.App{},
+	})
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -83,6 +96,7 @@ var _ reconcile.Reconciler = &ReconcileApp{}
 type ReconcileApp struct {
 	client.Client
 	scheme *runtime.Scheme
+	config *configv1alpha1.ManagerConfig
 }
 
 //这个方法的主要作用是处理 App 对象的变化，包括创建、更新和删除。当 App 对象发生变化时，Kubernetes 会调用这个方法。
@@ -98,6 +112,7 @@ type ReconcileApp struct {
 // +kubebuilder:rbac:groups=apps,resources=deployments/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=app.o0w0o.cn,resources=apps,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=app.o0w0o.cn,resources=apps/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
 func (r *ReconcileApp) Reconcile(request reconcile.Request) (reconcile.Result, error) {
 	// 获取 App 实例
 	instance := &appv1.App{}
@@ -117,6 +132,43 @@ func (r *ReconcileApp) Reconcile(request reconcile.Request) (reconcile.Result, e
 		return reconcile.Result{}, nil
 	}
 
+	// 解析 MicroService 之间的依赖关系，非法的依赖关系（如循环依赖）会被拒绝
+	order, err := render.RolloutOrder(instance)
+	if err != nil {
+		log.Error(err, "Invalid MicroService dependencies", "namespace", instance.Namespace, "name", instance.Name)
+		return reconcile.Result{}, r.rejectApp(instance, "InvalidDependencies", err.Error())
+	}
+	if err := r.syncRolloutOrder(instance, order); err != nil {
+		log.Info("Sync App rollout order error", err)
+		return reconcile.Result{}, err
+	}
+
+	// 推进 App 级别的协同发布
+	var requeueAfter time.Duration
+	if instance.Spec.Release != nil {
+		if err := validateRelease(instance); err != nil {
+			log.Error(err, "Invalid App release", "namespace", instance.Namespace, "name", instance.Name)
+			return reconcile.Result{}, r.rejectApp(instance, "InvalidRelease", err.Error())
+		}
+	}
+	if requeueAfter, err = r.syncRelease(instance); err != nil {
+		log.Info("Sync App release error", err)
+		return reconcile.Result{}, err
+	}
+
+	// 根据 release 推进之后的状态渲染 App 的期望状态
+	objects, err := render.App(r.config, instance)
+	if err != nil {
+		log.Info("Render App error", err)
+		return reconcile.Result{}, err
+	}
+
+	// 同步 App 的默认拒绝 NetworkPolicy
+	if err := r.syncDefaultDeny(instance, objects); err != nil {
+		log.Info("Sync App default deny NetworkPolicy error", err)
+		return reconcile.Result{}, err
+	}
+
 	// 同步 App 的状态
 	if err := r.syncAppStatus(instance); err != nil {
 		log.Info("Sync App error", err)
@@ -124,7 +176,7 @@ func (r *ReconcileApp) Reconcile(request reconcile.Request) (reconcile.Result, e
 	}
 
 	// 处理与 App 关联的 MicroService
-	if err := r.reconcileMicroService(request, instance); err != nil {
+	if err := r.reconcileMicroService(instance, objects); err != nil {
 		log.Info("Creating MicroService error", err)
 		return reconcile.Result{}, err
 	}
@@ -142,5 +194,5 @@ func (r *ReconcileApp) Reconcile(request reconcile.Request) (reconcile.Result, e
 		}
 	}
 	// 返回 reconcile.Result 和 nil 错误，表示 reconcile 操作成功完成
-	return reconcile.Result{}, nil
+	return reconcile.Result{RequeueAfter: requeueAfter}, nil
 }
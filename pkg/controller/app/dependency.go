@@ -0,0 +1,26 @@
+package app
+
+import (
+	appv1 "canary-crd/pkg/apis/app/v1"
+)
+
+//dependency.go: 这个文件负责 App 中 MicroService 之间的 dependsOn 依赖关系。
+//发布顺序由 render.RolloutOrder 根据依赖关系计算，遇到未知依赖或循环依赖时返回错误；依赖的 MicroService 是否可用在这里判断。
+
+// isMicroServiceAvailable 判断 MicroService 是否已经处于 Available 状态，
+// 即所有版本都已经创建，并且除 Drifted 之外最近一次的 Condition 为 Available。
+func isMicroServiceAvailable(ms *appv1.MicroService) bool {
+	status := ms.Status
+	if status.TotalVersions == 0 || status.AvailableVersions != status.TotalVersions {
+		return false
+	}
+	// Drifted Condition 由 ReportOnly 模式单独维护，与 MicroService 是否可用无关
+	for i := len(status.Conditions) - 1; i >= 0; i-- {
+		cond := status.Conditions[i]
+		if cond.Type == appv1.MicroServiceDrifted {
+			continue
+		}
+		return cond.Type == appv1.MicroServiceAvailable && cond.Status == appv1.ConditionTrue
+	}
+	return false
+}
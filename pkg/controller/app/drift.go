@@ -0,0 +1,49 @@
+package app
+
+import (
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	"canary-crd/pkg/controller/syncer"
+	"canary-crd/pkg/render"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+//drift.go: 这个文件为 canaryctl diff 计算 App 渲染出的 MicroService 与集群中的 MicroService 之间的差异。
+//它与 reconcileMicroService 使用同样的 syncer 比较逻辑和孤儿 MicroService 的判断，但不修改集群中的任何对象。
+
+// Drift 返回 App 控制器将要创建、更新和删除的 MicroService。dependsOn 的等待不影响结果，所有 MicroService 都会被比较。
+func Drift(c client.Client, scheme *runtime.Scheme, cfg *configv1alpha1.ManagerConfig, app *appv1.App, objects *render.AppObjects) ([]appv1.DriftedObject, error) {
+	var drift []appv1.DriftedObject
+	newMicroServices := make(map[string]*appv1.MicroService)
+	for _, ms := range objects.MicroServices {
+		if err := controllerutil.SetControllerReference(app, ms, scheme); err != nil {
+			return nil, err
+		}
+		newMicroServices[ms.Name] = ms
+
+		d, err := syncer.Diff(c, ms, mutateMicroService(ms))
+		if err != nil {
+			return nil, err
+		}
+		if d == nil {
+			continue
+		}
+		obj := appv1.DriftedObject{Kind: "MicroService", Name: ms.Name, Action: appv1.DriftUpdate, Fields: d.Fields}
+		if d.Missing {
+			obj.Action = appv1.DriftCreate
+		}
+		drift = append(drift, obj)
+	}
+
+	orphans, err := orphanMicroServices(c, cfg, app, newMicroServices)
+	if err != nil {
+		return nil, err
+	}
+	for _, ms := range orphans {
+		drift = append(drift, appv1.DriftedObject{Kind: "MicroService", Name: ms.Name, Action: appv1.DriftDelete})
+	}
+	return drift, nil
+}
@@ -2,6 +2,9 @@ package app
 
 import (
 	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	"canary-crd/pkg/controller/syncer"
+	"canary-crd/pkg/render"
 	"context"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -9,7 +12,6 @@ import (
 	"reflect"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
-	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 //这个文件定义了 App 控制器的行为。App 控制器负责监视 App 资源的变化，并根据 App 资源的状态进行相应的操作。
@@ -17,7 +19,7 @@ import (
 
 //reconcileMicroService 方法在 instance.go 文件中，是 ReconcileApp 结构体的一个方法。它负责处理与 App 对象关联的 MicroService 对象。以下是该方法的主要逻辑：
 //
-//定义新的 MicroService 对象：首先，方法会创建一个新的 MicroService 对象的映射，这些对象是基于 App 对象的 Spec.MicroServices 字段创建的。
+//定义新的 MicroService 对象：MicroService 对象由 render 包根据 App 对象的 Spec.MicroServices 字段按照发布顺序渲染，并应用了 release 当前步骤的 Canary 配置。
 //
 //处理每个 MicroService：对于 App 对象的 Spec.MicroServices 字段中的每个 MicroService，方法会检查是否已经存在一个相同的 MicroService 对象。
 //如果不存在，则创建一个新的 MicroService 对象。如果存在，但其 Spec 字段与新的 MicroService 对象不同，则更新已存在的 MicroService 对象。
@@ -26,26 +28,18 @@ import (
 //
 //总的来说，reconcileMicroService 方法负责同步 App 对象和 MicroService 对象。当 App 对象发生变化时，方法会确保 Kubernetes 集群中的 MicroService 对象与 App 对象的状态保持一致。
 
-func (r *ReconcileApp) reconcileMicroService(req reconcile.Request, app *appv1.App) error {
-	// Define the desired MicroService object
-	labels := app.Labels
-	if labels == nil {
-		labels = make(map[string]string)
-	}
-	labels["app.o0w0o.cn/app"] = app.Name
+func (r *ReconcileApp) reconcileMicroService(app *appv1.App, objects *render.AppObjects) error {
 	newMicroServices := make(map[string]*appv1.MicroService)
 
+	templates := make(map[string]*appv1.MicroServiceTemplate)
 	for i := range app.Spec.MicroServices {
-		microService := &app.Spec.MicroServices[i]
-
-		ms := &appv1.MicroService{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      app.Name + "-" + microService.Name,
-				Namespace: app.Namespace,
-				Labels:    labels,
-			},
-			Spec: microService.Spec,
-		}
+		templates[app.Spec.MicroServices[i].Name] = &app.Spec.MicroServices[i]
+	}
+	available := make(map[string]bool)
+
+	for i, name := range objects.Order {
+		microService := templates[name]
+		ms := objects.MicroServices[i]
 		if err := controllerutil.SetControllerReference(app, ms, r.scheme); err != nil {
 			return err
 		}
@@ -54,36 +48,46 @@ func (r *ReconcileApp) reconcileMicroService(req reconcile.Request, app *appv1.A
 		// Check if the MicroService already exists
 		found := &appv1.MicroService{}
 		err := r.Get(context.TODO(), types.NamespacedName{Name: ms.Name, Namespace: ms.Namespace}, found)
-
-		if err != nil && errors.IsNotFound(err) {
-			log.Info("Creating MicroService", "namespace", ms.Namespace, "name", ms.Name)
-			if err = r.Create(context.TODO(), ms); err != nil {
-				return err
-			}
-		} else if err != nil {
+		if err != nil && !errors.IsNotFound(err) {
 			return err
 		}
+		exist := err == nil
 
-		if !reflect.DeepEqual(ms.Spec, found.Spec) {
-
-			found.Spec = ms.Spec
-			log.Info("find MS changed and Updating MicroService", "namespace", ms.Namespace, "name", ms.Name)
-			err = r.Update(context.TODO(), found)
-			if err != nil {
-				return err
-			}
+		// 依赖的 MicroService 尚未 Available 时，暂不创建或更新当前 MicroService，
+		// 等依赖的 MicroService 状态变化触发下一次 Reconcile。
+		if pending := pendingDependencies(microService, available); len(pending) != 0 {
+			log.Info("Waiting for MicroService dependencies", "namespace", ms.Namespace, "name", ms.Name, "pending", pending)
+			continue
+		}
 
-			err := r.Get(context.TODO(), types.NamespacedName{Name: ms.Name, Namespace: ms.Namespace}, found)
-			if err != nil {
-				return err
-			}
-			microService.Spec = found.Spec
+		if exist {
+			available[microService.Name] = isMicroServiceAvailable(found)
+		}
 
+		// MicroService 控制器会把 ServiceName 等字段写回 Spec，通过 syncer 只在 App 中的期望状态变化时更新，避免两个控制器互相覆盖。
+		result, err := syncer.Sync(r, ms, mutateMicroService(ms))
+		if err != nil {
+			return err
+		}
+		if result != syncer.OperationResultNone {
+			log.Info("Synced MicroService", "namespace", ms.Namespace, "name", ms.Name, "operation", result)
+			available[microService.Name] = false
 		}
 	}
 	return r.cleanUpMicroServices(app, newMicroServices)
 }
 
+// pendingDependencies 返回 MicroService 的依赖中尚未 Available 的那些。
+func pendingDependencies(microService *appv1.MicroServiceTemplate, available map[string]bool) []string {
+	var pending []string
+	for _, dep := range microService.DependsOn {
+		if !available[dep] {
+			pending = append(pending, dep)
+		}
+	}
+	return pending
+}
+
 // reconcileMicroService 方法的主要任务是确保 App 对象的 MicroService 子资源与 App 对象的期望状态保持一致。
 // 这个方法首先会根据 App 对象的 Spec.MicroServices 字段创建一个新的 MicroService 对象的映射，
 // 然后对比 Kubernetes 集群中实际存在的 MicroService 对象。
@@ -98,30 +102,47 @@ func (r *ReconcileApp) reconcileMicroService(req reconcile.Request, app *appv1.A
 // 在这两种情况下，reconcileMicroService 方法都会清理那些在新的 MicroService 对象映射中不存在，
 // 但在 Kubernetes 集群中存在的 MicroService 对象，以确保 Kubernetes 集群中的 MicroService 对象与 App 对象的期望状态保持一致。
 func (r *ReconcileApp) cleanUpMicroServices(app *appv1.App, msList map[string]*appv1.MicroService) error {
-	// Check if the MicroService not exists
-	ctx := context.Background()
+	orphans, err := orphanMicroServices(r, r.config, app, msList)
+	if err != nil {
+		return err
+	}
+	for i := range orphans {
+		oldMs := &orphans[i]
+		log.Info("Deleted orphan MS and will delete it", "namespace", app.Namespace, "App", app.Namespace, "MS", oldMs.Name)
+		err := r.Delete(context.TODO(), oldMs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mutateMicroService 只把 App 渲染出的 Spec 写入集群中的 MicroService。
+func mutateMicroService(ms *appv1.MicroService) syncer.MutateFn {
+	return func(obj syncer.Object) {
+		obj.(*appv1.MicroService).Spec = ms.Spec
+	}
+}
 
+// orphanMicroServices 返回集群中属于 App，但是不在 msList 中的 MicroService。
+func orphanMicroServices(c client.Reader, cfg *configv1alpha1.ManagerConfig, app *appv1.App, msList map[string]*appv1.MicroService) ([]appv1.MicroService, error) {
 	microServiceList := appv1.MicroServiceList{}
 	labels := make(map[string]string)
-	labels["app.o0w0o.cn/app"] = app.Name
+	labels[cfg.AppLabel()] = app.Name
 
-	if err := r.List(ctx, client.InNamespace(app.Namespace).
+	if err := c.List(context.Background(), client.InNamespace(app.Namespace).
 		MatchingLabels(labels), &microServiceList); err != nil {
 		log.Error(err, "unable to list old MicroServices")
-		return err
+		return nil, err
 	}
 
-	for i := range microServiceList.Items {
-		oldMs := &microServiceList.Items[i]
-		if _, exist := msList[oldMs.Name]; exist == false {
-			log.Info("Deleted orphan MS and will delete it", "namespace", app.Namespace, "App", app.Namespace, "MS", oldMs.Name)
-			err := r.Delete(context.TODO(), oldMs)
-			if err != nil {
-				return err
-			}
+	var orphans []appv1.MicroService
+	for _, ms := range microServiceList.Items {
+		if _, exist := msList[ms.Name]; !exist {
+			orphans = append(orphans, ms)
 		}
 	}
-	return nil
+	return orphans, nil
 }
 
 // syncAppStatus 方法的主要任务是同步 App 对象的状态。以下是该方法的主要逻辑：
@@ -171,11 +192,45 @@ func (r *ReconcileApp) syncAppStatus(app *appv1.App) error {
 		newStatus.Conditions = append(newStatus.Conditions, conditions[i])
 	}
 	newStatus.Conditions = append(newStatus.Conditions, condition)
+	newStatus.RolloutOrder = app.Status.RolloutOrder
+	newStatus.Release = app.Status.Release
 	app.Status = newStatus
 	err = r.Status().Update(ctx, app)
 	return err
 }
 
+// syncRolloutOrder 将解析出的 MicroService 发布顺序写入 App 的状态。
+func (r *ReconcileApp) syncRolloutOrder(app *appv1.App, order []string) error {
+	if reflect.DeepEqual(app.Status.RolloutOrder, order) {
+		return nil
+	}
+	app.Status.RolloutOrder = order
+	return r.Status().Update(context.Background(), app)
+}
+
+// rejectApp 在 App 的配置非法时记录一个 Failed 状态的 Condition。
+// 如果最近一次的 Condition 已经记录了相同的原因，则不再重复更新，避免触发新的 Reconcile。
+func (r *ReconcileApp) rejectApp(app *appv1.App, reason string, message string) error {
+	conditions := app.Status.Conditions
+	if n := len(conditions); n != 0 {
+		last := conditions[n-1]
+		if last.Type == appv1.AppFailed && last.Reason == reason && last.Message == message {
+			return nil
+		}
+	}
+	condition := appv1.AppCondition{
+		Type:               appv1.AppFailed,
+		Status:             appv1.ConditionTrue,
+		LastUpdateTime:     metav1.Now(),
+		LastTransitionTime: metav1.Now(),
+		Reason:             reason,
+		Message:            message,
+	}
+	app.Status.Conditions = append(app.Status.Conditions, condition)
+	app.Status.RolloutOrder = nil
+	return r.Status().Update(context.Background(), app)
+}
+
 //calculateStatus 方法的主要任务是计算 App 对象的新状态。以下是该方法的主要逻辑：
 //获取所有的 MicroService 对象：方法首先会获取 Kubernetes 集群中与 App 对象关联的所有 MicroService 对象。这些对象是通过匹配 App 对象的标签来获取的。
 //计算 AvailableMicroServices 和 TotalMicroServices：然后，方法会计算 AvailableMicroServices 和 TotalMicroServices 的值。
@@ -190,7 +245,7 @@ func (r *ReconcileApp) calculateStatus(app *appv1.App) (appv1.AppStatus, error)
 
 	msList := appv1.MicroServiceList{}
 	labels := make(map[string]string)
-	labels["app.o0w0o.cn/app"] = app.Name
+	labels[r.config.AppLabel()] = app.Name
 
 	al := int32(len(msList.Items))
 	tl := int32(len(app.Spec.MicroServices))
@@ -0,0 +1,55 @@
+package app
+
+import (
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	"canary-crd/pkg/controller/syncer"
+	"canary-crd/pkg/render"
+	"context"
+
+	k8snetworkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+//networkpolicy.go: 这个文件负责 App 级别的默认拒绝策略。
+//开启 DefaultDeny 后，App 拥有一个选中 App 所有 Pod 的 NetworkPolicy，它不允许任何入站流量，
+//每个 MicroService 通过自己的 NetworkPolicy 声明允许的调用方。
+
+// syncDefaultDeny 根据 App.Spec.DefaultDeny 创建或者删除默认拒绝的 NetworkPolicy。关闭 NetworkPolicy 特性时不做任何处理。
+func (r *ReconcileApp) syncDefaultDeny(app *appv1.App, objects *render.AppObjects) error {
+	if !r.config.Enabled(configv1alpha1.NetworkPolicy) {
+		return nil
+	}
+	policy := objects.DefaultDeny
+	found := &k8snetworkingv1.NetworkPolicy{}
+	err := r.Get(context.TODO(), types.NamespacedName{Name: render.DefaultDenyName(r.config, app), Namespace: app.Namespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exist := err == nil
+
+	if policy == nil {
+		if !exist || !metav1.IsControlledBy(found, app) {
+			return nil
+		}
+		log.Info("Deleting default deny NetworkPolicy", "namespace", found.Namespace, "name", found.Name)
+		return r.Delete(context.TODO(), found)
+	}
+
+	if err := controllerutil.SetControllerReference(app, policy, r.scheme); err != nil {
+		return err
+	}
+	result, err := syncer.Sync(r, policy, func(obj syncer.Object) {
+		obj.(*k8snetworkingv1.NetworkPolicy).Spec = policy.Spec
+	})
+	if err != nil {
+		return err
+	}
+	if result != syncer.OperationResultNone {
+		log.Info("Synced default deny NetworkPolicy", "namespace", policy.Namespace, "name", policy.Name, "operation", result)
+	}
+	return nil
+}
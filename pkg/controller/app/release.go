@@ -0,0 +1,124 @@
+package app
+
+import (
+	appv1 "canary-crd/pkg/apis/app/v1"
+	"canary-crd/pkg/render"
+	"canary-crd/pkg/workload"
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+//release.go: 这个文件负责处理 App 级别的协同发布（release train）。
+//一个 release 指定了多个 MicroService 各自的目标版本、一个共享的灰度选择器（header 或 cookie）和一个共享的权重计划。
+//App 控制器会同时驱动所有参与者的 Canary 配置，当任何一个参与者失败时，所有参与者一起回滚。
+//设置 spec.release.paused 之后，release 停留在当前步骤，直到取消暂停。
+//steps 被缩短之后，超出范围的当前步骤会被移到最后一个步骤。
+//推进 release 的规则在 render.AdvanceRelease 中，不需要 API Server 就能测试。
+
+// validateRelease 检查 release 的参与者和权重计划是否合法。
+func validateRelease(app *appv1.App) error {
+	release := app.Spec.Release
+	if len(release.Steps) == 0 {
+		return fmt.Errorf("release %q has no steps", release.Name)
+	}
+	if len(release.Participants) == 0 {
+		return fmt.Errorf("release %q has no participants", release.Name)
+	}
+	seen := make(map[string]bool)
+	for _, p := range release.Participants {
+		if seen[p.MicroService] {
+			return fmt.Errorf("release %q lists microservice %q more than once", release.Name, p.MicroService)
+		}
+		seen[p.MicroService] = true
+		if findReleaseVersion(app, p) == nil {
+			return fmt.Errorf("release %q targets unknown version %q of microservice %q", release.Name, p.Version, p.MicroService)
+		}
+	}
+	return nil
+}
+
+// findReleaseVersion 返回参与者在 App.Spec 中对应的 DeployVersion，不存在时返回 nil。
+func findReleaseVersion(app *appv1.App, p appv1.ReleaseParticipant) *appv1.DeployVersion {
+	for i := range app.Spec.MicroServices {
+		ms := &app.Spec.MicroServices[i]
+		if ms.Name != p.MicroService {
+			continue
+		}
+		for j := range ms.Spec.Versions {
+			if ms.Spec.Versions[j].Name == p.Version {
+				return &ms.Spec.Versions[j]
+			}
+		}
+	}
+	return nil
+}
+
+// syncRelease 推进 App 的 release 状态，并返回下一次需要重新检查的时间间隔。
+// 推进的规则由 render.AdvanceRelease 决定，这里只负责读取参与者的 Deployment 或 StatefulSet 并写回状态。
+func (r *ReconcileApp) syncRelease(app *appv1.App) (time.Duration, error) {
+	var participants []render.ParticipantStatus
+	if release := app.Spec.Release; release != nil {
+		for _, p := range release.Participants {
+			participant, err := r.readParticipant(app, p)
+			if err != nil {
+				return 0, err
+			}
+			participants = append(participants, participant)
+		}
+	}
+
+	changed, requeue := render.AdvanceRelease(app, participants, r.config.StatefulSetProgressDeadline.Duration, time.Now())
+	if !changed {
+		return requeue, nil
+	}
+	if status := app.Status.Release; status != nil {
+		log.Info("Updating App release", "namespace", app.Namespace, "app", app.Name, "release", status.Name, "phase", status.Phase, "step", status.CurrentStep, "message", status.Message)
+	}
+	return requeue, r.Status().Update(context.Background(), app)
+}
+
+// readParticipant 读取参与者目标版本的 Deployment 或 StatefulSet 的状态，不存在时 Created 为 false。
+func (r *ReconcileApp) readParticipant(app *appv1.App, p appv1.ReleaseParticipant) (render.ParticipantStatus, error) {
+	participant := render.ParticipantStatus{Name: r.config.Name(app.Name, p.MicroService, p.Version), Kind: appv1.DeploymentKind}
+	key := types.NamespacedName{Name: participant.Name, Namespace: app.Namespace}
+	if version := findReleaseVersion(app, p); version != nil && version.GetKind() == appv1.StatefulSetKind {
+		participant.Kind = appv1.StatefulSetKind
+		sts := &appsv1.StatefulSet{}
+		if err := r.Get(context.TODO(), key, sts); err != nil {
+			if errors.IsNotFound(err) {
+				return participant, nil
+			}
+			return participant, err
+		}
+		participant.Created = true
+		participant.Ready = workload.StatefulSetReady(sts)
+		return participant, nil
+	}
+
+	deploy := &appsv1.Deployment{}
+	if err := r.Get(context.TODO(), key, deploy); err != nil {
+		if errors.IsNotFound(err) {
+			return participant, nil
+		}
+		return participant, err
+	}
+	participant.Created = true
+	participant.Ready = workload.DeploymentReady(deploy)
+	participant.ProgressDeadlineExceeded = isDeploymentFailed(deploy)
+	return participant, nil
+}
+
+// isDeploymentFailed 判断 Deployment 是否因为超过 progressDeadlineSeconds 而失败。
+func isDeploymentFailed(deploy *appsv1.Deployment) bool {
+	for _, cond := range deploy.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Reason == "ProgressDeadlineExceeded" {
+			return true
+		}
+	}
+	return false
+}
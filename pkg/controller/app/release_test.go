@@ -0,0 +1,165 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"canary-crd/pkg/apis"
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	"canary-crd/pkg/render"
+
+	"github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSyncRelease(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(kubescheme.AddToScheme(scheme)).To(gomega.Succeed())
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	app := &appv1.App{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"},
+		Spec: appv1.AppSpec{
+			MicroServices: []appv1.MicroServiceTemplate{{
+				Name: "web",
+				Spec: appv1.MicroServiceSpec{Versions: []appv1.DeployVersion{{Name: "v1"}, {Name: "v2"}}, CurrentVersionName: "v1"},
+			}},
+			Release: &appv1.AppRelease{
+				Name:         "spring",
+				Participants: []appv1.ReleaseParticipant{{MicroService: "web", Version: "v2"}},
+				Steps:        []appv1.ReleaseStep{{Weight: 10}, {Weight: 50}, {Weight: 100}},
+			},
+		},
+	}
+	replicas := int32(1)
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop-web-v2", Namespace: "default"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+	r := &ReconcileApp{Client: fake.NewFakeClientWithScheme(scheme, app, deploy), scheme: scheme, config: configv1alpha1.Default()}
+	get := func() *appv1.App {
+		found := &appv1.App{}
+		g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: "shop", Namespace: "default"}, found)).To(gomega.Succeed())
+		return found
+	}
+
+	// the release starts at the first step
+	requeue, err := r.syncRelease(get())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(requeue).To(gomega.Equal(render.ReleaseWaitInterval))
+	g.Expect(get().Status.Release.Phase).To(gomega.Equal(appv1.ReleaseProgressing))
+	g.Expect(get().Status.Release.CurrentStep).To(gomega.Equal(int32(0)))
+
+	// and waits for the participants to be ready
+	_, err = r.syncRelease(get())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(get().Status.Release.CurrentStep).To(gomega.Equal(int32(0)))
+
+	deploy.Status = appsv1.DeploymentStatus{UpdatedReplicas: 1, AvailableReplicas: 1}
+	g.Expect(r.Update(context.TODO(), deploy)).To(gomega.Succeed())
+	_, err = r.syncRelease(get())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(get().Status.Release.CurrentStep).To(gomega.Equal(int32(1)))
+
+	// shortening the steps of the running release moves it to the last step
+	// instead of indexing past the end
+	app = get()
+	app.Status.Release.CurrentStep = 2
+	app.Spec.Release.Steps = app.Spec.Release.Steps[:2]
+	requeue, err = r.syncRelease(app)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(requeue).To(gomega.Equal(render.ReleaseWaitInterval))
+	g.Expect(app.Status.Release.CurrentStep).To(gomega.Equal(int32(1)))
+
+	// the last step completes the release
+	app.Status.Release.LastStepTime = metav1.NewTime(time.Now().Add(-time.Minute))
+	_, err = r.syncRelease(app)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(app.Status.Release.Phase).To(gomega.Equal(appv1.ReleaseSucceeded))
+
+	// a participant exceeding its progress deadline rolls the release back
+	app.Spec.Release.Name = "summer"
+	_, err = r.syncRelease(app)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	deploy.Status.Conditions = []appsv1.DeploymentCondition{{Type: appsv1.DeploymentProgressing, Reason: "ProgressDeadlineExceeded"}}
+	g.Expect(r.Update(context.TODO(), deploy)).To(gomega.Succeed())
+	_, err = r.syncRelease(app)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(app.Status.Release.Phase).To(gomega.Equal(appv1.ReleaseRolledBack))
+}
+
+func TestSyncReleaseStatefulSet(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(kubescheme.AddToScheme(scheme)).To(gomega.Succeed())
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	app := &appv1.App{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"},
+		Spec: appv1.AppSpec{
+			MicroServices: []appv1.MicroServiceTemplate{{
+				Name: "db",
+				Spec: appv1.MicroServiceSpec{Versions: []appv1.DeployVersion{
+					{Name: "v1", Kind: appv1.StatefulSetKind},
+					{Name: "v2", Kind: appv1.StatefulSetKind},
+				}, CurrentVersionName: "v1"},
+			}},
+			Release: &appv1.AppRelease{
+				Name:         "spring",
+				Participants: []appv1.ReleaseParticipant{{MicroService: "db", Version: "v2"}},
+				Steps:        []appv1.ReleaseStep{{Weight: 10}, {Weight: 100}},
+			},
+		},
+	}
+	replicas := int32(1)
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop-db-v2", Namespace: "default"},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+		Status:     appsv1.StatefulSetStatus{CurrentRevision: "v2-1", UpdateRevision: "v2-2"},
+	}
+	r := &ReconcileApp{Client: fake.NewFakeClientWithScheme(scheme, app, sts), scheme: scheme, config: configv1alpha1.Default()}
+
+	_, err := r.syncRelease(app)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(app.Status.Release.Phase).To(gomega.Equal(appv1.ReleaseProgressing))
+
+	// a StatefulSet that is not ready yet makes the release wait
+	requeue, err := r.syncRelease(app)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(requeue).To(gomega.Equal(render.ReleaseWaitInterval))
+	g.Expect(app.Status.Release.Phase).To(gomega.Equal(appv1.ReleaseProgressing))
+	g.Expect(app.Status.Release.CurrentStep).To(gomega.Equal(int32(0)))
+
+	// until its progress deadline has passed, then the release is rolled back
+	app.Status.Release.LastStepTime = metav1.NewTime(time.Now().Add(-11 * time.Minute))
+	_, err = r.syncRelease(app)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(app.Status.Release.Phase).To(gomega.Equal(appv1.ReleaseRolledBack))
+	g.Expect(app.Status.Release.Message).To(gomega.ContainSubstring("StatefulSet shop-db-v2"))
+}
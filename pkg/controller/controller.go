@@ -17,18 +17,20 @@ limitations under the License.
 package controller
 
 import (
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
 //controller.go: 这个文件定义了一个全局的函数列表和一个函数，这个函数会遍历这个列表并调用其中的每个函数，将所有控制器添加到管理器。这个函数在 main.go 中被调用。
 
 // AddToManagerFuncs is a list of functions to add all Controllers to the Manager
-var AddToManagerFuncs []func(manager.Manager) error
+var AddToManagerFuncs []func(manager.Manager, *configv1alpha1.ManagerConfig) error
 
 // AddToManager adds all Controllers to the Manager
-func AddToManager(m manager.Manager) error {
+func AddToManager(m manager.Manager, cfg *configv1alpha1.ManagerConfig) error {
 	for _, f := range AddToManagerFuncs {
-		if err := f(m); err != nil {
+		if err := f(m, cfg); err != nil {
 			return err
 		}
 	}
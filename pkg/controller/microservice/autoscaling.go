@@ -0,0 +1,60 @@
+package microservice
+
+import (
+	appv1 "canary-crd/pkg/apis/app/v1"
+	"canary-crd/pkg/controller/syncer"
+	"canary-crd/pkg/render"
+	"context"
+
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+//autoscaling.go: 这个文件负责为每个版本的工作负载管理 HorizontalPodAutoscaler。
+//版本可以声明自己的 Autoscaling，否则使用 MicroService 的默认 Autoscaling。HPA 与工作负载同名，由 MicroService 拥有，
+//版本被删除或者重命名时，对应的 HPA 会像 Deployment 一样被清理。开启 ScaleWithWeight 后，灰度版本的最小和最大副本数会按照灰度权重等比例缩放。
+
+// reconcileAutoscaling 为每个开启了 Autoscaling 的版本创建或更新 HPA，并清理不再需要的 HPA。
+func (r *ReconcileMicroService) reconcileAutoscaling(microService *appv1.MicroService, objects *render.MicroServiceObjects) error {
+	stayHPAName := make(map[string]bool)
+	for _, version := range objects.Versions {
+		hpa := version.HPA
+		if hpa == nil {
+			continue
+		}
+
+		if err := controllerutil.SetControllerReference(microService, hpa, r.scheme); err != nil {
+			return err
+		}
+		if err := r.updateOrCreateHPA(microService, hpa); err != nil {
+			log.Error(err, "Set DeployVersion HPA error", "namespace", microService.Namespace, "microService", microService.Name, "Version", version.Name)
+			return err
+		}
+		stayHPAName[hpa.Name] = true
+	}
+
+	hpaList := &autoscalingv2beta2.HorizontalPodAutoscalerList{}
+	labels := map[string]string{r.config.ServiceLabel(): microService.Name}
+	if err := r.List(context.TODO(), client.InNamespace(microService.Namespace).MatchingLabels(labels), hpaList); err != nil {
+		return err
+	}
+	for i := range hpaList.Items {
+		hpa := &hpaList.Items[i]
+		if stayHPAName[hpa.Name] {
+			continue
+		}
+		log.Info("Find orphan HPA", "namespace", microService.Namespace, "MicroService", microService.Name, "HPA", hpa.Name)
+		if err := r.Delete(context.TODO(), hpa); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateOrCreateHPA 通过 syncer 创建或更新 HPA，只有期望的 HPA 发生变化时才会更新它。
+func (r *ReconcileMicroService) updateOrCreateHPA(microService *appv1.MicroService, hpa *autoscalingv2beta2.HorizontalPodAutoscaler) error {
+	return r.syncObject(microService, "HorizontalPodAutoscaler", hpa, func(found syncer.Object) {
+		found.(*autoscalingv2beta2.HorizontalPodAutoscaler).Spec = hpa.Spec
+	})
+}
@@ -0,0 +1,96 @@
+package microservice
+
+import (
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	"canary-crd/pkg/render"
+	"context"
+	"reflect"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+//certificate.go: 这个文件负责 LoadBalance 中 Ingress 的证书状态。
+//声明了 TLS 的 Ingress 由 render 包加上 cert-manager 的 issuer 注解和对应的 TLS 配置，由 cert-manager 的 ingress-shim 创建与 Secret 同名的 Certificate。
+//灰度 Ingress 复用主 Ingress 的 TLS 配置和 Secret，但不带 issuer 注解，因此不会各自申请证书。
+//为了不依赖 cert-manager 的类型，Certificate 以 unstructured 的方式读取，证书是否就绪记录在 MicroService 的 Status 中。
+
+// certificateWaitInterval 是等待证书签发时重新检查的间隔
+const certificateWaitInterval = 30 * time.Second
+
+var certificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+// syncCertificateStatus 读取 LoadBalance 中每个声明了 TLS 的 Ingress 对应的 Certificate，并把是否就绪写入 MicroService 的 Status。
+// 返回值表示是否有证书正在等待签发，需要稍后重新检查。集群中没有安装 cert-manager 的 CRD 时，证书视为未就绪，
+// 但不会重新检查，直到 MicroService 或它拥有的对象发生变化；关闭 CertManager 特性时不检查证书。
+func (r *ReconcileMicroService) syncCertificateStatus(microService *appv1.MicroService) (bool, error) {
+	var certificates []appv1.CertificateStatus
+	waiting := false
+	if lb := microService.Spec.LoadBalance; lb != nil && len(microService.Spec.Versions) > 0 && r.config.Enabled(configv1alpha1.CertManager) {
+		for _, ingressLB := range render.LoadBalanceIngresses(lb) {
+			if ingressLB.TLS == nil {
+				continue
+			}
+			status, installed, err := r.certificateStatus(microService.Namespace, ingressLB)
+			if err != nil {
+				return false, err
+			}
+			waiting = waiting || (installed && !status.Ready)
+			certificates = append(certificates, status)
+		}
+	}
+
+	if reflect.DeepEqual(certificates, microService.Status.Certificates) {
+		return waiting, nil
+	}
+	// 在副本上更新 Status，避免 API Server 的返回覆盖 microService 中尚未写回的 Spec
+	updated := microService.DeepCopy()
+	updated.Status.Certificates = certificates
+	if err := r.Status().Update(context.Background(), updated); err != nil {
+		return false, err
+	}
+	microService.Status.Certificates = certificates
+	microService.ResourceVersion = updated.ResourceVersion
+	return waiting, nil
+}
+
+// certificateStatus 读取 Ingress 对应的 Certificate，并根据它的 Ready Condition 返回证书的状态，
+// 以及集群中是否安装了 cert-manager 的 CRD。
+func (r *ReconcileMicroService) certificateStatus(namespace string, ingressLB *appv1.IngressLoadBalance) (appv1.CertificateStatus, bool, error) {
+	status := appv1.CertificateStatus{Ingress: ingressLB.Name, SecretName: render.IngressTLSSecretName(ingressLB)}
+
+	cert := &unstructured.Unstructured{}
+	cert.SetGroupVersionKind(certificateGVK)
+	err := r.Get(context.TODO(), types.NamespacedName{Name: status.SecretName, Namespace: namespace}, cert)
+	if meta.IsNoMatchError(err) {
+		status.Message = "cert-manager is not installed."
+		return status, false, nil
+	} else if errors.IsNotFound(err) {
+		status.Message = "Certificate has not been created yet."
+		return status, true, nil
+	} else if err != nil {
+		return status, true, err
+	}
+
+	status.Ready, status.Message = certificateReady(cert)
+	return status, true, nil
+}
+
+// certificateReady 返回 Certificate 的 Ready Condition 是否为 True 以及它的 message。
+func certificateReady(cert *unstructured.Unstructured) (bool, string) {
+	conditions, _, _ := unstructured.NestedSlice(cert.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok || cond["type"] != "Ready" {
+			continue
+		}
+		message, _ := cond["message"].(string)
+		return cond["status"] == "True", message
+	}
+	return false, "Certificate has not been issued yet."
+}
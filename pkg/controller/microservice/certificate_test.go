@@ -0,0 +1,96 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package microservice
+
+import (
+	"context"
+	"testing"
+
+	"canary-crd/pkg/apis"
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	"github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// noCertManagerClient behaves like a cluster without the cert-manager CRDs.
+type noCertManagerClient struct {
+	client.Client
+}
+
+func (c noCertManagerClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	if u, ok := obj.(*unstructured.Unstructured); ok && u.GroupVersionKind() == certificateGVK {
+		return &meta.NoKindMatchError{GroupKind: certificateGVK.GroupKind(), SearchedVersions: []string{certificateGVK.Version}}
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func TestSyncCertificateStatus(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(kubescheme.AddToScheme(scheme)).To(gomega.Succeed())
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+	ms := &appv1.MicroService{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: appv1.MicroServiceSpec{
+			LoadBalance: &appv1.LoadBalance{Ingress: &appv1.IngressLoadBalance{Name: "web", TLS: &appv1.IngressTLS{Issuer: "letsencrypt"}}},
+			Versions:    []appv1.DeployVersion{{Name: "v1"}},
+		},
+	}
+	fakeClient := fake.NewFakeClientWithScheme(scheme, ms)
+
+	// without cert-manager the state is recorded once and not checked again
+	r := &ReconcileMicroService{Client: noCertManagerClient{fakeClient}, scheme: scheme, config: configv1alpha1.Default()}
+	waiting, err := r.syncCertificateStatus(ms)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(waiting).To(gomega.BeFalse())
+	g.Expect(ms.Status.Certificates).To(gomega.Equal([]appv1.CertificateStatus{
+		{Ingress: "web", SecretName: "web-tls", Message: "cert-manager is not installed."},
+	}))
+
+	// a Certificate ingress-shim has not created yet is waited for
+	r.Client = fakeClient
+	waiting, err = r.syncCertificateStatus(ms)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(waiting).To(gomega.BeTrue())
+	g.Expect(ms.Status.Certificates[0].Message).To(gomega.Equal("Certificate has not been created yet."))
+}
+
+func TestCertificateReady(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	cert := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	ready, message := certificateReady(cert)
+	g.Expect(ready).To(gomega.BeFalse())
+	g.Expect(message).To(gomega.Equal("Certificate has not been issued yet."))
+
+	g.Expect(unstructured.SetNestedSlice(cert.Object, []interface{}{
+		map[string]interface{}{"type": "Issuing", "status": "False"},
+		map[string]interface{}{"type": "Ready", "status": "True", "message": "Certificate is up to date and has not expired"},
+	}, "status", "conditions")).To(gomega.Succeed())
+	ready, message = certificateReady(cert)
+	g.Expect(ready).To(gomega.BeTrue())
+	g.Expect(message).To(gomega.Equal("Certificate is up to date and has not expired"))
+}
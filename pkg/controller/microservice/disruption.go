@@ -0,0 +1,82 @@
+package microservice
+
+import (
+	appv1 "canary-crd/pkg/apis/app/v1"
+	"canary-crd/pkg/controller/syncer"
+	"canary-crd/pkg/render"
+	"context"
+
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+//disruption.go: 这个文件负责为每个版本的工作负载生成 PodDisruptionBudget，避免节点驱逐时整个灰度版本同时下线。
+//PDB 与工作负载同名，使用版本的 Pod 选择器，minAvailable 或 maxUnavailable 可以在 MicroService 或版本级别配置。
+//版本被删除后，对应的 PDB 会像 cleanUpDeploy 清理 Deployment 一样被清理。
+
+// reconcileDisruptionBudget 为每个版本创建或更新 PDB，并清理不再需要的 PDB。
+func (r *ReconcileMicroService) reconcileDisruptionBudget(microService *appv1.MicroService, objects *render.MicroServiceObjects) error {
+	stayPDBName := make(map[string]bool)
+	for _, version := range objects.Versions {
+		pdb := version.PDB
+		if err := controllerutil.SetControllerReference(microService, pdb, r.scheme); err != nil {
+			return err
+		}
+		if err := r.updateOrCreatePDB(pdb); err != nil {
+			log.Error(err, "Set DeployVersion PDB error", "namespace", microService.Namespace, "microService", microService.Name, "Version", version.Name)
+			return err
+		}
+		stayPDBName[pdb.Name] = true
+	}
+	return r.cleanUpPDB(microService, stayPDBName)
+}
+
+// updateOrCreatePDB 创建或更新 PDB。
+// policy/v1beta1 的 PDB 在 Kubernetes 1.15 之前不允许修改 Spec，因此不使用 syncer 更新，
+// 而是在期望的 PDB 与 last-applied-hash 注解不一致时删除旧的 PDB 并重新创建。
+func (r *ReconcileMicroService) updateOrCreatePDB(pdb *policyv1beta1.PodDisruptionBudget) error {
+	hash, err := syncer.Hash(pdb)
+	if err != nil {
+		return err
+	}
+	pdb.Annotations = map[string]string{syncer.LastAppliedHashAnnotation: hash}
+
+	found := &policyv1beta1.PodDisruptionBudget{}
+	err = r.Get(context.TODO(), types.NamespacedName{Name: pdb.Name, Namespace: pdb.Namespace}, found)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating PDB", "namespace", pdb.Namespace, "name", pdb.Name)
+		return r.Create(context.TODO(), pdb)
+	} else if err != nil {
+		return err
+	} else if found.Annotations[syncer.LastAppliedHashAnnotation] != hash {
+		log.Info("Find PDB as been modified and recreating it", "namespace", pdb.Namespace, "name", pdb.Name)
+		if err := r.Delete(context.TODO(), found); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		return r.Create(context.TODO(), pdb)
+	}
+	return nil
+}
+
+// cleanUpPDB 删除那些不属于任何版本的 PDB。
+func (r *ReconcileMicroService) cleanUpPDB(microService *appv1.MicroService, stayPDBName map[string]bool) error {
+	pdbList := &policyv1beta1.PodDisruptionBudgetList{}
+	labels := map[string]string{r.config.ServiceLabel(): microService.Name}
+	if err := r.List(context.TODO(), client.InNamespace(microService.Namespace).MatchingLabels(labels), pdbList); err != nil {
+		return err
+	}
+	for i := range pdbList.Items {
+		pdb := &pdbList.Items[i]
+		if stayPDBName[pdb.Name] {
+			continue
+		}
+		log.Info("Find orphan PDB", "namespace", microService.Namespace, "MicroService", microService.Name, "PDB", pdb.Name)
+		if err := r.Delete(context.TODO(), pdb); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package microservice
+
+import (
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	"canary-crd/pkg/controller/syncer"
+	"canary-crd/pkg/render"
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+//drift.go: 这个文件负责 MicroService 的漂移检测。
+//所有由 MicroService 拥有的对象都通过 syncObject 和 deleteObject 写入集群：
+//Apply 模式下，syncer 会把被 kubectl edit 等方式修改过的字段改回来，并记录到 canary_crd_drift_reverted_total 指标中；
+//ReportOnly 模式下不做任何修改，只把将要进行的创建、更新和删除记录到 Status.Drift 和 Drifted Condition 中，
+//并通过 canary_crd_microservice_drifted_objects 指标暴露漂移对象的数量，便于先以审计模式接管存量的命名空间。
+//canaryctl diff 通过导出的 Drift 使用同样的 ReportOnly 代码路径，在不修改集群的情况下比较渲染结果与集群中的对象。
+
+var (
+	driftedObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "canary_crd_microservice_drifted_objects",
+		Help: "Number of objects that differ from a MicroService reconciled in ReportOnly mode.",
+	}, []string{"namespace", "microservice"})
+
+	driftReverted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "canary_crd_drift_reverted_total",
+		Help: "Number of times an object edited behind the controller's back was written back.",
+	}, []string{"namespace", "microservice", "kind"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(driftedObjects, driftReverted)
+}
+
+// syncObject 通过 syncer 创建或更新 MicroService 拥有的对象。ReportOnly 模式下只记录对象与期望状态的差异。
+func (r *ReconcileMicroService) syncObject(microService *appv1.MicroService, kind string, desired syncer.Object, mutate syncer.MutateFn) error {
+	if microService.Spec.ReportOnly() {
+		drift, err := syncer.Diff(r, desired, mutate)
+		if err != nil || drift == nil {
+			return err
+		}
+		obj := appv1.DriftedObject{Kind: kind, Name: desired.GetName(), Action: appv1.DriftUpdate, Fields: drift.Fields}
+		if drift.Missing {
+			obj.Action = appv1.DriftCreate
+		}
+		microService.Status.Drift = append(microService.Status.Drift, obj)
+		return nil
+	}
+
+	result, err := syncer.Sync(r, desired, mutate)
+	if err != nil {
+		return err
+	}
+	switch result {
+	case syncer.OperationResultNone:
+	case syncer.OperationResultReverted:
+		log.Info("Find drifted object and reverting it", "namespace", desired.GetNamespace(), "name", desired.GetName(), "kind", kind)
+		driftReverted.WithLabelValues(microService.Namespace, microService.Name, kind).Inc()
+	default:
+		log.Info("Synced object", "namespace", desired.GetNamespace(), "name", desired.GetName(), "kind", kind, "operation", result)
+	}
+	return nil
+}
+
+// deleteObject 删除 MicroService 不再需要的对象。ReportOnly 模式下只记录将要删除的对象。
+func (r *ReconcileMicroService) deleteObject(microService *appv1.MicroService, kind string, obj syncer.Object) error {
+	if microService.Spec.ReportOnly() {
+		microService.Status.Drift = append(microService.Status.Drift, appv1.DriftedObject{Kind: kind, Name: obj.GetName(), Action: appv1.DriftDelete})
+		return nil
+	}
+	return r.Delete(context.TODO(), obj)
+}
+
+// reportDrift 以 ReportOnly 模式处理 MicroService：计算工作负载、Service 和 Ingress 的差异，并写入 Status。
+func (r *ReconcileMicroService) reportDrift(microService *appv1.MicroService, objects *render.MicroServiceObjects) error {
+	previous := microService.Status.Drift
+	microService.Status.Drift = nil
+
+	if err := r.collectDrift(microService, objects); err != nil {
+		return err
+	}
+	return r.syncDriftStatus(microService, previous)
+}
+
+// collectDrift 把工作负载、Service 和 Ingress 的差异追加到 Status.Drift 中，microService 必须处于 ReportOnly 模式。
+func (r *ReconcileMicroService) collectDrift(microService *appv1.MicroService, objects *render.MicroServiceObjects) error {
+	if err := r.reconcileInstance(microService, objects); err != nil {
+		return err
+	}
+	return r.reconcileLoadBalance(microService, objects)
+}
+
+// Drift 以 ReportOnly 模式计算集群中的工作负载、Service 和 Ingress 与 objects 之间的差异，既不修改这些对象，也不更新 microService 的 Status。
+// canaryctl diff 通过它复用控制器的代码路径，mapper 用于判断 API Server 是否提供 networking.k8s.io/v1 Ingress。
+func Drift(c client.Client, mapper meta.RESTMapper, scheme *runtime.Scheme, cfg *configv1alpha1.ManagerConfig, microService *appv1.MicroService, objects *render.MicroServiceObjects) ([]appv1.DriftedObject, error) {
+	r := &ReconcileMicroService{
+		Client:        c,
+		scheme:        scheme,
+		config:        cfg,
+		legacyIngress: !servesNetworkingV1Ingress(mapper),
+	}
+	ms := microService.DeepCopy()
+	ms.Spec.Mode = appv1.ReportOnlyMode
+	ms.Status.Drift = nil
+	if err := r.collectDrift(ms, objects); err != nil {
+		return nil, err
+	}
+	return ms.Status.Drift, nil
+}
+
+// syncDriftStatus 根据本次计算出的 Status.Drift 更新 Drifted Condition 和指标，previous 是上一次记录的差异。
+// Apply 模式下 Status.Drift 为空，Drifted Condition 会被移除。
+func (r *ReconcileMicroService) syncDriftStatus(microService *appv1.MicroService, previous []appv1.DriftedObject) error {
+	drift := microService.Status.Drift
+	conditions, changed := setDriftedCondition(microService.Status.Conditions, drift, microService.Spec.ReportOnly())
+	if microService.Spec.ReportOnly() {
+		driftedObjects.WithLabelValues(microService.Namespace, microService.Name).Set(float64(len(drift)))
+	} else {
+		driftedObjects.DeleteLabelValues(microService.Namespace, microService.Name)
+	}
+
+	if !changed && reflect.DeepEqual(previous, drift) {
+		return nil
+	}
+	if len(drift) != 0 {
+		log.Info("MicroService drifted", "namespace", microService.Namespace, "name", microService.Name, "objects", len(drift))
+	}
+	// 在副本上更新 Status，避免 API Server 的返回覆盖 microService 中尚未写回的 Spec
+	updated := microService.DeepCopy()
+	updated.Status.Conditions = conditions
+	if err := r.Status().Update(context.Background(), updated); err != nil {
+		return err
+	}
+	microService.Status.Conditions = conditions
+	microService.ResourceVersion = updated.ResourceVersion
+	return nil
+}
+
+// setDriftedCondition 返回更新了 Drifted Condition 的 Conditions 以及是否发生了变化。
+// Drifted Condition 只有一个，原地更新；reportOnly 为 false 时移除它。
+func setDriftedCondition(conditions []appv1.MicroServiceCondition, drift []appv1.DriftedObject, reportOnly bool) ([]appv1.MicroServiceCondition, bool) {
+	index := -1
+	for i := range conditions {
+		if conditions[i].Type == appv1.MicroServiceDrifted {
+			index = i
+			break
+		}
+	}
+
+	if !reportOnly {
+		if index == -1 {
+			return conditions, false
+		}
+		result := append([]appv1.MicroServiceCondition{}, conditions[:index]...)
+		return append(result, conditions[index+1:]...), true
+	}
+
+	condition := appv1.MicroServiceCondition{
+		Type:   appv1.MicroServiceDrifted,
+		Status: appv1.ConditionFalse,
+		Reason: "InSync",
+	}
+	if len(drift) != 0 {
+		condition.Status = appv1.ConditionTrue
+		condition.Reason = "ObjectsDrifted"
+		condition.Message = fmt.Sprintf("%d objects differ from the MicroService.", len(drift))
+	}
+
+	now := metav1.Now()
+	result := append([]appv1.MicroServiceCondition{}, conditions...)
+	if index == -1 {
+		condition.LastUpdateTime, condition.LastTransitionTime = now, now
+		return append(result, condition), true
+	}
+	old := result[index]
+	if old.Status == condition.Status && old.Reason == condition.Reason && old.Message == condition.Message {
+		return conditions, false
+	}
+	condition.LastUpdateTime, condition.LastTransitionTime = now, old.LastTransitionTime
+	if old.Status != condition.Status {
+		condition.LastTransitionTime = now
+	}
+	result[index] = condition
+	return result, true
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package microservice
+
+import (
+	"testing"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+
+	"github.com/onsi/gomega"
+)
+
+func TestSetDriftedCondition(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	available := appv1.MicroServiceCondition{Type: appv1.MicroServiceAvailable, Status: appv1.ConditionTrue}
+	drift := []appv1.DriftedObject{{Kind: "Deployment", Name: "voting-v1", Action: appv1.DriftUpdate, Fields: []string{"spec.template.spec.containers[0].image"}}}
+
+	conditions, changed := setDriftedCondition([]appv1.MicroServiceCondition{available}, drift, true)
+	g.Expect(changed).To(gomega.BeTrue())
+	g.Expect(conditions).To(gomega.HaveLen(2))
+	g.Expect(conditions[1].Type).To(gomega.Equal(appv1.MicroServiceDrifted))
+	g.Expect(conditions[1].Status).To(gomega.Equal(appv1.ConditionTrue))
+	g.Expect(conditions[1].Message).To(gomega.Equal("1 objects differ from the MicroService."))
+
+	// the same drift leaves the condition untouched
+	again, changed := setDriftedCondition(conditions, drift, true)
+	g.Expect(changed).To(gomega.BeFalse())
+	g.Expect(again).To(gomega.Equal(conditions))
+
+	// the condition is updated in place once the objects are in sync
+	inSync, changed := setDriftedCondition(append(conditions, available), nil, true)
+	g.Expect(changed).To(gomega.BeTrue())
+	g.Expect(inSync).To(gomega.HaveLen(3))
+	g.Expect(inSync[1].Status).To(gomega.Equal(appv1.ConditionFalse))
+	g.Expect(inSync[1].Reason).To(gomega.Equal("InSync"))
+
+	// switching back to Apply mode removes it
+	applied, changed := setDriftedCondition(inSync, nil, false)
+	g.Expect(changed).To(gomega.BeTrue())
+	g.Expect(applied).To(gomega.Equal([]appv1.MicroServiceCondition{available, available}))
+}
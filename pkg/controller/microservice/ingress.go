@@ -0,0 +1,98 @@
+package microservice
+
+import (
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	"canary-crd/pkg/controller/syncer"
+	networkingv1 "canary-crd/pkg/networking/v1"
+	"canary-crd/pkg/render"
+	"context"
+	"strings"
+
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+//ingress.go: 这个文件负责屏蔽不同 Kubernetes 版本之间 Ingress API 的差异。
+//控制器内部统一使用 networking.k8s.io/v1 的 Ingress，启动时通过 discovery 判断 API Server 是否提供 networking.k8s.io/v1 Ingress，
+//如果不提供，则在读写时转换为 extensions/v1beta1 的 Ingress。
+
+// servesNetworkingV1Ingress 判断 API Server 是否提供 networking.k8s.io/v1 Ingress。
+// Manager 的 RESTMapper 在启动时由 discovery 构建，因此这里不需要额外请求 API Server。
+func servesNetworkingV1Ingress(mapper meta.RESTMapper) bool {
+	gk := schema.GroupKind{Group: networkingv1.SchemeGroupVersion.Group, Kind: "Ingress"}
+	_, err := mapper.RESTMapping(gk, networkingv1.SchemeGroupVersion.Version)
+	return err == nil
+}
+
+// ingressType 返回需要 Watch 的 Ingress 类型
+func ingressType(legacy bool) runtime.Object {
+	if legacy {
+		return &extensionsv1beta1.Ingress{}
+	}
+	return &networkingv1.Ingress{}
+}
+
+// *updateOrCreateIngress(microService *appv1.MicroService, ingress networkingv1.Ingress) error：这个方法负责创建或更新 Ingress 对象。如果 Ingress 对象不存在，
+// 它会创建一个新的 Ingress 对象。如果 Ingress 对象已经存在，只有期望的 Ingress 发生变化时才会更新它的 Spec 和控制器管理的注解。
+func (r *ReconcileMicroService) updateOrCreateIngress(microService *appv1.MicroService, ingress *networkingv1.Ingress) error {
+	var desired syncer.Object = ingress
+	if r.legacyIngress {
+		desired = networkingv1.ConvertToV1beta1Ingress(ingress)
+	}
+
+	return r.syncObject(microService, "Ingress", desired, func(found syncer.Object) {
+		switch current := found.(type) {
+		case *networkingv1.Ingress:
+			current.Spec = ingress.Spec
+		case *extensionsv1beta1.Ingress:
+			current.Spec = desired.(*extensionsv1beta1.Ingress).Spec
+		}
+		found.SetAnnotations(pruneIngressAnnotations(r.config, found.GetAnnotations(), desired.GetAnnotations()))
+	})
+}
+
+// pruneIngressAnnotations 删除 current 中由控制器管理但是不在 desired 中的注解，例如关闭 header 灰度之后的 canary-by-header。
+func pruneIngressAnnotations(cfg *configv1alpha1.ManagerConfig, current, desired map[string]string) map[string]string {
+	for k := range current {
+		if _, ok := desired[k]; ok {
+			continue
+		}
+		if strings.HasPrefix(k, cfg.NginxAnnotation("canary")) || k == render.CertManagerIssuerAnnotation || k == render.CertManagerClusterIssuerAnnotation {
+			delete(current, k)
+		}
+	}
+	return current
+}
+
+// listIngresses 列出符合条件的 Ingress，extensions/v1beta1 的 Ingress 会被转换为 networking.k8s.io/v1 的 Ingress。
+func (r *ReconcileMicroService) listIngresses(opts *client.ListOptions) ([]networkingv1.Ingress, error) {
+	if !r.legacyIngress {
+		list := &networkingv1.IngressList{}
+		if err := r.List(context.TODO(), opts, list); err != nil {
+			return nil, err
+		}
+		return list.Items, nil
+	}
+
+	list := &extensionsv1beta1.IngressList{}
+	if err := r.List(context.TODO(), opts, list); err != nil {
+		return nil, err
+	}
+	ingresses := make([]networkingv1.Ingress, 0, len(list.Items))
+	for i := range list.Items {
+		ingresses = append(ingresses, *networkingv1.ConvertFromV1beta1Ingress(&list.Items[i]))
+	}
+	return ingresses, nil
+}
+
+// deleteIngress 删除 Ingress，使用 Manager 启动时选择的 API 版本。
+func (r *ReconcileMicroService) deleteIngress(microService *appv1.MicroService, ingress *networkingv1.Ingress) error {
+	if r.legacyIngress {
+		return r.deleteObject(microService, "Ingress", networkingv1.ConvertToV1beta1Ingress(ingress))
+	}
+	return r.deleteObject(microService, "Ingress", ingress.DeepCopy())
+}
@@ -2,11 +2,11 @@ package microservice
 
 import (
 	appv1 "canary-crd/pkg/apis/app/v1"
+	"canary-crd/pkg/controller/syncer"
+	"canary-crd/pkg/render"
 	"context"
 	appsv1 "k8s.io/api/apps/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
 	"reflect"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -15,108 +15,135 @@ import (
 //instance.go: 这个文件主要负责处理 MicroService 对象的实例。它包含了一些关键的方法，如 reconcileInstance 和 syncMicroServiceStatus。
 //reconcileInstance 方法负责处理 MicroService 对象的实例，包括创建、更新和删除。syncMicroServiceStatus 方法则负责同步 MicroService 对象的状态。
 
-// *reconcileInstance(microService appv1.MicroService) error：这个方法负责处理 MicroService 对象的实例。
-// 它首先创建一个新的 Deployment 映射，然后遍历 MicroService 对象的 Versions 字段，为每个版本创建一个 Deployment，
-// 并将其添加到映射中。然后，它会检查每个新的 Deployment 是否已经存在，如果不存在，它会创建一个新的 Deployment，如果已经存在，
-// 它会检查 Deployment 的 Spec 字段是否发生了变化，如果发生了变化，它会更新 Deployment。
-// 最后，它会清理那些在新的 Deployment 映射中不存在，但在 Kubernetes 集群中存在的 Deployment。
-func (r *ReconcileMicroService) reconcileInstance(microService *appv1.MicroService) error {
-
-	newDeploys := make(map[string]*appsv1.Deployment)
-	for i := range microService.Spec.Versions {
-		version := &microService.Spec.Versions[i]
-
-		deploy, err := makeVersionDeployment(version, microService)
-		if err != nil {
-			log.Error(err, "Make Deployment for version error", "versionName", version.Name)
-			return err
-		}
-		if err := controllerutil.SetControllerReference(microService, deploy, r.scheme); err != nil {
+// *reconcileInstance(microService *appv1.MicroService, objects *render.MicroServiceObjects) error：这个方法负责处理 MicroService 对象的实例。
+// 它遍历渲染出的每个版本，为版本的 Deployment 或 StatefulSet 以及配置快照设置 OwnerReference，然后创建快照，
+// 并检查每个工作负载是否已经存在，如果不存在，它会创建一个新的工作负载，如果已经存在，
+// 它会检查工作负载的 Spec 字段是否发生了变化，如果发生了变化，它会更新工作负载。
+// 最后，它会清理那些不属于任何版本，但在 Kubernetes 集群中存在的工作负载和快照。
+func (r *ReconcileMicroService) reconcileInstance(microService *appv1.MicroService, objects *render.MicroServiceObjects) error {
+
+	newWorkloads := make(map[string]appv1.WorkloadKind)
+	var versionStatuses []appv1.VersionStatus
+	staySnapshotName := make(map[string]bool)
+	for _, version := range objects.Versions {
+		obj := version.Workload
+		if err := controllerutil.SetControllerReference(microService, obj, r.scheme); err != nil {
 			log.Error(err, "Set DeployVersion CtlRef Error", "versionName", version.Name)
 			return err
 		}
 
-		newDeploys[deploy.Name] = deploy
-		found := &appsv1.Deployment{}
-		err = r.Get(context.TODO(), types.NamespacedName{Name: deploy.Name, Namespace: deploy.Namespace}, found)
-
-		if err != nil && errors.IsNotFound(err) {
-
-			log.Info("Old Deployment NotFound and Creating new one", "namespace", deploy.Namespace, "name", deploy.Name)
-			if err = r.Create(context.TODO(), deploy); err != nil {
-				return err
-			}
-
-		} else if err != nil {
-
-			log.Error(err, "Get Deployment info Error", "namespace", deploy.Namespace, "name", deploy.Name)
+		if err := r.syncConfigSnapshots(microService, version.Snapshots); err != nil {
+			log.Error(err, "Sync config snapshots error", "versionName", version.Name)
 			return err
+		}
+		for _, snapshot := range version.Snapshots {
+			staySnapshotName[snapshot.GetName()] = true
+		}
 
-		} else if !reflect.DeepEqual(deploy.Spec, found.Spec) {
-
-			// Update the found object and write the result back if there are any changes
-			found.Spec = deploy.Spec
-			log.Info("Old deployment changed and Updating Deployment to reconcile", "namespace", deploy.Namespace, "name", deploy.Name)
-			err = r.Update(context.TODO(), found)
-			if err != nil {
-				return err
-			}
-
+		newWorkloads[obj.GetName()] = version.Kind
+		if err := r.updateOrCreateWorkload(microService, obj, version.Kind, version.HPA != nil); err != nil {
+			return err
 		}
+		versionStatuses = append(versionStatuses, appv1.VersionStatus{
+			Name:         version.Name,
+			TemplateHash: version.TemplateHash,
+		})
 	}
-	return r.cleanUpDeploy(microService, newDeploys)
+	if err := r.cleanUpDeploy(microService, newWorkloads); err != nil {
+		return err
+	}
+	// ReportOnly 模式下只记录工作负载的差异，不清理快照也不更新状态
+	if microService.Spec.ReportOnly() {
+		return nil
+	}
+	if err := r.cleanUpConfigSnapshots(microService, staySnapshotName); err != nil {
+		return err
+	}
+	return r.syncVersionStatus(microService, versionStatuses)
 }
 
-// makeVersionDeployment(version *appv1.DeployVersion, microService *appv1.MicroService) (*appsv1.Deployment, error)：
-// 这个方法创建一个新的 Deployment 对象。它接收一个 DeployVersion 对象和一个 MicroService 对象，然后返回一个新的 Deployment 对象。
-func makeVersionDeployment(version *appv1.DeployVersion, microService *appv1.MicroService) (*appsv1.Deployment, error) {
-
-	labels := microService.Labels
-	if labels == nil {
-		labels = make(map[string]string)
+// syncVersionStatus 将每个版本渲染后的 Pod 模板哈希写入 MicroService 的状态。
+func (r *ReconcileMicroService) syncVersionStatus(microService *appv1.MicroService, versionStatuses []appv1.VersionStatus) error {
+	if reflect.DeepEqual(microService.Status.Versions, versionStatuses) {
+		return nil
 	}
-	labels["app.o0w0o.cn/service"] = microService.Name
-	labels["app.o0w0o.cn/version"] = version.Name
-
-	deploySpec := version.Template
+	microService.Status.Versions = versionStatuses
+	return r.Status().Update(context.Background(), microService)
+}
 
-	deploy := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      microService.Name + "-" + version.Name,
-			Namespace: microService.Namespace,
-			Labels:    labels,
-		},
-		Spec: deploySpec,
+// updateOrCreateWorkload 通过 syncer 创建或更新一个工作负载，只有期望的工作负载发生变化时才会更新它。
+// 工作负载由 HPA 管理副本数时（autoscaled），保留集群中当前的副本数，避免与 HPA 互相覆盖。
+func (r *ReconcileMicroService) updateOrCreateWorkload(microService *appv1.MicroService, obj render.Object, kind appv1.WorkloadKind, autoscaled bool) error {
+	err := r.syncObject(microService, string(kind), obj, func(found syncer.Object) {
+		switch current := found.(type) {
+		case *appsv1.Deployment:
+			desired := obj.(*appsv1.Deployment)
+			replicas, selector := current.Spec.Replicas, current.Spec.Selector
+			current.Spec = desired.Spec
+			// selector 创建后不可修改
+			current.Spec.Selector = selector
+			if autoscaled {
+				current.Spec.Replicas = replicas
+			}
+		case *appsv1.StatefulSet:
+			desired := obj.(*appsv1.StatefulSet)
+			// StatefulSet 创建后只允许修改以下字段
+			current.Spec.Template = desired.Spec.Template
+			current.Spec.UpdateStrategy = desired.Spec.UpdateStrategy
+			current.Spec.RevisionHistoryLimit = desired.Spec.RevisionHistoryLimit
+			if !autoscaled {
+				current.Spec.Replicas = desired.Spec.Replicas
+			}
+		}
+	})
+	if err != nil {
+		log.Error(err, "Sync workload Error", "namespace", obj.GetNamespace(), "name", obj.GetName(), "kind", kind)
 	}
-
-	return deploy, nil
+	return err
 }
 
-// **cleanUpDeploy(microService appv1.MicroService, newDeployList map[string]appsv1.Deployment) error：
-// 这个方法负责清理那些在新的 Deployment 映射中不存在，但在 Kubernetes 集群中存在的 Deployment。
-// 它会列出所有的 Deployment，然后删除那些不在 newDeployList 映射中的 Deployment。
-func (r *ReconcileMicroService) cleanUpDeploy(microService *appv1.MicroService, newDeployList map[string]*appsv1.Deployment) error {
-	// Check if the MicroService not exists
+// **cleanUpDeploy(microService appv1.MicroService, newWorkloads map[string]appv1.WorkloadKind) error：
+// 这个方法负责清理那些在新的工作负载映射中不存在，但在 Kubernetes 集群中存在的 Deployment 和 StatefulSet。
+// 它会列出所有的 Deployment 和 StatefulSet，然后删除那些不在 newWorkloads 映射中，或者版本已经切换为其他 Kind 的工作负载。
+func (r *ReconcileMicroService) cleanUpDeploy(microService *appv1.MicroService, newWorkloads map[string]appv1.WorkloadKind) error {
 	ctx := context.Background()
 
-	deployList := appsv1.DeploymentList{}
 	labels := make(map[string]string)
-	labels["app.o0w0o.cn/service"] = microService.Name
+	labels[r.config.ServiceLabel()] = microService.Name
+	opts := client.InNamespace(microService.Namespace).MatchingLabels(labels)
 
-	if err := r.List(ctx, client.InNamespace(microService.Namespace).
-		MatchingLabels(labels), &deployList); err != nil {
-		log.Error(err, "unable to list old MicroServices")
+	deployList := appsv1.DeploymentList{}
+	if err := r.List(ctx, opts, &deployList); err != nil {
+		log.Error(err, "unable to list old Deployments")
+		return err
+	}
+	stsList := appsv1.StatefulSetList{}
+	if err := r.List(ctx, opts, &stsList); err != nil {
+		log.Error(err, "unable to list old StatefulSets")
 		return err
 	}
 
-	for _, oldDeploy := range deployList.Items {
-		if _, exist := newDeployList[oldDeploy.Name]; exist == false {
-			log.Info("Find orphan Deployment", "namespace", microService.Namespace, "MicroService", microService.Name, "Deployment", oldDeploy.Name)
-			err := r.Delete(context.TODO(), &oldDeploy)
-			if err != nil {
-				log.Error(err, "Delete orphan Deployment error", "namespace", oldDeploy.Namespace, "name", oldDeploy.Name)
-				return err
-			}
+	var orphans []render.Object
+	for i := range deployList.Items {
+		if newWorkloads[deployList.Items[i].Name] != appv1.DeploymentKind {
+			orphans = append(orphans, &deployList.Items[i])
+		}
+	}
+	for i := range stsList.Items {
+		if newWorkloads[stsList.Items[i].Name] != appv1.StatefulSetKind {
+			orphans = append(orphans, &stsList.Items[i])
+		}
+	}
+
+	for _, orphan := range orphans {
+		log.Info("Find orphan workload", "namespace", microService.Namespace, "MicroService", microService.Name, "workload", orphan.GetName())
+		kind := appv1.DeploymentKind
+		if _, ok := orphan.(*appsv1.StatefulSet); ok {
+			kind = appv1.StatefulSetKind
+		}
+		if err := r.deleteObject(microService, string(kind), orphan); err != nil {
+			log.Error(err, "Delete orphan workload error", "namespace", orphan.GetNamespace(), "name", orphan.GetName())
+			return err
 		}
 	}
 	return nil
@@ -167,27 +194,32 @@ func (r *ReconcileMicroService) syncMicroServiceStatus(microService *appv1.Micro
 }
 
 // calculateStatus(microService *appv1.MicroService) (appv1.MicroServiceStatus, error)：
-// 这个方法计算 MicroService 对象的新状态。它会列出所有的 Deployment，然后计算 AvailableVersions 和 TotalVersions，然后返回一个新的 MicroServiceStatus 对象。
+// 这个方法计算 MicroService 对象的新状态。它会列出所有的 Deployment 和 StatefulSet，然后计算 AvailableVersions 和 TotalVersions，然后返回一个新的 MicroServiceStatus 对象。
 func (r *ReconcileMicroService) calculateStatus(microService *appv1.MicroService) (appv1.MicroServiceStatus, error) {
 	// Check if the MicroService not exists
 	ctx := context.Background()
 
 	deployList := appsv1.DeploymentList{}
+	stsList := appsv1.StatefulSetList{}
 	labels := make(map[string]string)
-	labels["app.o0w0o.cn/service"] = microService.Name
+	labels[r.config.ServiceLabel()] = microService.Name
 
-	al := int32(len(deployList.Items))
 	tl := int32(len(microService.Spec.Versions))
 	newStatus := appv1.MicroServiceStatus{
-		AvailableVersions: al,
-		TotalVersions:     tl,
+		TotalVersions: tl,
+		Versions:      microService.Status.Versions,
+		Certificates:  microService.Status.Certificates,
+	}
+	opts := client.InNamespace(microService.Namespace).MatchingLabels(labels)
+	if err := r.List(ctx, opts, &deployList); err != nil {
+		log.Error(err, "unable to list old Deployments")
+		return newStatus, err
 	}
-	if err := r.List(ctx, client.InNamespace(microService.Namespace).
-		MatchingLabels(labels), &deployList); err != nil {
-		log.Error(err, "unable to list old MicroServices")
+	if err := r.List(ctx, opts, &stsList); err != nil {
+		log.Error(err, "unable to list old StatefulSets")
 		return newStatus, err
 	}
-	newStatus.AvailableVersions = int32(len(deployList.Items))
+	newStatus.AvailableVersions = int32(len(deployList.Items) + len(stsList.Items))
 
 	return newStatus, nil
 }
@@ -0,0 +1,50 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package microservice
+
+import (
+	"testing"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestMergeServiceSpec(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	current := &corev1.ServiceSpec{
+		Type:      corev1.ServiceTypeNodePort,
+		ClusterIP: "10.0.0.10",
+		Ports: []corev1.ServicePort{
+			{Name: "http", Port: 80, Protocol: corev1.ProtocolTCP, NodePort: 30080},
+			{Name: "grpc", Port: 9090, Protocol: corev1.ProtocolTCP, NodePort: 30090},
+		},
+	}
+	desired := &corev1.ServiceSpec{
+		Type: corev1.ServiceTypeNodePort,
+		Ports: []corev1.ServicePort{
+			{Name: "http", Port: 80},
+			{Name: "metrics", Port: 9100},
+		},
+	}
+
+	spec := mergeServiceSpec(desired, current)
+	g.Expect(spec.ClusterIP).To(gomega.Equal("10.0.0.10"))
+	g.Expect(spec.Ports[0].NodePort).To(gomega.Equal(int32(30080)))
+	g.Expect(spec.Ports[1].NodePort).To(gomega.BeZero())
+	g.Expect(desired.ClusterIP).To(gomega.BeEmpty())
+}
@@ -18,13 +18,17 @@ package microservice
 
 import (
 	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	"canary-crd/pkg/render"
 	"context"
 	"k8s.io/apimachinery/pkg/types"
 	"reflect"
 
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
 	corev1 "k8s.io/api/core/v1"
-	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	k8snetworkingv1 "k8s.io/api/networking/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -62,24 +66,30 @@ var log = logf.Log.WithName("controller")
 
 // Add creates a new MicroService Controller and adds it to the Manager with default RBAC. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
-// Add(mgr manager.Manager) error：这个方法创建一个新的 MicroService 控制器并将其添加到 Manager。当 Manager 启动时，它会设置控制器的字段并启动控制器。
-func Add(mgr manager.Manager) error {
-	return add(mgr, newReconciler(mgr))
+// Add(mgr manager.Manager, cfg *configv1alpha1.ManagerConfig) error：这个方法创建一个新的 MicroService 控制器并将其添加到 Manager。当 Manager 启动时，它会设置控制器的字段并启动控制器。
+// cfg 是 Manager 的配置文件，决定了创建的对象的 Label、名字以及控制器的并发数。
+func Add(mgr manager.Manager, cfg *configv1alpha1.ManagerConfig) error {
+	return add(mgr, newReconciler(mgr, cfg), cfg)
 }
 
 // newReconciler returns a new reconcile.Reconciler
-// newReconciler(mgr manager.Manager) reconcile.Reconciler：这个方法返回一个新的 reconcile.Reconciler，
+// newReconciler(mgr manager.Manager, cfg *configv1alpha1.ManagerConfig) reconcile.Reconciler：这个方法返回一个新的 reconcile.Reconciler，
 // 它是一个 ReconcileMicroService 结构体的实例，该结构体实现了 reconcile.Reconciler 接口。
-func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileMicroService{Client: mgr.GetClient(), scheme: mgr.GetScheme()}
+func newReconciler(mgr manager.Manager, cfg *configv1alpha1.ManagerConfig) reconcile.Reconciler {
+	return &ReconcileMicroService{
+		Client:        mgr.GetClient(),
+		scheme:        mgr.GetScheme(),
+		config:        cfg,
+		legacyIngress: !servesNetworkingV1Ingress(mgr.GetRESTMapper()),
+	}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
-// add(mgr manager.Manager, r reconcile.Reconciler) error：这个方法将一个新的控制器添加到 mgr，r 是 reconcile.Reconciler。
-// 它创建一个新的控制器，并设置其 Reconciler 为 r。然后，它为 MicroService 对象和由 MicroService 对象创建的 Deployment、Service 和 Ingress 资源设置了 Watch。
-func add(mgr manager.Manager, r reconcile.Reconciler) error {
+// add(mgr manager.Manager, r reconcile.Reconciler, cfg *configv1alpha1.ManagerConfig) error：这个方法将一个新的控制器添加到 mgr，r 是 reconcile.Reconciler。
+// 它创建一个新的控制器，并设置其 Reconciler 为 r。然后，它为 MicroService 对象和由 MicroService 对象创建的 Deployment、StatefulSet、HPA、PDB、配置快照、NetworkPolicy、Service 和 Ingress 资源设置了 Watch。
+func add(mgr manager.Manager, r reconcile.Reconciler, cfg *configv1alpha1.ManagerConfig) error {
 	// Create a new controller
-	c, err := controller.New("microservice-controller", mgr, controller.Options{Reconciler: r})
+	c, err := controller.New("microservice-controller", mgr, controller.Options{Reconciler: r, MaxConcurrentReconciles: cfg.Concurrency.MicroService})
 	if err != nil {
 		return err
 	}
@@ -128,6 +138,80 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	err = c.Watch(&source.Kind{Type: &appsv1.StatefulSet{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &appv1.MicroService{},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(&source.Kind{Type: &autoscalingv2beta2.HorizontalPodAutoscaler{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &appv1.MicroService{},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(&source.Kind{Type: &policyv1beta1.PodDisruptionBudget{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &appv1.MicroService{},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &appv1.MicroService{},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &appv1.MicroService{},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch ConfigMaps and Secrets referenced by versions which restart on config change
+	if err := mgr.GetFieldIndexer().IndexField(&appv1.MicroService{}, configMapRefIndex, indexConfigMapRefs); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(&appv1.MicroService{}, secretRefIndex, indexSecretRefs); err != nil {
+		return err
+	}
+	err = c.Watch(&source.Kind{Type: &corev1.ConfigMap{}}, enqueueReferencingMicroServices(mgr.GetClient(), configMapRefIndex))
+	if err != nil {
+		return err
+	}
+	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, enqueueReferencingMicroServices(mgr.GetClient(), secretRefIndex))
+	if err != nil {
+		return err
+	}
+
+	// Watch MicroServices and Apps deciding whether NetworkPolicies select the pods of a MicroService
+	err = c.Watch(&source.Kind{Type: &appv1.MicroService{}}, enqueueNetworkPolicyPeers(cfg))
+	if err != nil {
+		return err
+	}
+	err = c.Watch(&source.Kind{Type: &appv1.App{}}, enqueueAppMicroServices(cfg))
+	if err != nil {
+		return err
+	}
+
+	err = c.Watch(&source.Kind{Type: &k8snetworkingv1.NetworkPolicy{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &appv1.MicroService{},
+	})
+	if err != nil {
+		return err
+	}
+
 	err = c.Watch(&source.Kind{Type: &corev1.Service{}}, &handler.EnqueueRequestForOwner{
 		IsController: true,
 		OwnerType:    &appv1.MicroService{},
@@ -136,7 +220,11 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
-	err = c.Watch(&source.Kind{Type: &extensionsv1beta1.Ingress{}}, &handler.EnqueueRequestForOwner{
+	legacyIngress := !servesNetworkingV1Ingress(mgr.GetRESTMapper())
+	if legacyIngress {
+		log.Info("networking.k8s.io/v1 Ingress is not served, falling back to extensions/v1beta1")
+	}
+	err = c.Watch(&source.Kind{Type: ingressType(legacyIngress)}, &handler.EnqueueRequestForOwner{
 		IsController: true,
 		OwnerType:    &appv1.MicroService{},
 	})
@@ -153,6 +241,11 @@ var _ reconcile.Reconciler = &ReconcileMicroService{}
 type ReconcileMicroService struct {
 	client.Client
 	scheme *runtime.Scheme
+	config *configv1alpha1.ManagerConfig
+
+	// legacyIngress is set when the API server does not serve networking.k8s.io/v1
+	// Ingress and extensions/v1beta1 Ingress is written instead.
+	legacyIngress bool
 }
 
 //Reconcile(request reconcile.Request) (reconcile.Result, error)：这个方法读取集群中的 MicroService 对象的状态，
@@ -164,14 +257,26 @@ type ReconcileMicroService struct {
 // Automatically generate RBAC rules to allow the Controller to read and write Deployments
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps,resources=deployments/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=replicasets;controllerrevisions,verbs=get;list;watch
+// +kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=monitoring.coreos.com,resources=servicemonitors;prometheusrules,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=app.o0w0o.cn,resources=microservices,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=app.o0w0o.cn,resources=microservices/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=app.o0w0o.cn,resources=apps,verbs=get;list;watch
+// +kubebuilder:rbac:groups=extensions,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
 func (r *ReconcileMicroService) Reconcile(request reconcile.Request) (reconcile.Result, error) {
 	// Fetch the MicroService instance
 	instance := &appv1.MicroService{}
 	err := r.Get(context.TODO(), request.NamespacedName, instance)
 	if err != nil {
 		if errors.IsNotFound(err) {
+			driftedObjects.DeleteLabelValues(request.Namespace, request.Name)
 			return reconcile.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
@@ -187,26 +292,121 @@ func (r *ReconcileMicroService) Reconcile(request reconcile.Request) (reconcile.
 		return reconcile.Result{}, err
 	}
 
-	if err := r.reconcileInstance(instance); err != nil {
+	objects, err := r.renderMicroService(instance)
+	if err != nil {
+		log.Info("Render MicroService error", err)
+		return reconcile.Result{}, err
+	}
+
+	if instance.Spec.ReportOnly() {
+		if err := r.reportDrift(instance, objects); err != nil {
+			log.Info("Report Drift error", err)
+			return reconcile.Result{}, err
+		}
+		return reconcile.Result{}, nil
+	}
+	previousDrift := instance.Status.Drift
+	instance.Status.Drift = nil
+	if err := r.syncDriftStatus(instance, previousDrift); err != nil {
+		log.Info("Sync Drift status error", err)
+		return reconcile.Result{}, err
+	}
+
+	if err := r.reconcileInstance(instance, objects); err != nil {
 		log.Info("Reconcile Instance Versions error", err)
 		return reconcile.Result{}, err
 	}
 
-	if err := r.reconcileLoadBalance(instance); err != nil {
+	if err := r.reconcileAutoscaling(instance, objects); err != nil {
+		log.Info("Reconcile Autoscaling error", err)
+		return reconcile.Result{}, err
+	}
+
+	if err := r.reconcileDisruptionBudget(instance, objects); err != nil {
+		log.Info("Reconcile DisruptionBudget error", err)
+		return reconcile.Result{}, err
+	}
+
+	if err := r.reconcileNetworkPolicy(instance, objects); err != nil {
+		log.Info("Reconcile NetworkPolicy error", err)
+		return reconcile.Result{}, err
+	}
+
+	if err := r.reconcileLoadBalance(instance, objects); err != nil {
 		log.Info("Reconcile LoadBalance error", err)
 		return reconcile.Result{}, err
 	}
 
+	if err := r.reconcileMonitoring(instance, objects); err != nil {
+		log.Info("Reconcile Monitoring error", err)
+		return reconcile.Result{}, err
+	}
+
+	certificatesWaiting, err := r.syncCertificateStatus(instance)
+	if err != nil {
+		log.Info("Sync Certificate status error", err)
+		return reconcile.Result{}, err
+	}
+
 	oldMS := &appv1.MicroService{}
 	if err := r.Get(context.TODO(), types.NamespacedName{Name: instance.Name, Namespace: instance.Namespace}, oldMS); err != nil {
 		return reconcile.Result{}, err
 	}
-	if !reflect.DeepEqual(oldMS.Spec, instance.Spec) {
-		oldMS.Spec = instance.Spec
+	// render 包会把版本的 ServiceName 和灰度 Ingress 的名字等默认值写入渲染出的 Spec
+	if !reflect.DeepEqual(oldMS.Spec, objects.Spec) {
+		oldMS.Spec = objects.Spec
 		if err := r.Update(context.TODO(), oldMS); err != nil {
 			return reconcile.Result{}, err
 		}
 	}
 
+	if certificatesWaiting {
+		return reconcile.Result{RequeueAfter: certificateWaitInterval}, nil
+	}
 	return reconcile.Result{}, nil
 }
+
+// renderMicroService 读取 MicroService 引用的 ConfigMap 和 Secret，然后通过 render 包计算 MicroService 的期望状态。
+func (r *ReconcileMicroService) renderMicroService(microService *appv1.MicroService) (*render.MicroServiceObjects, error) {
+	inputs, err := ReadInputs(r, r.config, microService)
+	if err != nil {
+		return nil, err
+	}
+	return render.MicroService(r.config, microService, inputs)
+}
+
+// ReadInputs 读取 MicroService 引用的 ConfigMap 和 Secret，并判断是否有 NetworkPolicy 选中它的 Pod。
+// 不存在的对象不会放入 render.Inputs：版本的配置哈希把它们视为已删除，引用它们的配置快照则无法渲染。
+func ReadInputs(c client.Reader, cfg *configv1alpha1.ManagerConfig, microService *appv1.MicroService) (*render.Inputs, error) {
+	selected, err := selectedByNetworkPolicy(c, cfg, microService)
+	if err != nil {
+		return nil, err
+	}
+	inputs := &render.Inputs{
+		ConfigMaps:              make(map[string]*corev1.ConfigMap),
+		Secrets:                 make(map[string]*corev1.Secret),
+		SelectedByNetworkPolicy: selected,
+	}
+	configMaps, secrets := render.References(microService)
+	for _, name := range configMaps {
+		cm := &corev1.ConfigMap{}
+		if err := c.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: microService.Namespace}, cm); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		inputs.ConfigMaps[name] = cm
+	}
+	for _, name := range secrets {
+		secret := &corev1.Secret{}
+		if err := c.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: microService.Namespace}, secret); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		inputs.Secrets[name] = secret
+	}
+	return inputs, nil
+}
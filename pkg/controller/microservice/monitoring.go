@@ -0,0 +1,73 @@
+package microservice
+
+import (
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	"canary-crd/pkg/render"
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+//monitoring.go: 这个文件负责同步为声明了 Metrics 的 MicroService 渲染出的 prometheus-operator 的 ServiceMonitor 和 PrometheusRule。
+//为了不依赖 prometheus-operator 的类型，这两种对象都以 unstructured 的方式读写；集群中没有安装对应的 CRD 时跳过。
+//ServiceMonitor 选中 reconcileLoadBalance 为每个版本创建的 Service，并把 Service 的 <LabelDomain>/version 标签重写为 version 标签，
+//因此每条时间序列都带有版本信息。PrometheusRule 按照版本记录请求速率和错误率，便于对比灰度版本与稳定版本。
+
+// reconcileMonitoring 创建、更新或者删除 MicroService 的 ServiceMonitor 和 PrometheusRule。关闭 Monitoring 特性时不做任何处理。
+func (r *ReconcileMicroService) reconcileMonitoring(microService *appv1.MicroService, objects *render.MicroServiceObjects) error {
+	if !r.config.Enabled(configv1alpha1.Monitoring) {
+		return nil
+	}
+	if err := r.syncMonitoringObject(microService, render.ServiceMonitorGVK, objects.ServiceMonitor); err != nil {
+		return err
+	}
+	return r.syncMonitoringObject(microService, render.PrometheusRuleGVK, objects.PrometheusRule)
+}
+
+// syncMonitoringObject 创建或更新 desired，desired 为空时删除 MicroService 拥有的同名对象。
+// 集群中没有安装 prometheus-operator 的 CRD 时什么也不做。
+func (r *ReconcileMicroService) syncMonitoringObject(microService *appv1.MicroService, gvk schema.GroupVersionKind, desired *unstructured.Unstructured) error {
+	found := &unstructured.Unstructured{}
+	found.SetGroupVersionKind(gvk)
+	err := r.Get(context.TODO(), types.NamespacedName{Name: microService.Name, Namespace: microService.Namespace}, found)
+	if meta.IsNoMatchError(err) {
+		if desired != nil {
+			log.Info("prometheus-operator is not installed, skip", "namespace", microService.Namespace, "microService", microService.Name, "kind", gvk.Kind)
+		}
+		return nil
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	exist := err == nil
+
+	if desired == nil {
+		if !exist || !metav1.IsControlledBy(found, microService) {
+			return nil
+		}
+		log.Info("Deleting monitoring object", "namespace", found.GetNamespace(), "name", found.GetName(), "kind", gvk.Kind)
+		return r.Delete(context.TODO(), found)
+	}
+
+	if err := controllerutil.SetControllerReference(microService, desired, r.scheme); err != nil {
+		return err
+	}
+	if !exist {
+		log.Info("Creating monitoring object", "namespace", desired.GetNamespace(), "name", desired.GetName(), "kind", gvk.Kind)
+		return r.Create(context.TODO(), desired)
+	}
+	if !equality.Semantic.DeepEqual(desired.Object["spec"], found.Object["spec"]) {
+		found.Object["spec"] = desired.Object["spec"]
+		log.Info("Find monitoring object as been modified", "namespace", found.GetNamespace(), "name", found.GetName(), "kind", gvk.Kind)
+		return r.Update(context.TODO(), found)
+	}
+	return nil
+}
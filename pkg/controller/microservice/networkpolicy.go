@@ -0,0 +1,118 @@
+package microservice
+
+import (
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	"canary-crd/pkg/controller/syncer"
+	"canary-crd/pkg/render"
+	"context"
+
+	k8snetworkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+//networkpolicy.go: 这个文件负责同步根据 MicroServiceSpec.NetworkPolicy 渲染出的 NetworkPolicy。
+//NetworkPolicy 与 MicroService 同名，通过 <LabelDomain>/service 标签选中 MicroService 所有版本的 Pod，
+//允许来自同一个 App 中的其他 MicroService、指定的 Namespace 以及 Ingress Controller 的流量。
+//配合 App 的 DefaultDeny，整个 App 默认拒绝所有流量，每个 MicroService 只需要声明允许的调用方。
+
+// reconcileNetworkPolicy 创建、更新或者删除 MicroService 的 NetworkPolicy。关闭 NetworkPolicy 特性时不做任何处理。
+func (r *ReconcileMicroService) reconcileNetworkPolicy(microService *appv1.MicroService, objects *render.MicroServiceObjects) error {
+	if !r.config.Enabled(configv1alpha1.NetworkPolicy) {
+		return nil
+	}
+	policy := objects.NetworkPolicy
+	if policy == nil {
+		found := &k8snetworkingv1.NetworkPolicy{}
+		err := r.Get(context.TODO(), types.NamespacedName{Name: microService.Name, Namespace: microService.Namespace}, found)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		if !metav1.IsControlledBy(found, microService) {
+			return nil
+		}
+		log.Info("Deleting NetworkPolicy", "namespace", found.Namespace, "name", found.Name)
+		return r.Delete(context.TODO(), found)
+	}
+
+	if err := controllerutil.SetControllerReference(microService, policy, r.scheme); err != nil {
+		return err
+	}
+
+	return r.syncObject(microService, "NetworkPolicy", policy, func(found syncer.Object) {
+		found.(*k8snetworkingv1.NetworkPolicy).Spec = policy.Spec
+	})
+}
+
+// selectedByNetworkPolicy 判断是否有 NetworkPolicy 通过标签选中 MicroService 的 Pod：它自己的 NetworkPolicy、所属 App 的 DefaultDeny，
+// 或者同一个 Namespace 中允许它访问的其他 MicroService 的 NetworkPolicy。只有这时 Pod 模板才需要 service 和 app 标签，
+// 其他 Pod 模板保持不变，升级控制器不会滚动重启它们。
+func selectedByNetworkPolicy(c client.Reader, cfg *configv1alpha1.ManagerConfig, microService *appv1.MicroService) (bool, error) {
+	if !cfg.Enabled(configv1alpha1.NetworkPolicy) {
+		return false, nil
+	}
+	var app *appv1.App
+	if name, ok := microService.Labels[cfg.AppLabel()]; ok {
+		app = &appv1.App{}
+		if err := c.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: microService.Namespace}, app); err != nil {
+			if !errors.IsNotFound(err) {
+				return false, err
+			}
+			app = nil
+		}
+	}
+	list := &appv1.MicroServiceList{}
+	if err := c.List(context.TODO(), client.InNamespace(microService.Namespace), list); err != nil {
+		return false, err
+	}
+	microServices := make([]*appv1.MicroService, 0, len(list.Items))
+	for i := range list.Items {
+		microServices = append(microServices, &list.Items[i])
+	}
+	return render.SelectedByNetworkPolicy(cfg, microService, app, microServices), nil
+}
+
+// enqueueNetworkPolicyPeers 返回一个 EventHandler，它把 NetworkPolicy 允许访问发生变化的 MicroService 的其他 MicroService 加入工作队列，
+// 这些 MicroService 的 Pod 模板是否需要标签取决于它。
+func enqueueNetworkPolicyPeers(cfg *configv1alpha1.ManagerConfig) handler.EventHandler {
+	return &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+			microService, ok := obj.Object.(*appv1.MicroService)
+			if !ok {
+				return nil
+			}
+			var requests []reconcile.Request
+			for _, name := range render.NetworkPolicyPeers(cfg, microService) {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: microService.Namespace}})
+			}
+			return requests
+		}),
+	}
+}
+
+// enqueueAppMicroServices 返回一个 EventHandler，它在 App 发生变化时把 App 的所有 MicroService 加入工作队列，
+// 它们的 Pod 模板是否需要标签取决于 App 的 DefaultDeny。
+func enqueueAppMicroServices(cfg *configv1alpha1.ManagerConfig) handler.EventHandler {
+	return &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+			app, ok := obj.Object.(*appv1.App)
+			if !ok {
+				return nil
+			}
+			requests := make([]reconcile.Request, 0, len(app.Spec.MicroServices))
+			for _, template := range app.Spec.MicroServices {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: cfg.Name(app.Name, template.Name), Namespace: app.Namespace}})
+			}
+			return requests
+		}),
+	}
+}
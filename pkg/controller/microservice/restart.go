@@ -0,0 +1,64 @@
+package microservice
+
+import (
+	appv1 "canary-crd/pkg/apis/app/v1"
+	"canary-crd/pkg/render"
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+//restart.go: 这个文件负责在版本引用的 ConfigMap 或 Secret 内容发生变化时自动滚动重启版本的 Pod。
+//开启了 RestartOnConfigChange 的版本，其 Pod 模板通过 env、envFrom 和 volumes 引用的 ConfigMap 和 Secret 会被记录在 MicroService 的字段索引中，
+//这些对象发生变化时，控制器通过索引找到对应的 MicroService，并把它们内容的哈希值写入 Pod 模板的注解，由 Kubernetes 完成滚动更新。
+//这个功能按版本开启，因此灰度版本可以使用新的配置，而稳定版本保持不变。
+
+// configMapRefIndex 和 secretRefIndex 是 MicroService 上记录被引用的 ConfigMap 和 Secret 名字的字段索引
+const (
+	configMapRefIndex = "spec.versions.configMapRefs"
+	secretRefIndex    = "spec.versions.secretRefs"
+)
+
+// indexConfigMapRefs 和 indexSecretRefs 是 MicroService 字段索引的取值函数
+func indexConfigMapRefs(obj runtime.Object) []string {
+	microService := obj.(*appv1.MicroService)
+	var refs []string
+	for i := range microService.Spec.Versions {
+		configMaps, _ := render.ConfigReferences(&microService.Spec.Versions[i], microService)
+		refs = append(refs, configMaps...)
+	}
+	return refs
+}
+
+func indexSecretRefs(obj runtime.Object) []string {
+	microService := obj.(*appv1.MicroService)
+	var refs []string
+	for i := range microService.Spec.Versions {
+		_, secrets := render.ConfigReferences(&microService.Spec.Versions[i], microService)
+		refs = append(refs, secrets...)
+	}
+	return refs
+}
+
+// enqueueReferencingMicroServices 返回一个 EventHandler，它通过字段索引找到引用了发生变化的对象的 MicroService。
+func enqueueReferencingMicroServices(c client.Client, index string) handler.EventHandler {
+	return &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(obj handler.MapObject) []reconcile.Request {
+			list := &appv1.MicroServiceList{}
+			opts := client.InNamespace(obj.Meta.GetNamespace()).MatchingField(index, obj.Meta.GetName())
+			if err := c.List(context.TODO(), opts, list); err != nil {
+				log.Error(err, "List MicroServices referencing config error", "namespace", obj.Meta.GetNamespace(), "name", obj.Meta.GetName())
+				return nil
+			}
+			requests := make([]reconcile.Request, 0, len(list.Items))
+			for _, ms := range list.Items {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: ms.Name, Namespace: ms.Namespace}})
+			}
+			return requests
+		}),
+	}
+}
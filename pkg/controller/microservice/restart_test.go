@@ -0,0 +1,65 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package microservice
+
+import (
+	"testing"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+
+	"github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIndexConfigRefs(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	tpl := corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "init", EnvFrom: []corev1.EnvFromSource{
+			{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "init-secret"}}},
+		}}},
+		Containers: []corev1.Container{{
+			Name: "web",
+			Env: []corev1.EnvVar{
+				{Name: "MODE", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "web-config"}, Key: "mode"}}},
+				{Name: "PLAIN", Value: "plain"},
+			},
+			EnvFrom: []corev1.EnvFromSource{
+				{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "shared"}}},
+			},
+		}},
+		Volumes: []corev1.Volume{
+			{Name: "config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "web-config"}}}},
+			{Name: "tls", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "web-tls"}}},
+		},
+	}}
+
+	ms := &appv1.MicroService{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: appv1.MicroServiceSpec{Versions: []appv1.DeployVersion{
+			{Name: "v1", Template: appsv1.DeploymentSpec{Template: tpl}},
+			{Name: "v2", Template: appsv1.DeploymentSpec{Template: tpl}, RestartOnConfigChange: true},
+		}},
+	}
+	g.Expect(indexConfigMapRefs(ms)).To(gomega.Equal([]string{"shared", "web-config"}))
+	g.Expect(indexSecretRefs(ms)).To(gomega.Equal([]string{"init-secret", "web-tls"}))
+
+}
@@ -0,0 +1,138 @@
+package microservice
+
+import (
+	appv1 "canary-crd/pkg/apis/app/v1"
+	"canary-crd/pkg/render"
+	"context"
+	"encoding/json"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+//snapshot.go: 这个文件负责创建和清理每个版本的配置快照。
+//版本声明的配置（字面量数据，或者引用已有的 ConfigMap 和 Secret）由 render 包复制到以内容哈希命名的 ConfigMap 或 Secret 中并挂载到版本的 Pod 模板，
+//这些快照由 MicroService 拥有并且创建后不再修改。这样灰度版本使用自己的配置，而不是与稳定版本共享可变的 ConfigMap。
+//滚动更新期间以及回滚时，旧的 ReplicaSet 和 StatefulSet 的 ControllerRevision 仍然引用旧的快照，这些快照会保留到它们被清理，
+//版本被删除后它的快照才会全部被清理。
+
+// syncConfigSnapshots 为版本渲染出的配置快照设置 OwnerReference 并创建它们，ReportOnly 模式下不创建。
+func (r *ReconcileMicroService) syncConfigSnapshots(microService *appv1.MicroService, snapshots []render.Object) error {
+	for _, obj := range snapshots {
+		if err := controllerutil.SetControllerReference(microService, obj, r.scheme); err != nil {
+			return err
+		}
+		if microService.Spec.ReportOnly() {
+			continue
+		}
+		if err := r.createSnapshot(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createSnapshot 创建快照，快照的名字包含内容的哈希值，因此已经存在的快照从不更新。
+func (r *ReconcileMicroService) createSnapshot(obj render.Object) error {
+	found := obj.DeepCopyObject().(render.Object)
+	err := r.Get(context.TODO(), types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}, found)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating config snapshot", "namespace", obj.GetNamespace(), "name", obj.GetName())
+		if err := r.Create(context.TODO(), obj); err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+		return nil
+	}
+	return err
+}
+
+// cleanUpConfigSnapshots 删除 MicroService 拥有的、不在 staySnapshotName 中的快照。
+// 版本仍然存在时，它的 ReplicaSet 或 ControllerRevision 仍在引用的快照会被保留。
+func (r *ReconcileMicroService) cleanUpConfigSnapshots(microService *appv1.MicroService, staySnapshotName map[string]bool) error {
+	opts := client.InNamespace(microService.Namespace).MatchingLabels(map[string]string{r.config.ServiceLabel(): microService.Name})
+
+	cmList := &corev1.ConfigMapList{}
+	if err := r.List(context.TODO(), opts, cmList); err != nil {
+		return err
+	}
+	secretList := &corev1.SecretList{}
+	if err := r.List(context.TODO(), opts, secretList); err != nil {
+		return err
+	}
+	referenced, err := r.referencedSnapshots(microService)
+	if err != nil {
+		return err
+	}
+
+	versions := make(map[string]bool, len(microService.Spec.Versions))
+	for _, version := range microService.Spec.Versions {
+		versions[version.Name] = true
+	}
+
+	var orphans []render.Object
+	for i := range cmList.Items {
+		orphans = append(orphans, &cmList.Items[i])
+	}
+	for i := range secretList.Items {
+		orphans = append(orphans, &secretList.Items[i])
+	}
+	for _, obj := range orphans {
+		if _, ok := obj.GetLabels()[r.config.SnapshotLabel()]; !ok || staySnapshotName[obj.GetName()] || !metav1.IsControlledBy(obj, microService) {
+			continue
+		}
+		if versions[obj.GetLabels()[r.config.VersionLabel()]] && referenced[obj.GetName()] {
+			continue
+		}
+		log.Info("Find unused config snapshot", "namespace", microService.Namespace, "MicroService", microService.Name, "snapshot", obj.GetName())
+		if err := r.Delete(context.TODO(), obj); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// referencedSnapshots 返回 MicroService 的 Deployment 的 ReplicaSet 和 StatefulSet 的 ControllerRevision 中，
+// Pod 模板引用的 ConfigMap 和 Secret 的名字。它们带有 Pod 模板的 service 标签。
+func (r *ReconcileMicroService) referencedSnapshots(microService *appv1.MicroService) (map[string]bool, error) {
+	opts := client.InNamespace(microService.Namespace).MatchingLabels(map[string]string{r.config.ServiceLabel(): microService.Name})
+
+	var templates []*corev1.PodTemplateSpec
+	rsList := &appsv1.ReplicaSetList{}
+	if err := r.List(context.TODO(), opts, rsList); err != nil {
+		return nil, err
+	}
+	for i := range rsList.Items {
+		templates = append(templates, &rsList.Items[i].Spec.Template)
+	}
+	revisionList := &appsv1.ControllerRevisionList{}
+	if err := r.List(context.TODO(), opts, revisionList); err != nil {
+		return nil, err
+	}
+	for _, revision := range revisionList.Items {
+		// StatefulSet 的 ControllerRevision 保存的是替换 spec.template 的 patch
+		patch := struct {
+			Spec struct {
+				Template corev1.PodTemplateSpec `json:"template"`
+			} `json:"spec"`
+		}{}
+		if err := json.Unmarshal(revision.Data.Raw, &patch); err != nil {
+			log.Error(err, "Decode ControllerRevision error", "namespace", revision.Namespace, "name", revision.Name)
+			continue
+		}
+		templates = append(templates, &patch.Spec.Template)
+	}
+
+	referenced := make(map[string]bool)
+	for _, tpl := range templates {
+		configMaps, secrets := render.PodTemplateReferences(tpl)
+		for _, name := range append(configMaps, secrets...) {
+			referenced[name] = true
+		}
+	}
+	return referenced, nil
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package microservice
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"canary-crd/pkg/apis"
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	"github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestCleanUpConfigSnapshots(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(kubescheme.AddToScheme(scheme)).To(gomega.Succeed())
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+	cfg := configv1alpha1.Default()
+
+	ms := &appv1.MicroService{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "web-uid"},
+		Spec:       appv1.MicroServiceSpec{Versions: []appv1.DeployVersion{{Name: "v1"}, {Name: "v2"}}},
+	}
+	labels := func(version string) map[string]string {
+		return map[string]string{cfg.ServiceLabel(): "web", cfg.VersionLabel(): version, cfg.SnapshotLabel(): "app"}
+	}
+	snapshot := func(name, version string) *corev1.ConfigMap {
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels(version)}}
+		g.Expect(controllerutil.SetControllerReference(ms, cm, scheme)).To(gomega.Succeed())
+		return cm
+	}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "web-v2-tls-old", Namespace: "default", Labels: labels("v2")}}
+	g.Expect(controllerutil.SetControllerReference(ms, secret, scheme)).To(gomega.Succeed())
+
+	mounting := func(names ...string) corev1.PodTemplateSpec {
+		tpl := corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{cfg.ServiceLabel(): "web"}}}
+		for _, name := range names {
+			tpl.Spec.Volumes = append(tpl.Spec.Volumes, corev1.Volume{Name: name, VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: name}}}})
+		}
+		return tpl
+	}
+	// the previous ReplicaSet of v1 still mounts its old snapshot, and so does the one of the removed v0
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-v1-old", Namespace: "default", Labels: map[string]string{cfg.ServiceLabel(): "web"}},
+		Spec:       appsv1.ReplicaSetSpec{Template: mounting("web-v1-app-old", "web-v0-app-old")},
+	}
+	// the previous revision of the v2 StatefulSet still mounts its old Secret
+	tpl := corev1.PodTemplateSpec{Spec: corev1.PodSpec{Volumes: []corev1.Volume{{Name: "tls", VolumeSource: corev1.VolumeSource{
+		Secret: &corev1.SecretVolumeSource{SecretName: "web-v2-tls-old"}}}}}}
+	data, err := json.Marshal(map[string]interface{}{"spec": map[string]interface{}{"template": tpl}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	revision := &appsv1.ControllerRevision{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-v2-1", Namespace: "default", Labels: map[string]string{cfg.ServiceLabel(): "web"}},
+		Data:       runtime.RawExtension{Raw: data},
+	}
+
+	r := &ReconcileMicroService{Client: fake.NewFakeClientWithScheme(scheme, ms, rs, revision, secret,
+		snapshot("web-v1-app-new", "v1"),
+		snapshot("web-v1-app-old", "v1"),
+		snapshot("web-v1-app-older", "v1"),
+		snapshot("web-v0-app-old", "v0"),
+	), scheme: scheme, config: cfg}
+
+	g.Expect(r.cleanUpConfigSnapshots(ms, map[string]bool{"web-v1-app-new": true})).To(gomega.Succeed())
+	exists := func(obj runtime.Object, name string) bool {
+		err := r.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: "default"}, obj)
+		g.Expect(err == nil || errors.IsNotFound(err)).To(gomega.BeTrue())
+		return err == nil
+	}
+	g.Expect(exists(&corev1.ConfigMap{}, "web-v1-app-new")).To(gomega.BeTrue())
+	g.Expect(exists(&corev1.ConfigMap{}, "web-v1-app-old")).To(gomega.BeTrue())
+	g.Expect(exists(&corev1.Secret{}, "web-v2-tls-old")).To(gomega.BeTrue())
+	g.Expect(exists(&corev1.ConfigMap{}, "web-v1-app-older")).To(gomega.BeFalse())
+	g.Expect(exists(&corev1.ConfigMap{}, "web-v0-app-old")).To(gomega.BeFalse())
+
+	// once the old ReplicaSet is gone its snapshot is deleted too
+	g.Expect(r.Delete(context.TODO(), rs)).To(gomega.Succeed())
+	g.Expect(r.cleanUpConfigSnapshots(ms, map[string]bool{"web-v1-app-new": true})).To(gomega.Succeed())
+	g.Expect(exists(&corev1.ConfigMap{}, "web-v1-app-old")).To(gomega.BeFalse())
+}
+
+func TestCreateSnapshotKeepsExisting(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(kubescheme.AddToScheme(scheme)).To(gomega.Succeed())
+	existing := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-v1-app-5d8f9c7b4", Namespace: "default"},
+		Data:       map[string]string{"mode": "old"},
+	}
+	r := &ReconcileMicroService{Client: fake.NewFakeClientWithScheme(scheme, existing), scheme: scheme, config: configv1alpha1.Default()}
+
+	desired := existing.DeepCopy()
+	desired.Data = map[string]string{"mode": "new"}
+	g.Expect(r.createSnapshot(desired)).To(gomega.Succeed())
+
+	found := &corev1.ConfigMap{}
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: existing.Name, Namespace: "default"}, found)).To(gomega.Succeed())
+	g.Expect(found.Data).To(gomega.Equal(map[string]string{"mode": "old"}))
+
+	created := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "web-v1-app-7c6b5a4d3", Namespace: "default"}}
+	g.Expect(r.createSnapshot(created)).To(gomega.Succeed())
+	g.Expect(r.Get(context.TODO(), types.NamespacedName{Name: created.Name, Namespace: "default"}, found)).To(gomega.Succeed())
+}
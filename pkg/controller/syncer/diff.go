@@ -0,0 +1,124 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// diffOwned returns the paths of the fields of found that applying desired would
+// change. The last-applied hash annotation is ignored, so that objects created
+// before the controller managed them are compared by content only.
+func diffOwned(found, desired Object, mutate MutateFn) ([]string, error) {
+	applied := found.DeepCopyObject().(Object)
+	applyOwned(applied, desired, mutate)
+
+	want, err := runtime.DefaultUnstructuredConverter.ToUnstructured(applied)
+	if err != nil {
+		return nil, err
+	}
+	have, err := runtime.DefaultUnstructuredConverter.ToUnstructured(found)
+	if err != nil {
+		return nil, err
+	}
+	for _, obj := range []map[string]interface{}{want, have} {
+		if annotations, ok := obj["metadata"].(map[string]interface{})["annotations"].(map[string]interface{}); ok {
+			delete(annotations, LastAppliedHashAnnotation)
+		}
+	}
+
+	var fields []string
+	diffValue("", want["metadata"], have["metadata"], &fields, "metadata")
+	diffValue("", want["spec"], have["spec"], &fields, "spec")
+	return fields, nil
+}
+
+// diffValue appends path to fields when want and have differ. Zero values in want
+// are treated as unset: the API server defaults them, so they are not compared.
+// Keys of labels and annotations that want removes are reported as well; other
+// missing keys are fields defaulted by the API server.
+func diffValue(parent string, want, have interface{}, fields *[]string, key string) {
+	path := key
+	if parent != "" {
+		path = parent + "." + key
+	}
+	if isZero(want) {
+		return
+	}
+
+	switch w := want.(type) {
+	case map[string]interface{}:
+		h, ok := have.(map[string]interface{})
+		if !ok && have != nil {
+			*fields = append(*fields, path)
+			return
+		}
+		keys := make([]string, 0, len(w)+len(h))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		if key == "labels" || key == "annotations" {
+			for k := range h {
+				if _, ok := w[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if _, ok := w[k]; !ok {
+				*fields = append(*fields, path+"."+k)
+				continue
+			}
+			diffValue(path, w[k], h[k], fields, k)
+		}
+	case []interface{}:
+		h, ok := have.([]interface{})
+		if !ok || len(w) != len(h) {
+			*fields = append(*fields, path)
+			return
+		}
+		for i := range w {
+			diffValue("", w[i], h[i], fields, fmt.Sprintf("%s[%d]", path, i))
+		}
+	default:
+		if !reflect.DeepEqual(want, have) {
+			*fields = append(*fields, path)
+		}
+	}
+}
+
+// isZero reports whether v is unset or the zero value of a scalar.
+func isZero(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case bool:
+		return !v
+	case int64:
+		return v == 0
+	case float64:
+		return v == 0
+	}
+	return false
+}
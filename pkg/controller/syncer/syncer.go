@@ -0,0 +1,189 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package syncer creates and updates the objects owned by the controllers.
+//
+// The API server defaults fields and other controllers (HPAs, the service
+// allocator) set fields of the objects we create, so comparing a desired object
+// with the one in the cluster never converges. Instead the syncer stores a hash
+// of the desired object in the last-applied-hash annotation and only updates the
+// object when the desired state itself changed. On update a MutateFn copies the
+// fields owned by the controller onto the object read from the cluster, leaving
+// every other field untouched. When the hash matches but the owned fields were
+// edited behind the controller's back, the edit is reverted and reported as drift.
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// LastAppliedHashAnnotation records the hash of the desired object last written by the controller.
+const LastAppliedHashAnnotation = "app.o0w0o.cn/last-applied-hash"
+
+// Object is a Kubernetes object managed by the syncer.
+type Object interface {
+	runtime.Object
+	metav1.Object
+}
+
+// MutateFn copies the fields owned by the controller from the desired object onto
+// found, the object read from the cluster.
+type MutateFn func(found Object)
+
+// OperationResult is the action taken by Sync.
+type OperationResult string
+
+const (
+	OperationResultNone    OperationResult = "unchanged"
+	OperationResultCreated OperationResult = "created"
+	OperationResultUpdated OperationResult = "updated"
+	// OperationResultReverted means the desired state did not change but the object
+	// in the cluster was edited, and the owned fields were written back.
+	OperationResultReverted OperationResult = "reverted"
+)
+
+// Sync creates desired when it does not exist yet. When it exists and either its
+// last-applied hash differs from the hash of desired or its owned fields drifted,
+// the labels and annotations of desired are merged into the found object, mutate
+// copies the owned fields and the object is updated. Labels and annotations set
+// by others are kept.
+func Sync(c client.Client, desired Object, mutate MutateFn) (OperationResult, error) {
+	hash, err := Hash(desired)
+	if err != nil {
+		return OperationResultNone, err
+	}
+	annotations := desired.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string, 1)
+	}
+	annotations[LastAppliedHashAnnotation] = hash
+	desired.SetAnnotations(annotations)
+
+	found := newObject(desired)
+	err = c.Get(context.TODO(), types.NamespacedName{Name: desired.GetName(), Namespace: desired.GetNamespace()}, found)
+	if err != nil && errors.IsNotFound(err) {
+		if err := c.Create(context.TODO(), desired); err != nil {
+			return OperationResultNone, err
+		}
+		return OperationResultCreated, nil
+	} else if err != nil {
+		return OperationResultNone, err
+	}
+
+	result := OperationResultUpdated
+	if found.GetAnnotations()[LastAppliedHashAnnotation] == hash {
+		fields, err := diffOwned(found, desired, mutate)
+		if err != nil || len(fields) == 0 {
+			return OperationResultNone, err
+		}
+		result = OperationResultReverted
+	}
+
+	applyOwned(found, desired, mutate)
+	if err := c.Update(context.TODO(), found); err != nil {
+		return OperationResultNone, err
+	}
+	return result, nil
+}
+
+// Drift describes how an object in the cluster differs from what Sync would write.
+type Drift struct {
+	// Missing is set when the object does not exist.
+	Missing bool
+	// Fields are the paths of the owned fields that differ, such as spec.replicas.
+	Fields []string
+}
+
+// Diff reports what Sync would change for desired without changing anything.
+// It returns nil when the object in the cluster is in sync. Fields desired leaves
+// unset are defaulted by the API server or owned by others and are not compared.
+func Diff(c client.Client, desired Object, mutate MutateFn) (*Drift, error) {
+	found := newObject(desired)
+	err := c.Get(context.TODO(), types.NamespacedName{Name: desired.GetName(), Namespace: desired.GetNamespace()}, found)
+	if err != nil && errors.IsNotFound(err) {
+		return &Drift{Missing: true}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	fields, err := diffOwned(found, desired, mutate)
+	if err != nil || len(fields) == 0 {
+		return nil, err
+	}
+	return &Drift{Fields: fields}, nil
+}
+
+// applyOwned merges the labels, annotations and owner references of desired into
+// found and lets mutate copy the owned fields.
+func applyOwned(found, desired Object, mutate MutateFn) {
+	found.SetLabels(mergeStringMap(found.GetLabels(), desired.GetLabels()))
+	found.SetAnnotations(mergeStringMap(found.GetAnnotations(), desired.GetAnnotations()))
+	if len(desired.GetOwnerReferences()) != 0 {
+		found.SetOwnerReferences(desired.GetOwnerReferences())
+	}
+	mutate(found)
+}
+
+// Hash returns the hash of obj, ignoring its last-applied hash annotation.
+func Hash(obj Object) (string, error) {
+	annotations := obj.GetAnnotations()
+	if _, ok := annotations[LastAppliedHashAnnotation]; ok {
+		obj = obj.DeepCopyObject().(Object)
+		annotations = mergeStringMap(nil, obj.GetAnnotations())
+		delete(annotations, LastAppliedHashAnnotation)
+		obj.SetAnnotations(annotations)
+	}
+
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	hasher := fnv.New32a()
+	hasher.Write(data)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32())), nil
+}
+
+// newObject returns an empty object of the type of obj to read the object in the
+// cluster into. Decoding into a copy of obj would merge its maps into the result.
+func newObject(obj Object) Object {
+	return reflect.New(reflect.TypeOf(obj).Elem()).Interface().(Object)
+}
+
+// mergeStringMap returns a copy of base with the values of overlay set.
+func mergeStringMap(base, overlay map[string]string) map[string]string {
+	if len(base) == 0 && len(overlay) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(base)+len(overlay))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overlay {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package syncer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func makeService(port int32) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "voting", Namespace: "default", Labels: map[string]string{"app.o0w0o.cn/service": "voting"}},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: port}}},
+	}
+}
+
+func TestSync(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	c := fake.NewFakeClient()
+	key := types.NamespacedName{Name: "voting", Namespace: "default"}
+	mutate := func(desired *corev1.Service) MutateFn {
+		return func(found Object) {
+			svc := found.(*corev1.Service)
+			clusterIP := svc.Spec.ClusterIP
+			svc.Spec = desired.Spec
+			svc.Spec.ClusterIP = clusterIP
+		}
+	}
+
+	desired := makeService(80)
+	result, err := Sync(c, desired, mutate(desired))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result).To(gomega.Equal(OperationResultCreated))
+
+	// fields defaulted by the API server or set by others never cause an update
+	found := &corev1.Service{}
+	g.Expect(c.Get(context.TODO(), key, found)).To(gomega.Succeed())
+	g.Expect(found.Annotations).To(gomega.HaveKey(LastAppliedHashAnnotation))
+	found.Spec.ClusterIP = "10.0.0.10"
+	found.Spec.Ports[0].Protocol = corev1.ProtocolTCP
+	found.Labels["team"] = "voting"
+	g.Expect(c.Update(context.TODO(), found)).To(gomega.Succeed())
+
+	desired = makeService(80)
+	result, err = Sync(c, desired, mutate(desired))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result).To(gomega.Equal(OperationResultNone))
+
+	// a changed desired state updates only the owned fields
+	desired = makeService(8080)
+	result, err = Sync(c, desired, mutate(desired))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result).To(gomega.Equal(OperationResultUpdated))
+
+	found = &corev1.Service{}
+	g.Expect(c.Get(context.TODO(), key, found)).To(gomega.Succeed())
+	g.Expect(found.Spec.Ports[0].Port).To(gomega.Equal(int32(8080)))
+	g.Expect(found.Spec.ClusterIP).To(gomega.Equal("10.0.0.10"))
+	g.Expect(found.Labels).To(gomega.HaveKeyWithValue("team", "voting"))
+	g.Expect(found.Annotations[LastAppliedHashAnnotation]).To(gomega.Equal(desired.Annotations[LastAppliedHashAnnotation]))
+}
+
+func TestHashIgnoresLastAppliedHash(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	svc := makeService(80)
+	hash, err := Hash(svc)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	svc.Annotations = map[string]string{LastAppliedHashAnnotation: "stale"}
+	g.Expect(Hash(svc)).To(gomega.Equal(hash))
+	g.Expect(svc.Annotations).To(gomega.HaveKeyWithValue(LastAppliedHashAnnotation, "stale"))
+
+	g.Expect(Hash(makeService(8080))).NotTo(gomega.Equal(hash))
+}
+
+func TestDiff(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	c := fake.NewFakeClient()
+	key := types.NamespacedName{Name: "voting", Namespace: "default"}
+	mutate := func(desired *corev1.Service) MutateFn {
+		return func(found Object) {
+			svc := found.(*corev1.Service)
+			clusterIP := svc.Spec.ClusterIP
+			svc.Spec = desired.Spec
+			svc.Spec.ClusterIP = clusterIP
+		}
+	}
+
+	desired := makeService(80)
+	drift, err := Diff(c, desired, mutate(desired))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(drift).To(gomega.Equal(&Drift{Missing: true}))
+
+	_, err = Sync(c, desired, mutate(desired))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// defaulted fields are not drift
+	found := &corev1.Service{}
+	g.Expect(c.Get(context.TODO(), key, found)).To(gomega.Succeed())
+	found.Spec.ClusterIP = "10.0.0.10"
+	found.Spec.Ports[0].Protocol = corev1.ProtocolTCP
+	found.Spec.SessionAffinity = corev1.ServiceAffinityNone
+	g.Expect(c.Update(context.TODO(), found)).To(gomega.Succeed())
+
+	desired = makeService(80)
+	drift, err = Diff(c, desired, mutate(desired))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(drift).To(gomega.BeNil())
+
+	// an edit behind the controller's back is reported and reverted by Sync
+	found.Spec.Ports[0].Port = 8080
+	delete(found.Labels, "app.o0w0o.cn/service")
+	g.Expect(c.Update(context.TODO(), found)).To(gomega.Succeed())
+
+	drift, err = Diff(c, desired, mutate(desired))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(drift).To(gomega.Equal(&Drift{Fields: []string{"metadata.labels.app.o0w0o.cn/service", "spec.ports[0].port"}}))
+
+	result, err := Sync(c, desired, mutate(desired))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result).To(gomega.Equal(OperationResultReverted))
+
+	g.Expect(c.Get(context.TODO(), key, found)).To(gomega.Succeed())
+	g.Expect(found.Spec.Ports[0].Port).To(gomega.Equal(int32(80)))
+	g.Expect(found.Spec.ClusterIP).To(gomega.Equal("10.0.0.10"))
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dashboard serves the release state of the Apps and MicroServices as
+// a read-only JSON API and a small HTML dashboard.
+//
+// Everything is read from the cache of the manager, so the teams watching their
+// releases neither need RBAC on the cluster nor add load to the API Server:
+//
+//	GET /api/v1/apps[?namespace=NAMESPACE]
+//	GET /api/v1/apps/NAMESPACE/NAME
+//	GET /api/v1/microservices[?namespace=NAMESPACE]
+//	GET /api/v1/microservices/NAMESPACE/NAME
+//	GET /api/v1/events[?namespace=NAMESPACE]
+//
+// The lists hold the versions of each MicroService with their weights and
+// readiness; a single App or MicroService also holds its recent Events and
+// those of its workloads. The cache only starts once the manager holds the
+// leader lock, until then the API answers 503 Service Unavailable.
+package dashboard
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+// +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch
+
+var log = logf.Log.WithName("dashboard")
+
+//go:embed index.html
+var index []byte
+
+// Server serves the API and the dashboard.
+type Server struct {
+	reader  client.Reader
+	config  *configv1alpha1.ManagerConfig
+	started int32
+}
+
+// New returns a Server reading from reader, the cache of the manager. The
+// workloads of the versions are named by cfg.
+func New(reader client.Reader, cfg *configv1alpha1.ManagerConfig) *Server {
+	return &Server{reader: reader, config: cfg}
+}
+
+// Start marks the cache as synced and blocks until stop is closed. The Server
+// is added to the manager, which starts it once its caches have synced.
+func (s *Server) Start(stop <-chan struct{}) error {
+	atomic.StoreInt32(&s.started, 1)
+	<-stop
+	atomic.StoreInt32(&s.started, 0)
+	return nil
+}
+
+// Handler returns the handler of the API and the dashboard.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(index)
+	})
+	mux.Handle("/api/v1/apps", s.api(func(r *http.Request, _ []string) (interface{}, error) {
+		return list(s.listApps(r.URL.Query().Get("namespace")))
+	}))
+	mux.Handle("/api/v1/apps/", s.api(func(r *http.Request, key []string) (interface{}, error) {
+		return s.getApp(key[0], key[1])
+	}))
+	mux.Handle("/api/v1/microservices", s.api(func(r *http.Request, _ []string) (interface{}, error) {
+		return list(s.listMicroServices(r.URL.Query().Get("namespace")))
+	}))
+	mux.Handle("/api/v1/microservices/", s.api(func(r *http.Request, key []string) (interface{}, error) {
+		return s.getMicroService(key[0], key[1])
+	}))
+	mux.Handle("/api/v1/events", s.api(func(r *http.Request, _ []string) (interface{}, error) {
+		return list(s.listEvents(r.URL.Query().Get("namespace")))
+	}))
+	return mux
+}
+
+// api returns a handler answering GET requests with the JSON of the result of
+// get. Paths below a list carry the namespace and the name of an object, get
+// receives them as key.
+func (s *Server) api(get func(r *http.Request, key []string) (interface{}, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeError(w, http.StatusMethodNotAllowed, "only GET is allowed")
+			return
+		}
+		if atomic.LoadInt32(&s.started) == 0 {
+			writeError(w, http.StatusServiceUnavailable, "the cache is not started, this manager stands by for the leader lock")
+			return
+		}
+
+		var key []string
+		if strings.Count(r.URL.Path, "/") > 3 {
+			key = strings.Split(strings.SplitN(r.URL.Path, "/", 5)[4], "/")
+			if len(key) != 2 || key[0] == "" || key[1] == "" {
+				writeError(w, http.StatusNotFound, "expected a path like "+strings.Join(strings.SplitN(r.URL.Path, "/", 5)[:4], "/")+"/NAMESPACE/NAME")
+				return
+			}
+		}
+
+		result, err := get(r, key)
+		if errors.IsNotFound(err) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		} else if err != nil {
+			log.Error(err, "unable to read the cache", "path", r.URL.Path)
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+}
+
+// list wraps items in an object, the way the API Server returns lists.
+func list(items interface{}, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"items": items}, nil
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
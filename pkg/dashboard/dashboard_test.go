@@ -0,0 +1,143 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"canary-crd/pkg/apis"
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	"github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestServer(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(kubescheme.AddToScheme(scheme)).To(gomega.Succeed())
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	replicas := int32(2)
+	isController := true
+	app := &appv1.App{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "prod"},
+		Spec: appv1.AppSpec{
+			MicroServices: []appv1.MicroServiceTemplate{{Name: "web"}, {Name: "cart"}},
+			Release: &appv1.AppRelease{
+				Name:  "spring",
+				Steps: []appv1.ReleaseStep{{Weight: 10}, {Weight: 50}},
+			},
+		},
+		Status: appv1.AppStatus{
+			TotalMicroServices: 2,
+			Release:            &appv1.AppReleaseStatus{Name: "spring", Phase: appv1.ReleaseProgressing, CurrentStep: 1},
+		},
+	}
+	ms := &appv1.MicroService{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "shop-web",
+			Namespace:       "prod",
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "app.o0w0o.cn/v1", Kind: "App", Name: "shop", Controller: &isController}},
+		},
+		Spec: appv1.MicroServiceSpec{
+			CurrentVersionName: "v1",
+			Versions: []appv1.DeployVersion{
+				{Name: "v1"},
+				{Name: "v2", Canary: &appv1.Canary{Weight: 50, Header: "X-Canary"}},
+			},
+		},
+	}
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop-web-v1", Namespace: "prod"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{UpdatedReplicas: 2, AvailableReplicas: 2},
+	}
+	event := func(name, kind, involved string, age time.Duration) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "prod"},
+			InvolvedObject: corev1.ObjectReference{Kind: kind, Name: involved, Namespace: "prod"},
+			Reason:         name,
+			LastTimestamp:  metav1.NewTime(time.Now().Add(-age)),
+		}
+	}
+
+	s := New(fake.NewFakeClientWithScheme(scheme, app, ms, deploy,
+		event("ScalingReplicaSet", "Deployment", "shop-web-v1", time.Minute),
+		event("Scheduled", "Pod", "shop-web-v1-abcde", 0),
+		event("Killing", "Deployment", "shop-web-v2", 2*time.Minute),
+	), configv1alpha1.Default())
+	handler := s.Handler()
+	get := func(method, path string, result interface{}) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		if result != nil {
+			g.Expect(json.Unmarshal(w.Body.Bytes(), result)).To(gomega.Succeed(), w.Body.String())
+		}
+		return w.Code
+	}
+
+	// the cache of a standby manager is not started
+	g.Expect(get(http.MethodGet, "/api/v1/apps", nil)).To(gomega.Equal(http.StatusServiceUnavailable))
+	stop := make(chan struct{})
+	defer close(stop)
+	go s.Start(stop)
+	g.Eventually(func() int { return get(http.MethodGet, "/api/v1/apps", nil) }).Should(gomega.Equal(http.StatusOK))
+
+	var apps struct{ Items []App }
+	g.Expect(get(http.MethodGet, "/api/v1/apps?namespace=prod", &apps)).To(gomega.Equal(http.StatusOK))
+	g.Expect(apps.Items).To(gomega.HaveLen(1))
+	g.Expect(*apps.Items[0].Release).To(gomega.Equal(Release{Name: "spring", Phase: appv1.ReleaseProgressing, Step: 2, Steps: 2, Weight: 50}))
+	g.Expect(apps.Items[0].MicroServices).To(gomega.HaveLen(2))
+	g.Expect(apps.Items[0].MicroServices[1].Name).To(gomega.Equal("shop-cart"))
+
+	var web MicroService
+	g.Expect(get(http.MethodGet, "/api/v1/microservices/prod/shop-web", &web)).To(gomega.Equal(http.StatusOK))
+	g.Expect(web.App).To(gomega.Equal("shop"))
+	g.Expect(web.Versions).To(gomega.Equal([]Version{
+		{Name: "v1", Kind: appv1.DeploymentKind, Current: true, Weight: 50, Created: true, Replicas: 2, ReadyReplicas: 2, Ready: true},
+		{Name: "v2", Kind: appv1.DeploymentKind, Weight: 50, Header: "X-Canary"},
+	}))
+	g.Expect(web.Events).To(gomega.HaveLen(2))
+	g.Expect(web.Events[0].Reason).To(gomega.Equal("ScalingReplicaSet"))
+	g.Expect(web.Events[1].Reason).To(gomega.Equal("Killing"))
+
+	var events struct{ Items []Event }
+	g.Expect(get(http.MethodGet, "/api/v1/events", &events)).To(gomega.Equal(http.StatusOK))
+	g.Expect(events.Items).To(gomega.HaveLen(2))
+
+	g.Expect(get(http.MethodGet, "/api/v1/microservices/prod/missing", nil)).To(gomega.Equal(http.StatusNotFound))
+	g.Expect(get(http.MethodGet, "/api/v1/apps/prod", nil)).To(gomega.Equal(http.StatusNotFound))
+	g.Expect(get(http.MethodPost, "/api/v1/apps", nil)).To(gomega.Equal(http.StatusMethodNotAllowed))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	g.Expect(w.Code).To(gomega.Equal(http.StatusOK))
+	g.Expect(strings.Contains(w.Body.String(), "api/v1/apps")).To(gomega.BeTrue())
+}
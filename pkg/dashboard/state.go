@@ -0,0 +1,340 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	"canary-crd/pkg/workload"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// eventLimit is the number of recent Events returned at most.
+const eventLimit = 50
+
+// App is the release state of an App.
+type App struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// AvailableMicroServices of TotalMicroServices report Available.
+	AvailableMicroServices int32          `json:"availableMicroServices"`
+	TotalMicroServices     int32          `json:"totalMicroServices"`
+	Release                *Release       `json:"release,omitempty"`
+	MicroServices          []MicroService `json:"microServices"`
+	Events                 []Event        `json:"events,omitempty"`
+}
+
+// Release is the progress of the release of an App.
+type Release struct {
+	Name  string             `json:"name"`
+	Phase appv1.ReleasePhase `json:"phase,omitempty"`
+	// Step is the current step, counted from 1, of Steps.
+	Step    int32  `json:"step,omitempty"`
+	Steps   int    `json:"steps"`
+	Weight  int    `json:"weight,omitempty"`
+	Paused  bool   `json:"paused,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// MicroService is the release state of a MicroService.
+type MicroService struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// App is the name of the App the MicroService was created for.
+	App            string    `json:"app,omitempty"`
+	CurrentVersion string    `json:"currentVersion"`
+	Versions       []Version `json:"versions"`
+	Events         []Event   `json:"events,omitempty"`
+}
+
+// Version is the traffic and the readiness of a version of a MicroService.
+type Version struct {
+	Name    string             `json:"name"`
+	Kind    appv1.WorkloadKind `json:"kind"`
+	Current bool               `json:"current"`
+	// Weight is the percentage of the traffic the version receives, besides
+	// the requests matching its Header or Cookie.
+	Weight int    `json:"weight"`
+	Header string `json:"header,omitempty"`
+	Cookie string `json:"cookie,omitempty"`
+	// Created is false until the workload of the version exists.
+	Created       bool  `json:"created"`
+	Replicas      int32 `json:"replicas"`
+	ReadyReplicas int32 `json:"readyReplicas"`
+	Ready         bool  `json:"ready"`
+}
+
+// Event is an Event about an App, a MicroService or a workload of a version.
+type Event struct {
+	Namespace string    `json:"namespace"`
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message"`
+	Count     int32     `json:"count"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// listApps returns the Apps of the namespace, of all namespaces when it is empty.
+func (s *Server) listApps(namespace string) ([]App, error) {
+	list := &appv1.AppList{}
+	if err := s.reader.List(context.TODO(), client.InNamespace(namespace), list); err != nil {
+		return nil, err
+	}
+	apps := make([]App, 0, len(list.Items))
+	for i := range list.Items {
+		app, err := s.app(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, *app)
+	}
+	return apps, nil
+}
+
+// getApp returns the App with the name and its recent Events.
+func (s *Server) getApp(namespace, name string) (*App, error) {
+	obj := &appv1.App{}
+	if err := s.reader.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: name}, obj); err != nil {
+		return nil, err
+	}
+	app, err := s.app(obj)
+	if err != nil {
+		return nil, err
+	}
+	involved := map[involvedObject]bool{{kind: "App", name: app.Name}: true}
+	for _, ms := range app.MicroServices {
+		s.addInvolved(involved, &ms)
+	}
+	app.Events, err = s.events(namespace, involved)
+	return app, err
+}
+
+// app returns the state of obj, with the MicroServices created for it. The
+// MicroServices not created yet are shown with the spec of their template.
+func (s *Server) app(obj *appv1.App) (*App, error) {
+	app := &App{
+		Namespace:              obj.Namespace,
+		Name:                   obj.Name,
+		AvailableMicroServices: obj.Status.AvailableMicroServices,
+		TotalMicroServices:     obj.Status.TotalMicroServices,
+		MicroServices:          []MicroService{},
+	}
+	if release := obj.Spec.Release; release != nil {
+		app.Release = &Release{Name: release.Name, Steps: len(release.Steps), Paused: release.Paused}
+		if status := obj.Status.Release; status != nil && status.Name == release.Name {
+			app.Release.Phase = status.Phase
+			app.Release.Message = status.Message
+			if int(status.CurrentStep) < len(release.Steps) {
+				app.Release.Step = status.CurrentStep + 1
+				app.Release.Weight = release.Steps[status.CurrentStep].Weight
+			}
+		}
+	}
+
+	for _, template := range obj.Spec.MicroServices {
+		ms := &appv1.MicroService{}
+		name := s.config.Name(obj.Name, template.Name)
+		err := s.reader.Get(context.TODO(), types.NamespacedName{Namespace: obj.Namespace, Name: name}, ms)
+		if err != nil && errors.IsNotFound(err) {
+			ms.Namespace, ms.Name = obj.Namespace, name
+			ms.Spec = template.Spec
+		} else if err != nil {
+			return nil, err
+		}
+		state, err := s.microService(ms)
+		if err != nil {
+			return nil, err
+		}
+		state.App = obj.Name
+		app.MicroServices = append(app.MicroServices, *state)
+	}
+	return app, nil
+}
+
+// listMicroServices returns the MicroServices of the namespace, of all
+// namespaces when it is empty, including the MicroServices created for Apps.
+func (s *Server) listMicroServices(namespace string) ([]MicroService, error) {
+	list := &appv1.MicroServiceList{}
+	if err := s.reader.List(context.TODO(), client.InNamespace(namespace), list); err != nil {
+		return nil, err
+	}
+	microServices := make([]MicroService, 0, len(list.Items))
+	for i := range list.Items {
+		ms, err := s.microService(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		microServices = append(microServices, *ms)
+	}
+	return microServices, nil
+}
+
+// getMicroService returns the MicroService with the name and its recent Events.
+func (s *Server) getMicroService(namespace, name string) (*MicroService, error) {
+	obj := &appv1.MicroService{}
+	if err := s.reader.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: name}, obj); err != nil {
+		return nil, err
+	}
+	ms, err := s.microService(obj)
+	if err != nil {
+		return nil, err
+	}
+	involved := make(map[involvedObject]bool)
+	s.addInvolved(involved, ms)
+	ms.Events, err = s.events(namespace, involved)
+	return ms, err
+}
+
+// microService returns the state of obj, reading the workloads of its versions.
+func (s *Server) microService(obj *appv1.MicroService) (*MicroService, error) {
+	ms := &MicroService{
+		Namespace:      obj.Namespace,
+		Name:           obj.Name,
+		CurrentVersion: obj.Spec.CurrentVersionName,
+		Versions:       []Version{},
+	}
+	if owner := metav1.GetControllerOf(obj); owner != nil && owner.Kind == "App" {
+		ms.App = owner.Name
+	}
+
+	weights := obj.Spec.Weights()
+	for i := range obj.Spec.Versions {
+		version := &obj.Spec.Versions[i]
+		state := Version{
+			Name:    version.Name,
+			Kind:    version.GetKind(),
+			Current: version.Name == obj.Spec.CurrentVersionName,
+			Weight:  weights[version.Name],
+		}
+		if version.Canary != nil && !state.Current {
+			state.Header = version.Canary.Header
+			state.Cookie = version.Canary.Cookie
+		}
+		status, err := workload.Read(s.reader, s.config, obj, version)
+		if err != nil {
+			return nil, err
+		}
+		state.Created = status.Created
+		state.Replicas = status.Replicas
+		state.ReadyReplicas = status.ReadyReplicas
+		state.Ready = status.Ready
+		ms.Versions = append(ms.Versions, state)
+	}
+	return ms, nil
+}
+
+// involvedObject is an object Events are shown for.
+type involvedObject struct {
+	kind string
+	name string
+}
+
+// addInvolved adds ms and the workloads of its versions to involved.
+func (s *Server) addInvolved(involved map[involvedObject]bool, ms *MicroService) {
+	involved[involvedObject{kind: "MicroService", name: ms.Name}] = true
+	for _, version := range ms.Versions {
+		involved[involvedObject{kind: string(version.Kind), name: s.config.Name(ms.Name, version.Name)}] = true
+	}
+}
+
+// listEvents returns the recent Events of the Apps and MicroServices of the
+// namespace, of all namespaces when it is empty, and of their workloads.
+func (s *Server) listEvents(namespace string) ([]Event, error) {
+	microServices, err := s.listMicroServices(namespace)
+	if err != nil {
+		return nil, err
+	}
+	involved := make(map[string]map[involvedObject]bool)
+	for i := range microServices {
+		ms := &microServices[i]
+		if involved[ms.Namespace] == nil {
+			involved[ms.Namespace] = make(map[involvedObject]bool)
+		}
+		s.addInvolved(involved[ms.Namespace], ms)
+		if ms.App != "" {
+			involved[ms.Namespace][involvedObject{kind: "App", name: ms.App}] = true
+		}
+	}
+
+	list := &corev1.EventList{}
+	if err := s.reader.List(context.TODO(), client.InNamespace(namespace), list); err != nil {
+		return nil, err
+	}
+	return recentEvents(list.Items, func(event *corev1.Event) bool {
+		return isAppEvent(event) || involved[event.Namespace][involvedObject{kind: event.InvolvedObject.Kind, name: event.InvolvedObject.Name}]
+	}), nil
+}
+
+// events returns the recent Events of the namespace about the involved objects.
+func (s *Server) events(namespace string, involved map[involvedObject]bool) ([]Event, error) {
+	list := &corev1.EventList{}
+	if err := s.reader.List(context.TODO(), client.InNamespace(namespace), list); err != nil {
+		return nil, err
+	}
+	return recentEvents(list.Items, func(event *corev1.Event) bool {
+		return involved[involvedObject{kind: event.InvolvedObject.Kind, name: event.InvolvedObject.Name}]
+	}), nil
+}
+
+// isAppEvent reports whether event is about an App or a MicroService.
+func isAppEvent(event *corev1.Event) bool {
+	gv, err := schema.ParseGroupVersion(event.InvolvedObject.APIVersion)
+	return err == nil && gv.Group == appv1.SchemeGroupVersion.Group
+}
+
+// recentEvents returns the latest events matching the filter, newest first.
+func recentEvents(items []corev1.Event, filter func(*corev1.Event) bool) []Event {
+	events := []Event{}
+	for i := range items {
+		event := &items[i]
+		if !filter(event) {
+			continue
+		}
+		lastSeen := event.LastTimestamp.Time
+		if lastSeen.IsZero() {
+			lastSeen = event.EventTime.Time
+		}
+		events = append(events, Event{
+			Namespace: event.Namespace,
+			Kind:      event.InvolvedObject.Kind,
+			Name:      event.InvolvedObject.Name,
+			Type:      event.Type,
+			Reason:    event.Reason,
+			Message:   event.Message,
+			Count:     event.Count,
+			LastSeen:  lastSeen,
+		})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].LastSeen.After(events[j].LastSeen)
+	})
+	if len(events) > eventLimit {
+		events = events[:eventLimit]
+	}
+	return events
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package leaderelection runs the manager only while it holds a leader lock, so
+// that several replicas of the controller can run for availability without
+// fighting over the objects they reconcile.
+//
+// controller-runtime v0.1 hardcodes the lease durations of its own leader
+// election and never releases the lock, so a standby replica only takes over
+// after the lease of a stopped leader expired. Run uses client-go directly: the
+// durations are configurable and the lock is released when the leader stops.
+// The client-go elector of this version waits a full lease after any change of
+// the lock, so candidates see a released lock as missing and take it at once.
+package leaderelection
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	k8sleaderelection "k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var log = logf.Log.WithName("leaderelection")
+
+// ErrLeaderElectionLost is returned by Run when the lock could not be renewed.
+var ErrLeaderElectionLost = errors.New("leader election lost")
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Options configures the leader election.
+type Options struct {
+	// Enabled turns leader election on, otherwise Run starts immediately.
+	Enabled bool
+	// Namespace of the ConfigMap holding the lock.
+	Namespace string
+	// Name of the ConfigMap holding the lock.
+	Name string
+	// LeaseDuration is how long standby replicas wait before taking over a lock
+	// that was not renewed.
+	LeaseDuration time.Duration
+	// RenewDeadline is how long the leader retries renewing the lock before it
+	// gives up leadership.
+	RenewDeadline time.Duration
+	// RetryPeriod is how long the candidates wait between tries.
+	RetryPeriod time.Duration
+}
+
+// AddFlags registers the leader election flags.
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&o.Enabled, "enable-leader-election", false, "Enable leader election, so that only one of several replicas of the manager is active.")
+	fs.StringVar(&o.Namespace, "leader-election-namespace", "", "The namespace of the leader election ConfigMap, defaults to the namespace of the pod.")
+	fs.StringVar(&o.Name, "leader-election-id", "canary-crd-leader-election", "The name of the leader election ConfigMap.")
+	fs.DurationVar(&o.LeaseDuration, "leader-election-lease-duration", 15*time.Second, "How long standby replicas wait before taking over a lock that was not renewed.")
+	fs.DurationVar(&o.RenewDeadline, "leader-election-renew-deadline", 10*time.Second, "How long the leader retries renewing the lock before giving up leadership.")
+	fs.DurationVar(&o.RetryPeriod, "leader-election-retry-period", 2*time.Second, "How long the candidates wait between tries.")
+}
+
+// Run calls start once the lock is acquired and blocks until stop is closed or
+// leadership is lost. The stop channel passed to start is closed in both cases.
+// When stop is closed, Run waits for start to return and releases the lock so
+// that a standby replica takes over without waiting for the lease to expire.
+func Run(cfg *rest.Config, recorder record.EventRecorder, opts Options, stop <-chan struct{}, start func(stop <-chan struct{}) error) error {
+	if !opts.Enabled {
+		return start(stop)
+	}
+
+	lock, err := newResourceLock(cfg, recorder, opts)
+	if err != nil {
+		return err
+	}
+	return run(&releasableLock{Interface: lock}, opts, stop, start)
+}
+
+// run is Run with the lock of the candidate.
+func run(lock resourcelock.Interface, opts Options, stop <-chan struct{}, start func(stop <-chan struct{}) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	leading := make(chan struct{})
+	result := make(chan error, 1)
+	elector, err := k8sleaderelection.NewLeaderElector(k8sleaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: opts.LeaseDuration,
+		RenewDeadline: opts.RenewDeadline,
+		RetryPeriod:   opts.RetryPeriod,
+		Callbacks: k8sleaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leadingCtx context.Context) {
+				log.Info("became leader", "lock", lock.Describe(), "identity", lock.Identity())
+				close(leading)
+				result <- start(leadingCtx.Done())
+				// start returned on its own, stop the elector as well
+				cancel()
+			},
+			OnStoppedLeading: func() {
+				log.Info("stopped leading", "lock", lock.Describe(), "identity", lock.Identity())
+			},
+			OnNewLeader: func(identity string) {
+				log.Info("new leader elected", "lock", lock.Describe(), "leader", identity)
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Info("waiting for leader lock", "lock", lock.Describe(), "identity", lock.Identity())
+	elector.Run(ctx)
+	// the elector only returns on its own when renewing the lock failed
+	lost := ctx.Err() == nil
+
+	select {
+	case <-leading:
+	default:
+		return nil
+	}
+	err = <-result
+	if lost {
+		return ErrLeaderElectionLost
+	}
+	if releaseErr := release(lock); releaseErr != nil {
+		log.Error(releaseErr, "unable to release leader lock", "lock", lock.Describe())
+	}
+	return err
+}
+
+// release gives up the lock held by this candidate: the holder is cleared and the
+// lease shortened, so that the other candidates acquire it on their next try.
+func release(lock resourcelock.Interface) error {
+	record, err := lock.Get()
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if record.HolderIdentity != lock.Identity() {
+		return nil
+	}
+	now := metav1.Now()
+	log.Info("releasing leader lock", "lock", lock.Describe(), "identity", lock.Identity())
+	return lock.Update(resourcelock.LeaderElectionRecord{
+		LeaseDurationSeconds: 1,
+		AcquireTime:          now,
+		RenewTime:            now,
+		LeaderTransitions:    record.LeaderTransitions,
+	})
+}
+
+// releasableLock presents a released lock as missing: the elector then creates it,
+// which takes over the released lock right away instead of waiting for the lease.
+type releasableLock struct {
+	resourcelock.Interface
+}
+
+// Get returns NotFound when the lock has no holder.
+func (l *releasableLock) Get() (*resourcelock.LeaderElectionRecord, error) {
+	record, err := l.Interface.Get()
+	if err == nil && record.HolderIdentity == "" {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, l.Describe())
+	}
+	return record, err
+}
+
+// Create takes over a released lock, or creates the lock when it does not exist.
+// Updating a lock another candidate took in the meantime fails with a conflict.
+func (l *releasableLock) Create(ler resourcelock.LeaderElectionRecord) error {
+	record, err := l.Interface.Get()
+	if apierrors.IsNotFound(err) {
+		return l.Interface.Create(ler)
+	} else if err != nil {
+		return err
+	}
+	if record.HolderIdentity != "" {
+		return apierrors.NewConflict(schema.GroupResource{Resource: "configmaps"}, l.Describe(), errors.New("the lock was taken by "+record.HolderIdentity))
+	}
+	ler.LeaderTransitions = record.LeaderTransitions + 1
+	return l.Interface.Update(ler)
+}
+
+// newResourceLock creates the ConfigMap lock of a new candidate with a unique identity.
+func newResourceLock(cfg *rest.Config, recorder record.EventRecorder, opts Options) (resourcelock.Interface, error) {
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = os.Getenv("POD_NAMESPACE")
+	}
+	if namespace == "" {
+		return nil, errors.New("unable to find the leader election namespace, please set --leader-election-namespace")
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	identity := hostname + "_" + string(uuid.NewUUID())
+	return resourcelock.New(resourcelock.ConfigMapsResourceLock, namespace, opts.Name, client.CoreV1(), resourcelock.ResourceLockConfig{
+		Identity:      identity,
+		EventRecorder: recorder,
+	})
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leaderelection
+
+import (
+	"testing"
+	"time"
+
+	"github.com/onsi/gomega"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+func TestLeaderHandoff(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	// the lease is much longer than the test waits, so the standby manager can
+	// only take over because the leader released the lock
+	opts := Options{
+		Enabled:       true,
+		Namespace:     "default",
+		Name:          "handoff-test",
+		LeaseDuration: time.Minute,
+		RenewDeadline: 40 * time.Second,
+		RetryPeriod:   500 * time.Millisecond,
+	}
+
+	leaders := make(chan int, 2)
+	stops := []chan struct{}{make(chan struct{}), make(chan struct{})}
+	results := []chan error{make(chan error, 1), make(chan error, 1)}
+	for i := range stops {
+		i := i
+		mgr, err := manager.New(cfg, manager.Options{MetricsBindAddress: "0"})
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		go func() {
+			results[i] <- Run(cfg, mgr.GetRecorder("handoff-test"), opts, stops[i], func(stop <-chan struct{}) error {
+				leaders <- i
+				return mgr.Start(stop)
+			})
+		}()
+	}
+
+	var leader int
+	g.Eventually(leaders, 10*time.Second).Should(gomega.Receive(&leader))
+	g.Consistently(leaders, 2*time.Second).ShouldNot(gomega.Receive())
+
+	close(stops[leader])
+	g.Eventually(results[leader], 10*time.Second).Should(gomega.Receive(gomega.BeNil()))
+
+	standby := 1 - leader
+	g.Eventually(leaders, 10*time.Second).Should(gomega.Receive(gomega.Equal(standby)))
+
+	close(stops[standby])
+	g.Eventually(results[standby], 10*time.Second).Should(gomega.Receive(gomega.BeNil()))
+}
+
+func TestRunWithoutLeaderElection(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	stop := make(chan struct{})
+	close(stop)
+	called := false
+	err := Run(cfg, nil, Options{}, stop, func(s <-chan struct{}) error {
+		called = true
+		g.Expect(s).To(gomega.BeClosed())
+		return nil
+	})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(called).To(gomega.BeTrue())
+}
+
+func TestRunReleasesLock(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	opts := Options{Enabled: true, LeaseDuration: time.Minute, RenewDeadline: 40 * time.Second, RetryPeriod: 100 * time.Millisecond}
+	client := fake.NewSimpleClientset()
+	newLock := func(identity string) resourcelock.Interface {
+		lock, err := resourcelock.New(resourcelock.ConfigMapsResourceLock, "default", "release-test", client.CoreV1(), resourcelock.ResourceLockConfig{
+			Identity:      identity,
+			EventRecorder: record.NewFakeRecorder(100),
+		})
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		return lock
+	}
+
+	first, second := newLock("first"), newLock("second")
+	stopFirst, stopSecond := make(chan struct{}), make(chan struct{})
+	leaders := make(chan string, 2)
+	results := make(chan error, 2)
+	start := func(name string) func(<-chan struct{}) error {
+		return func(stop <-chan struct{}) error {
+			leaders <- name
+			<-stop
+			return nil
+		}
+	}
+
+	go func() { results <- run(&releasableLock{Interface: first}, opts, stopFirst, start("first")) }()
+	g.Eventually(leaders, 5*time.Second).Should(gomega.Receive(gomega.Equal("first")))
+	go func() { results <- run(&releasableLock{Interface: second}, opts, stopSecond, start("second")) }()
+	g.Consistently(leaders, time.Second).ShouldNot(gomega.Receive())
+
+	close(stopFirst)
+	g.Eventually(results, 5*time.Second).Should(gomega.Receive(gomega.BeNil()))
+	g.Eventually(leaders, 5*time.Second).Should(gomega.Receive(gomega.Equal("second")))
+
+	close(stopSecond)
+	g.Eventually(results, 5*time.Second).Should(gomega.Receive(gomega.BeNil()))
+	record, err := second.Get()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(record.HolderIdentity).To(gomega.BeEmpty())
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// IngressClassAnnotation is the annotation older ingress controllers read the ingress class from.
+const IngressClassAnnotation = "kubernetes.io/ingress.class"
+
+// IsV1beta1IngressSpec reports whether spec was decoded from an extensions/v1beta1
+// shaped IngressSpec, that is whether any of its backends names a service.
+func IsV1beta1IngressSpec(spec *extensionsv1beta1.IngressSpec) bool {
+	if spec.Backend != nil && spec.Backend.ServiceName != "" {
+		return true
+	}
+	for _, rule := range spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.ServiceName != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ConvertFromV1beta1IngressSpec converts an extensions/v1beta1 IngressSpec. Paths
+// get the ImplementationSpecific path type, which matches the v1beta1 behaviour.
+func ConvertFromV1beta1IngressSpec(in *extensionsv1beta1.IngressSpec) IngressSpec {
+	out := IngressSpec{}
+	if in.Backend != nil {
+		out.DefaultBackend = convertFromV1beta1Backend(in.Backend)
+	}
+	for _, tls := range in.TLS {
+		out.TLS = append(out.TLS, IngressTLS{
+			Hosts:      append([]string(nil), tls.Hosts...),
+			SecretName: tls.SecretName,
+		})
+	}
+	for _, rule := range in.Rules {
+		outRule := IngressRule{Host: rule.Host}
+		if rule.HTTP != nil {
+			outRule.HTTP = &HTTPIngressRuleValue{}
+			for _, path := range rule.HTTP.Paths {
+				pathType := PathTypeImplementationSpecific
+				outRule.HTTP.Paths = append(outRule.HTTP.Paths, HTTPIngressPath{
+					Path:     path.Path,
+					PathType: &pathType,
+					Backend:  *convertFromV1beta1Backend(&path.Backend),
+				})
+			}
+		}
+		out.Rules = append(out.Rules, outRule)
+	}
+	return out
+}
+
+// ConvertToV1beta1IngressSpec converts to an extensions/v1beta1 IngressSpec. The
+// ingress class name and path types have no v1beta1 counterpart in the spec and
+// resource backends are dropped.
+func ConvertToV1beta1IngressSpec(in *IngressSpec) extensionsv1beta1.IngressSpec {
+	out := extensionsv1beta1.IngressSpec{}
+	if in.DefaultBackend != nil && in.DefaultBackend.Service != nil {
+		out.Backend = convertToV1beta1Backend(in.DefaultBackend)
+	}
+	for _, tls := range in.TLS {
+		out.TLS = append(out.TLS, extensionsv1beta1.IngressTLS{
+			Hosts:      append([]string(nil), tls.Hosts...),
+			SecretName: tls.SecretName,
+		})
+	}
+	for _, rule := range in.Rules {
+		outRule := extensionsv1beta1.IngressRule{Host: rule.Host}
+		if rule.HTTP != nil {
+			outRule.HTTP = &extensionsv1beta1.HTTPIngressRuleValue{}
+			for _, path := range rule.HTTP.Paths {
+				if path.Backend.Service == nil {
+					continue
+				}
+				outRule.HTTP.Paths = append(outRule.HTTP.Paths, extensionsv1beta1.HTTPIngressPath{
+					Path:    path.Path,
+					Backend: *convertToV1beta1Backend(&path.Backend),
+				})
+			}
+		}
+		out.Rules = append(out.Rules, outRule)
+	}
+	return out
+}
+
+// ConvertToV1beta1Ingress converts an Ingress to an extensions/v1beta1 Ingress, moving
+// the ingress class name into the kubernetes.io/ingress.class annotation.
+func ConvertToV1beta1Ingress(in *Ingress) *extensionsv1beta1.Ingress {
+	out := &extensionsv1beta1.Ingress{
+		ObjectMeta: *in.ObjectMeta.DeepCopy(),
+		Spec:       ConvertToV1beta1IngressSpec(&in.Spec),
+		Status: extensionsv1beta1.IngressStatus{
+			LoadBalancer: *in.Status.LoadBalancer.DeepCopy(),
+		},
+	}
+	if in.Spec.IngressClassName != nil {
+		if out.Annotations == nil {
+			out.Annotations = make(map[string]string)
+		}
+		out.Annotations[IngressClassAnnotation] = *in.Spec.IngressClassName
+	}
+	return out
+}
+
+// ConvertFromV1beta1Ingress converts an extensions/v1beta1 Ingress, taking the ingress
+// class name from the kubernetes.io/ingress.class annotation.
+func ConvertFromV1beta1Ingress(in *extensionsv1beta1.Ingress) *Ingress {
+	out := &Ingress{
+		ObjectMeta: *in.ObjectMeta.DeepCopy(),
+		Spec:       ConvertFromV1beta1IngressSpec(&in.Spec),
+		Status: IngressStatus{
+			LoadBalancer: *in.Status.LoadBalancer.DeepCopy(),
+		},
+	}
+	if class, ok := in.Annotations[IngressClassAnnotation]; ok {
+		out.Spec.IngressClassName = &class
+		delete(out.Annotations, IngressClassAnnotation)
+	}
+	return out
+}
+
+func convertFromV1beta1Backend(in *extensionsv1beta1.IngressBackend) *IngressBackend {
+	port := ServiceBackendPort{}
+	if in.ServicePort.Type == intstr.String {
+		port.Name = in.ServicePort.StrVal
+	} else {
+		port.Number = in.ServicePort.IntVal
+	}
+	return &IngressBackend{
+		Service: &IngressServiceBackend{Name: in.ServiceName, Port: port},
+	}
+}
+
+func convertToV1beta1Backend(in *IngressBackend) *extensionsv1beta1.IngressBackend {
+	port := intstr.FromInt(int(in.Service.Port.Number))
+	if in.Service.Port.Name != "" {
+		port = intstr.FromString(in.Service.Port.Name)
+	}
+	return &extensionsv1beta1.IngressBackend{
+		ServiceName: in.Service.Name,
+		ServicePort: port,
+	}
+}
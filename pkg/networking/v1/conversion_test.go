@@ -0,0 +1,61 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+
+	"github.com/onsi/gomega"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestIngressConversion(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	legacy := &extensionsv1beta1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "voting-web",
+			Namespace:   "default",
+			Annotations: map[string]string{IngressClassAnnotation: "nginx"},
+		},
+		Spec: extensionsv1beta1.IngressSpec{
+			TLS: []extensionsv1beta1.IngressTLS{{Hosts: []string{"voting.o0w0o.cn"}, SecretName: "voting-tls"}},
+			Rules: []extensionsv1beta1.IngressRule{{
+				Host: "voting.o0w0o.cn",
+				IngressRuleValue: extensionsv1beta1.IngressRuleValue{HTTP: &extensionsv1beta1.HTTPIngressRuleValue{
+					Paths: []extensionsv1beta1.HTTPIngressPath{
+						{Path: "/", Backend: extensionsv1beta1.IngressBackend{ServiceName: "voting-web", ServicePort: intstr.FromInt(80)}},
+						{Path: "/api", Backend: extensionsv1beta1.IngressBackend{ServiceName: "voting-api", ServicePort: intstr.FromString("http")}},
+					},
+				}},
+			}},
+		},
+	}
+	g.Expect(IsV1beta1IngressSpec(&legacy.Spec)).To(gomega.BeTrue())
+
+	ingress := ConvertFromV1beta1Ingress(legacy)
+	g.Expect(*ingress.Spec.IngressClassName).To(gomega.Equal("nginx"))
+	g.Expect(ingress.Annotations).NotTo(gomega.HaveKey(IngressClassAnnotation))
+	paths := ingress.Spec.Rules[0].HTTP.Paths
+	g.Expect(*paths[0].PathType).To(gomega.Equal(PathTypeImplementationSpecific))
+	g.Expect(paths[0].Backend.Service).To(gomega.Equal(&IngressServiceBackend{Name: "voting-web", Port: ServiceBackendPort{Number: 80}}))
+	g.Expect(paths[1].Backend.Service).To(gomega.Equal(&IngressServiceBackend{Name: "voting-api", Port: ServiceBackendPort{Name: "http"}}))
+
+	g.Expect(ConvertToV1beta1Ingress(ingress)).To(gomega.Equal(legacy))
+}
@@ -0,0 +1,24 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package v1 contains the networking.k8s.io/v1 Ingress types.
+//
+// The vendored k8s.io/api predates networking.k8s.io/v1 Ingress, so the types are
+// mirrored here field for field, together with conversions from and to the
+// extensions/v1beta1 Ingress served by older clusters.
+// +k8s:deepcopy-gen=package,register
+// +groupName=networking.k8s.io
+package v1
@@ -0,0 +1,150 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Ingress is a collection of rules that allow inbound connections to reach the
+// endpoints defined by a backend.
+type Ingress struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IngressSpec   `json:"spec,omitempty"`
+	Status IngressStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// IngressList is a collection of Ingress.
+type IngressList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Ingress `json:"items"`
+}
+
+// IngressSpec describes the Ingress the user wishes to exist.
+type IngressSpec struct {
+	// IngressClassName is the name of the IngressClass cluster resource.
+	// +optional
+	IngressClassName *string `json:"ingressClassName,omitempty"`
+
+	// DefaultBackend is the backend that should handle requests that don't match any rule.
+	// +optional
+	DefaultBackend *IngressBackend `json:"defaultBackend,omitempty"`
+
+	// +optional
+	TLS []IngressTLS `json:"tls,omitempty"`
+
+	// +optional
+	Rules []IngressRule `json:"rules,omitempty"`
+}
+
+// IngressTLS describes the transport layer security associated with an Ingress.
+type IngressTLS struct {
+	// +optional
+	Hosts []string `json:"hosts,omitempty"`
+
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+}
+
+// IngressStatus describe the current state of the Ingress.
+type IngressStatus struct {
+	// +optional
+	LoadBalancer corev1.LoadBalancerStatus `json:"loadBalancer,omitempty"`
+}
+
+// IngressRule represents the rules mapping the paths under a specified host to
+// the related backend services.
+type IngressRule struct {
+	// +optional
+	Host string `json:"host,omitempty"`
+
+	IngressRuleValue `json:",inline,omitempty"`
+}
+
+// IngressRuleValue represents a rule to apply against incoming requests.
+type IngressRuleValue struct {
+	// +optional
+	HTTP *HTTPIngressRuleValue `json:"http,omitempty"`
+}
+
+// HTTPIngressRuleValue is a list of http selectors pointing to backends.
+type HTTPIngressRuleValue struct {
+	Paths []HTTPIngressPath `json:"paths"`
+}
+
+// PathType represents the type of path referred to by a HTTPIngressPath.
+type PathType string
+
+const (
+	// PathTypeExact matches the URL path exactly and with case sensitivity.
+	PathTypeExact = PathType("Exact")
+
+	// PathTypePrefix matches based on a URL path prefix split by '/'.
+	PathTypePrefix = PathType("Prefix")
+
+	// PathTypeImplementationSpecific leaves the matching up to the IngressClass.
+	PathTypeImplementationSpecific = PathType("ImplementationSpecific")
+)
+
+// HTTPIngressPath associates a path with a backend.
+type HTTPIngressPath struct {
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// +optional
+	PathType *PathType `json:"pathType,omitempty"`
+
+	Backend IngressBackend `json:"backend"`
+}
+
+// IngressBackend describes all endpoints for a given service and port.
+type IngressBackend struct {
+	// +optional
+	Service *IngressServiceBackend `json:"service,omitempty"`
+
+	// +optional
+	Resource *corev1.TypedLocalObjectReference `json:"resource,omitempty"`
+}
+
+// IngressServiceBackend references a Kubernetes Service as a Backend.
+type IngressServiceBackend struct {
+	Name string `json:"name"`
+
+	// +optional
+	Port ServiceBackendPort `json:"port,omitempty"`
+}
+
+// ServiceBackendPort is the service port being referenced.
+type ServiceBackendPort struct {
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// +optional
+	Number int32 `json:"number,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Ingress{}, &IngressList{})
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	k8snetworkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AppObjects is the desired state of an App.
+type AppObjects struct {
+	// Order is the rollout order of the MicroServices of the App, by their
+	// names in App.Spec.MicroServices.
+	Order []string
+	// MicroServices holds the MicroService of each name of Order, with the
+	// current step of the App release applied.
+	MicroServices []*appv1.MicroService
+	// DefaultDeny is nil unless the App asks for a default deny NetworkPolicy
+	// and the NetworkPolicy feature is enabled.
+	DefaultDeny *k8snetworkingv1.NetworkPolicy
+}
+
+// Objects returns all the objects of o: the default deny NetworkPolicy followed
+// by the MicroServices in rollout order.
+func (o *AppObjects) Objects() []Object {
+	var objects []Object
+	if o.DefaultDeny != nil {
+		objects = append(objects, o.DefaultDeny)
+	}
+	for _, ms := range o.MicroServices {
+		objects = append(objects, ms)
+	}
+	return objects
+}
+
+// App renders the desired state of app. It fails when the dependencies between
+// its MicroServices are invalid. It does not modify app.
+func App(cfg *configv1alpha1.ManagerConfig, app *appv1.App) (*AppObjects, error) {
+	order, err := RolloutOrder(app)
+	if err != nil {
+		return nil, err
+	}
+	objects := &AppObjects{Order: order}
+
+	templates := make(map[string]*appv1.MicroServiceTemplate)
+	for i := range app.Spec.MicroServices {
+		templates[app.Spec.MicroServices[i].Name] = &app.Spec.MicroServices[i]
+	}
+	for _, name := range order {
+		labels := make(map[string]string, len(app.Labels)+1)
+		for k, v := range app.Labels {
+			labels[k] = v
+		}
+		labels[cfg.AppLabel()] = app.Name
+
+		ms := &appv1.MicroService{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      cfg.Name(app.Name, name),
+				Namespace: app.Namespace,
+				Labels:    labels,
+			},
+			Spec: *templates[name].Spec.DeepCopy(),
+		}
+		applyRelease(app, name, &ms.Spec)
+		objects.MicroServices = append(objects.MicroServices, ms)
+	}
+
+	if app.Spec.DefaultDeny && cfg.Enabled(configv1alpha1.NetworkPolicy) {
+		objects.DefaultDeny = makeDefaultDenyPolicy(cfg, app)
+	}
+	return objects, nil
+}
+
+// RolloutOrder sorts App.Spec.MicroServices topologically by their dependsOn.
+// MicroServices not constrained by a dependency keep their order in the spec,
+// so the result is stable. It fails when dependsOn references an unknown
+// MicroService or the MicroService itself, or when the dependencies form a cycle.
+func RolloutOrder(app *appv1.App) ([]string, error) {
+	templates := app.Spec.MicroServices
+	index := make(map[string]int, len(templates))
+	for i := range templates {
+		if _, exist := index[templates[i].Name]; exist {
+			return nil, fmt.Errorf("duplicate microservice %q", templates[i].Name)
+		}
+		index[templates[i].Name] = i
+	}
+
+	inDegree := make([]int, len(templates))
+	dependents := make([][]int, len(templates))
+	for i := range templates {
+		for _, dep := range templates[i].DependsOn {
+			j, exist := index[dep]
+			if !exist {
+				return nil, fmt.Errorf("microservice %q depends on unknown microservice %q", templates[i].Name, dep)
+			}
+			if j == i {
+				return nil, fmt.Errorf("microservice %q depends on itself", templates[i].Name)
+			}
+			inDegree[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	order := make([]string, 0, len(templates))
+	done := make([]bool, len(templates))
+	for len(order) < len(templates) {
+		next := -1
+		for i := range templates {
+			if !done[i] && inDegree[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			var cycle []string
+			for i := range templates {
+				if !done[i] {
+					cycle = append(cycle, templates[i].Name)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle between microservices: %s", strings.Join(cycle, ", "))
+		}
+		done[next] = true
+		order = append(order, templates[next].Name)
+		for _, i := range dependents[next] {
+			inDegree[i]--
+		}
+	}
+	return order, nil
+}
+
+// applyRelease writes the Canary of the current step of the App release into
+// the target version of the participant name. Once the release is rolled back,
+// the Canary of the target version is removed and all the traffic goes back to
+// the current version.
+func applyRelease(app *appv1.App, name string, spec *appv1.MicroServiceSpec) {
+	release := app.Spec.Release
+	status := app.Status.Release
+	if release == nil || status == nil || status.Name != release.Name || int(status.CurrentStep) >= len(release.Steps) {
+		return
+	}
+
+	for _, p := range release.Participants {
+		if p.MicroService != name {
+			continue
+		}
+		for i := range spec.Versions {
+			version := &spec.Versions[i]
+			if version.Name != p.Version {
+				continue
+			}
+			if status.Phase == appv1.ReleaseRolledBack {
+				version.Canary = nil
+				continue
+			}
+			canary := &appv1.Canary{
+				Weight:      release.Steps[status.CurrentStep].Weight,
+				Header:      release.Header,
+				HeaderValue: release.HeaderValue,
+				Cookie:      release.Cookie,
+			}
+			if version.Canary != nil {
+				canary.CanaryIngressName = version.Canary.CanaryIngressName
+			}
+			version.Canary = canary
+		}
+	}
+}
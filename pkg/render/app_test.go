@@ -0,0 +1,83 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"testing"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+
+	"github.com/onsi/gomega"
+)
+
+func TestRolloutOrder(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app := &appv1.App{Spec: appv1.AppSpec{MicroServices: []appv1.MicroServiceTemplate{
+		{Name: "web", DependsOn: []string{"api"}},
+		{Name: "api", DependsOn: []string{"db-proxy"}},
+		{Name: "worker"},
+		{Name: "db-proxy"},
+	}}}
+	order, err := RolloutOrder(app)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(order).To(gomega.Equal([]string{"worker", "db-proxy", "api", "web"}))
+
+	// Cycles are rejected
+	app.Spec.MicroServices[3].DependsOn = []string{"web"}
+	_, err = RolloutOrder(app)
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	// Unknown dependencies are rejected
+	app.Spec.MicroServices[3].DependsOn = []string{"cache"}
+	_, err = RolloutOrder(app)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestApplyRelease(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app := &appv1.App{
+		Spec: appv1.AppSpec{
+			Release: &appv1.AppRelease{
+				Name:         "feature-x",
+				Participants: []appv1.ReleaseParticipant{{MicroService: "web", Version: "v2"}},
+				Steps:        []appv1.ReleaseStep{{Weight: 10}, {Weight: 50}},
+				Header:       "x-feature",
+				HeaderValue:  "always",
+			},
+		},
+		Status: appv1.AppStatus{
+			Release: &appv1.AppReleaseStatus{Name: "feature-x", Phase: appv1.ReleaseProgressing, CurrentStep: 1},
+		},
+	}
+	spec := &appv1.MicroServiceSpec{Versions: []appv1.DeployVersion{{Name: "v1"}, {Name: "v2"}}}
+
+	applyRelease(app, "web", spec)
+	g.Expect(spec.Versions[0].Canary).To(gomega.BeNil())
+	g.Expect(spec.Versions[1].Canary).To(gomega.Equal(&appv1.Canary{Weight: 50, Header: "x-feature", HeaderValue: "always"}))
+
+	// Non participants are left untouched
+	other := &appv1.MicroServiceSpec{Versions: []appv1.DeployVersion{{Name: "v2"}}}
+	applyRelease(app, "result", other)
+	g.Expect(other.Versions[0].Canary).To(gomega.BeNil())
+
+	// Rolling back removes the canary from every participant
+	app.Status.Release.Phase = appv1.ReleaseRolledBack
+	applyRelease(app, "web", spec)
+	g.Expect(spec.Versions[1].Canary).To(gomega.BeNil())
+}
@@ -0,0 +1,322 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"sort"
+	"unicode/utf8"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+)
+
+// ConfigHashAnnotation records on the pod template of a version the hash of
+// the ConfigMaps and Secrets it references, so that the pods are rolled when
+// their content changes. It is only set for versions with RestartOnConfigChange.
+const ConfigHashAnnotation = "app.o0w0o.cn/config-hash"
+
+// ConfigReferences returns the names of the ConfigMaps and Secrets referenced
+// through env, envFrom and volumes by the pod template of version, when the
+// version restarts on config changes. The names are sorted and unique.
+func ConfigReferences(version *appv1.DeployVersion, microService *appv1.MicroService) (configMaps []string, secrets []string) {
+	if !version.RestartOnConfigChange {
+		return nil, nil
+	}
+	tpl, err := PodTemplate(version, microService)
+	if err != nil {
+		return nil, nil
+	}
+	return PodTemplateReferences(tpl)
+}
+
+// References returns the names of all the ConfigMaps and Secrets the rendering
+// of microService reads, that is those of ConfigReferences and those copied
+// into config snapshots. They are the objects Inputs has to hold.
+func References(microService *appv1.MicroService) (configMaps []string, secrets []string) {
+	cmSet := make(map[string]bool)
+	secretSet := make(map[string]bool)
+	for i := range microService.Spec.Versions {
+		version := &microService.Spec.Versions[i]
+		cms, ss := ConfigReferences(version, microService)
+		for _, name := range cms {
+			cmSet[name] = true
+		}
+		for _, name := range ss {
+			secretSet[name] = true
+		}
+		for _, config := range version.Config {
+			if config.ConfigMapRef != nil {
+				cmSet[config.ConfigMapRef.Name] = true
+			}
+			if config.SecretRef != nil {
+				secretSet[config.SecretRef.Name] = true
+			}
+		}
+	}
+	return sortedKeys(cmSet), sortedKeys(secretSet)
+}
+
+// PodTemplateReferences returns the names of the ConfigMaps and Secrets
+// referenced through env, envFrom and volumes by tpl, sorted and unique.
+func PodTemplateReferences(tpl *corev1.PodTemplateSpec) (configMaps []string, secrets []string) {
+	cmSet := make(map[string]bool)
+	secretSet := make(map[string]bool)
+
+	containers := append(append([]corev1.Container{}, tpl.Spec.InitContainers...), tpl.Spec.Containers...)
+	for _, container := range containers {
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+				cmSet[ref.Name] = true
+			}
+			if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+				secretSet[ref.Name] = true
+			}
+		}
+		for _, envFrom := range container.EnvFrom {
+			if ref := envFrom.ConfigMapRef; ref != nil {
+				cmSet[ref.Name] = true
+			}
+			if ref := envFrom.SecretRef; ref != nil {
+				secretSet[ref.Name] = true
+			}
+		}
+	}
+	for _, volume := range tpl.Spec.Volumes {
+		if volume.ConfigMap != nil {
+			cmSet[volume.ConfigMap.Name] = true
+		}
+		if volume.Secret != nil {
+			secretSet[volume.Secret.SecretName] = true
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					cmSet[source.ConfigMap.Name] = true
+				}
+				if source.Secret != nil {
+					secretSet[source.Secret.Name] = true
+				}
+			}
+		}
+	}
+	return sortedKeys(cmSet), sortedKeys(secretSet)
+}
+
+// versionConfigHash returns the hash of the content of the ConfigMaps and
+// Secrets referenced by version, or an empty string when the version does not
+// restart on config changes. Missing objects are part of the hash, so creating
+// or deleting a referenced object rolls the version as well.
+func versionConfigHash(version *appv1.DeployVersion, microService *appv1.MicroService, inputs *Inputs) string {
+	if !version.RestartOnConfigChange {
+		return ""
+	}
+	configMaps, secrets := ConfigReferences(version, microService)
+
+	hasher := fnv.New32a()
+	for _, name := range configMaps {
+		cm, ok := inputs.ConfigMaps[name]
+		fmt.Fprintf(hasher, "configmap/%s/%t/", name, ok)
+		writeData(hasher, configMapData(cm))
+	}
+	for _, name := range secrets {
+		secret, ok := inputs.Secrets[name]
+		fmt.Fprintf(hasher, "secret/%s/%t/", name, ok)
+		if ok {
+			writeData(hasher, secret.Data)
+		}
+	}
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
+// stampConfigHash records configHash in the annotations of tpl.
+func stampConfigHash(tpl *corev1.PodTemplateSpec, configHash string) {
+	if configHash == "" {
+		return
+	}
+	if tpl.Annotations == nil {
+		tpl.Annotations = make(map[string]string)
+	}
+	tpl.Annotations[ConfigHashAnnotation] = configHash
+}
+
+// versionSnapshot is a config snapshot of a version; exactly one of configMap
+// and secret is set.
+type versionSnapshot struct {
+	config    *appv1.ConfigSnapshot
+	configMap *corev1.ConfigMap
+	secret    *corev1.Secret
+}
+
+func (s *versionSnapshot) object() Object {
+	if s.secret != nil {
+		return s.secret
+	}
+	return s.configMap
+}
+
+// makeConfigSnapshot merges the referenced ConfigMap or Secret with the literal
+// data of config into an immutable ConfigMap or Secret named after its content.
+func makeConfigSnapshot(cfg *configv1alpha1.ManagerConfig, config *appv1.ConfigSnapshot, version *appv1.DeployVersion, microService *appv1.MicroService, inputs *Inputs) (versionSnapshot, error) {
+	snapshot := versionSnapshot{config: config}
+	if config.ConfigMapRef != nil && config.SecretRef != nil {
+		return snapshot, fmt.Errorf("config %q of version %q sets both configMapRef and secretRef", config.Name, version.Name)
+	}
+
+	data := make(map[string][]byte)
+	if ref := config.ConfigMapRef; ref != nil {
+		cm, ok := inputs.ConfigMaps[ref.Name]
+		if !ok {
+			return snapshot, fmt.Errorf("configmap %q referenced by config %q of version %q not found", ref.Name, config.Name, version.Name)
+		}
+		for k, v := range configMapData(cm) {
+			data[k] = v
+		}
+	}
+	if ref := config.SecretRef; ref != nil {
+		secret, ok := inputs.Secrets[ref.Name]
+		if !ok {
+			return snapshot, fmt.Errorf("secret %q referenced by config %q of version %q not found", ref.Name, config.Name, version.Name)
+		}
+		for k, v := range secret.Data {
+			data[k] = v
+		}
+	}
+	for k, v := range config.Data {
+		data[k] = []byte(v)
+	}
+
+	isSecret := config.Secret || config.SecretRef != nil
+	meta := metav1.ObjectMeta{
+		Name:      cfg.Name(microService.Name, version.Name, config.Name, snapshotHash(data, isSecret)),
+		Namespace: microService.Namespace,
+		// The snapshot label records the name of the ConfigSnapshot
+		Labels: map[string]string{
+			cfg.ServiceLabel():  microService.Name,
+			cfg.VersionLabel():  version.Name,
+			cfg.SnapshotLabel(): config.Name,
+		},
+	}
+	if isSecret {
+		snapshot.secret = &corev1.Secret{ObjectMeta: meta, Type: corev1.SecretTypeOpaque, Data: data}
+		return snapshot, nil
+	}
+
+	cm := &corev1.ConfigMap{ObjectMeta: meta}
+	for k, v := range data {
+		if utf8.Valid(v) {
+			if cm.Data == nil {
+				cm.Data = make(map[string]string)
+			}
+			cm.Data[k] = string(v)
+		} else {
+			if cm.BinaryData == nil {
+				cm.BinaryData = make(map[string][]byte)
+			}
+			cm.BinaryData[k] = v
+		}
+	}
+	snapshot.configMap = cm
+	return snapshot, nil
+}
+
+// mountConfigSnapshots mounts the snapshots as volumes into the containers of tpl.
+func mountConfigSnapshots(tpl *corev1.PodTemplateSpec, snapshots []versionSnapshot) {
+	for _, snapshot := range snapshots {
+		volume := corev1.Volume{Name: "snapshot-" + snapshot.config.Name}
+		if snapshot.secret != nil {
+			volume.Secret = &corev1.SecretVolumeSource{SecretName: snapshot.secret.Name}
+		} else {
+			volume.ConfigMap = &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: snapshot.configMap.Name}}
+		}
+		tpl.Spec.Volumes = append(tpl.Spec.Volumes, volume)
+
+		for i := range tpl.Spec.Containers {
+			container := &tpl.Spec.Containers[i]
+			if len(snapshot.config.Containers) > 0 && !containsString(snapshot.config.Containers, container.Name) {
+				continue
+			}
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      volume.Name,
+				MountPath: snapshot.config.MountPath,
+				ReadOnly:  true,
+			})
+		}
+	}
+}
+
+// configMapData returns the data and binary data of cm as bytes; cm may be nil.
+func configMapData(cm *corev1.ConfigMap) map[string][]byte {
+	if cm == nil {
+		return nil
+	}
+	data := make(map[string][]byte, len(cm.Data)+len(cm.BinaryData))
+	for k, v := range cm.Data {
+		data[k] = []byte(v)
+	}
+	for k, v := range cm.BinaryData {
+		data[k] = v
+	}
+	return data
+}
+
+// snapshotHash returns the hash of the content of a snapshot.
+func snapshotHash(data map[string][]byte, isSecret bool) string {
+	hasher := fnv.New32a()
+	if isSecret {
+		hasher.Write([]byte("secret\x00"))
+	}
+	writeData(hasher, data)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
+// writeData writes data to hasher in the order of its keys.
+func writeData(hasher hash.Hash, data map[string][]byte) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		hasher.Write([]byte(k))
+		hasher.Write([]byte{0})
+		hasher.Write(data[k])
+		hasher.Write([]byte{0})
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	if len(set) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"testing"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	"github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConfigReferences(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	tpl := corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "init", EnvFrom: []corev1.EnvFromSource{
+			{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "init-secret"}}},
+		}}},
+		Containers: []corev1.Container{{
+			Name: "web",
+			Env: []corev1.EnvVar{
+				{Name: "MODE", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "web-config"}, Key: "mode"}}},
+				{Name: "PLAIN", Value: "plain"},
+			},
+			EnvFrom: []corev1.EnvFromSource{
+				{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "shared"}}},
+			},
+		}},
+		Volumes: []corev1.Volume{
+			{Name: "config", VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "web-config"}}}},
+			{Name: "tls", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "web-tls"}}},
+		},
+	}}
+
+	configMaps, secrets := PodTemplateReferences(&tpl)
+	g.Expect(configMaps).To(gomega.Equal([]string{"shared", "web-config"}))
+	g.Expect(secrets).To(gomega.Equal([]string{"init-secret", "web-tls"}))
+
+	ms := &appv1.MicroService{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: appv1.MicroServiceSpec{Versions: []appv1.DeployVersion{
+			{Name: "v1", Template: appsv1.DeploymentSpec{Template: tpl},
+				Config: []appv1.ConfigSnapshot{{Name: "app", ConfigMapRef: &corev1.LocalObjectReference{Name: "app-config"}}}},
+			{Name: "v2", Template: appsv1.DeploymentSpec{Template: tpl}, RestartOnConfigChange: true},
+		}},
+	}
+	configMaps, secrets = ConfigReferences(&ms.Spec.Versions[0], ms)
+	g.Expect(configMaps).To(gomega.BeEmpty())
+	g.Expect(secrets).To(gomega.BeEmpty())
+	configMaps, secrets = References(ms)
+	g.Expect(configMaps).To(gomega.Equal([]string{"app-config", "shared", "web-config"}))
+	g.Expect(secrets).To(gomega.Equal([]string{"init-secret", "web-tls"}))
+
+	// The hash follows the content of the referenced objects, including their existence
+	inputs := &Inputs{ConfigMaps: map[string]*corev1.ConfigMap{
+		"shared": {Data: map[string]string{"mode": "stable"}},
+	}}
+	hash := versionConfigHash(&ms.Spec.Versions[1], ms, inputs)
+	g.Expect(hash).NotTo(gomega.BeEmpty())
+	g.Expect(versionConfigHash(&ms.Spec.Versions[0], ms, inputs)).To(gomega.BeEmpty())
+	inputs.ConfigMaps["shared"].Data["mode"] = "canary"
+	g.Expect(versionConfigHash(&ms.Spec.Versions[1], ms, inputs)).NotTo(gomega.Equal(hash))
+	inputs.ConfigMaps["web-config"] = &corev1.ConfigMap{}
+	g.Expect(versionConfigHash(&ms.Spec.Versions[1], ms, inputs)).NotTo(gomega.Equal(hash))
+
+	deploy, err := makeVersionDeployment(configv1alpha1.Default(), &ms.Spec.Versions[1], ms, "abc")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(deploy.Spec.Template.Annotations).To(gomega.HaveKeyWithValue(ConfigHashAnnotation, "abc"))
+	g.Expect(ms.Spec.Versions[1].Template.Template.Annotations).To(gomega.BeEmpty())
+}
+
+func TestConfigSnapshots(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	ms := &appv1.MicroService{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+	version := &appv1.DeployVersion{
+		Name: "v2",
+		Config: []appv1.ConfigSnapshot{
+			{Name: "app", MountPath: "/etc/app", Data: map[string]string{"mode": "canary"}},
+			{Name: "token", MountPath: "/etc/token", Containers: []string{"web"}, Secret: true, Data: map[string]string{"token": "s3cr3t"}},
+			{Name: "shared", MountPath: "/etc/shared", ConfigMapRef: &corev1.LocalObjectReference{Name: "shared"}},
+		},
+	}
+	cfg := configv1alpha1.Default()
+	inputs := &Inputs{}
+
+	app, err := makeConfigSnapshot(cfg, &version.Config[0], version, ms, inputs)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(app.secret).To(gomega.BeNil())
+	g.Expect(app.configMap.Data).To(gomega.Equal(map[string]string{"mode": "canary"}))
+	g.Expect(app.configMap.Labels).To(gomega.HaveKeyWithValue("app.o0w0o.cn/snapshot", "app"))
+
+	token, err := makeConfigSnapshot(cfg, &version.Config[1], version, ms, inputs)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(token.configMap).To(gomega.BeNil())
+	g.Expect(token.secret.Data).To(gomega.Equal(map[string][]byte{"token": []byte("s3cr3t")}))
+
+	// The name follows the content
+	version.Config[0].Data["mode"] = "stable"
+	changed, err := makeConfigSnapshot(cfg, &version.Config[0], version, ms, inputs)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(changed.configMap.Name).NotTo(gomega.Equal(app.configMap.Name))
+	g.Expect(changed.configMap.Name).To(gomega.HavePrefix("web-v2-app-"))
+
+	// Referenced objects must be part of the inputs
+	_, err = makeConfigSnapshot(cfg, &version.Config[2], version, ms, inputs)
+	g.Expect(err).To(gomega.HaveOccurred())
+	inputs.ConfigMaps = map[string]*corev1.ConfigMap{"shared": {Data: map[string]string{"region": "eu"}}}
+	shared, err := makeConfigSnapshot(cfg, &version.Config[2], version, ms, inputs)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(shared.configMap.Data).To(gomega.Equal(map[string]string{"region": "eu"}))
+
+	tpl := &corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}, {Name: "proxy"}}}}
+	mountConfigSnapshots(tpl, []versionSnapshot{app, token})
+	g.Expect(tpl.Spec.Volumes).To(gomega.HaveLen(2))
+	g.Expect(tpl.Spec.Volumes[0].ConfigMap.Name).To(gomega.Equal(app.configMap.Name))
+	g.Expect(tpl.Spec.Volumes[1].Secret.SecretName).To(gomega.Equal(token.secret.Name))
+	g.Expect(tpl.Spec.Containers[0].VolumeMounts).To(gomega.HaveLen(2))
+	g.Expect(tpl.Spec.Containers[1].VolumeMounts).To(gomega.Equal([]corev1.VolumeMount{
+		{Name: "snapshot-app", MountPath: "/etc/app", ReadOnly: true},
+	}))
+}
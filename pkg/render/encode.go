@@ -0,0 +1,55 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"io"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/yaml"
+)
+
+// Encode writes objects to w as a multi-document YAML stream. The kind and API
+// version of typed objects are looked up in scheme and set on them.
+func Encode(w io.Writer, scheme *runtime.Scheme, objects []Object) error {
+	for _, obj := range objects {
+		var data []byte
+		var err error
+		if u, ok := obj.(*unstructured.Unstructured); ok {
+			data, err = yaml.Marshal(u.Object)
+		} else {
+			gvk, gvkErr := apiutil.GVKForObject(obj, scheme)
+			if gvkErr != nil {
+				return gvkErr
+			}
+			obj.GetObjectKind().SetGroupVersionKind(gvk)
+			data, err = yaml.Marshal(obj)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, "---\n"); err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"strconv"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	networkingv1 "canary-crd/pkg/networking/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The cert-manager annotations asking ingress-shim to issue the certificate of
+// an Ingress.
+const (
+	CertManagerIssuerAnnotation        = "cert-manager.io/issuer"
+	CertManagerClusterIssuerAnnotation = "cert-manager.io/cluster-issuer"
+)
+
+// renderLoadBalance renders the Services and Ingresses of the LoadBalance of
+// microService into objects.
+//
+// Every Service of the LoadBalance selects the pods of the current version, and
+// every version gets its own copy of each of them. Every version with a Canary
+// gets a copy of each Ingress whose backends point to its own Services. The
+// Service name of each version and the name of its canary Ingress are written
+// back into microService.
+func renderLoadBalance(cfg *configv1alpha1.ManagerConfig, microService *appv1.MicroService, objects *MicroServiceObjects) {
+	lb := microService.Spec.LoadBalance
+	if lb == nil || len(microService.Spec.Versions) == 0 {
+		return
+	}
+
+	currentVersion := &microService.Spec.Versions[0]
+	for i := range microService.Spec.Versions {
+		if microService.Spec.Versions[i].Name == microService.Spec.CurrentVersionName {
+			currentVersion = &microService.Spec.Versions[i]
+			break
+		}
+	}
+
+	services := LoadBalanceServices(lb)
+	ingresses := LoadBalanceIngresses(lb)
+
+	for _, svcLB := range services {
+		svcLB.Spec.Selector = currentVersion.PodLabels()
+		objects.Services = append(objects.Services, makeService(svcLB.Name, microService.Namespace, microServiceLabels(cfg, microService), &svcLB.Spec))
+	}
+
+	// ingressSpecs holds the IngressSpecs with their TLS settings; the canary
+	// Ingresses share the certificate Secret of the main Ingress
+	ingressSpecs := make(map[string]*networkingv1.IngressSpec, len(ingresses))
+	for _, ingressLB := range ingresses {
+		spec, annotations := makeIngressTLS(ingressLB)
+		if !cfg.Enabled(configv1alpha1.CertManager) {
+			annotations = nil
+		}
+		if spec.IngressClassName == nil && cfg.Ingress.DefaultClass != "" {
+			class := cfg.Ingress.DefaultClass
+			spec.IngressClassName = &class
+		}
+		ingressSpecs[ingressLB.Name] = spec
+		objects.Ingresses = append(objects.Ingresses, &networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        ingressLB.Name,
+				Namespace:   microService.Namespace,
+				Labels:      microServiceLabels(cfg, microService),
+				Annotations: annotations,
+			},
+			Spec: *spec,
+		})
+	}
+
+	// versionServices maps, for each version, the Services of the LoadBalance
+	// to the Services of the version
+	versionServices := make(map[string]map[string]string)
+	for i := range microService.Spec.Versions {
+		version := &microService.Spec.Versions[i]
+		serviceNames := make(map[string]string)
+		for _, svcLB := range services {
+			spec := svcLB.Spec.DeepCopy()
+			spec.Selector = version.PodLabels()
+			serviceName := cfg.Name(svcLB.Name, version.Name)
+			if svcLB == lb.Service {
+				serviceName = version.ServiceName
+				if serviceName == "" {
+					serviceName = cfg.Name(microService.Name, version.Name)
+				}
+				version.ServiceName = serviceName
+			}
+			objects.Services = append(objects.Services, makeService(serviceName, microService.Namespace, versionLabels(cfg, version, microService), spec))
+			serviceNames[svcLB.Name] = serviceName
+		}
+		versionServices[version.Name] = serviceNames
+	}
+
+	for i := range microService.Spec.Versions {
+		version := &microService.Spec.Versions[i]
+		if version.Canary == nil {
+			continue
+		}
+		for _, ingressLB := range ingresses {
+			name := cfg.CanaryName(cfg.Name(ingressLB.Name, version.Name))
+			if ingressLB == lb.Ingress {
+				if version.Canary.CanaryIngressName == "" {
+					version.Canary.CanaryIngressName = cfg.CanaryName(cfg.Name(microService.Name, version.Name))
+				}
+				name = version.Canary.CanaryIngressName
+			}
+			// Ingresses referencing no Service of the LoadBalance get no canary
+			if ingress := makeCanaryIngress(cfg, microService, name, ingressSpecs[ingressLB.Name], version, versionServices[version.Name]); ingress != nil {
+				objects.Ingresses = append(objects.Ingresses, ingress)
+			}
+		}
+	}
+}
+
+// makeService renders a Service.
+func makeService(name string, namespace string, labels map[string]string, svcSpec *corev1.ServiceSpec) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: *svcSpec.DeepCopy(),
+	}
+}
+
+// LoadBalanceServices returns all the Services of lb, Service before Services.
+func LoadBalanceServices(lb *appv1.LoadBalance) []*appv1.ServiceLoadBalance {
+	var services []*appv1.ServiceLoadBalance
+	if lb.Service != nil {
+		services = append(services, lb.Service)
+	}
+	for i := range lb.Services {
+		services = append(services, &lb.Services[i])
+	}
+	return services
+}
+
+// LoadBalanceIngresses returns all the Ingresses of lb, Ingress before Ingresses.
+func LoadBalanceIngresses(lb *appv1.LoadBalance) []*appv1.IngressLoadBalance {
+	var ingresses []*appv1.IngressLoadBalance
+	if lb.Ingress != nil {
+		ingresses = append(ingresses, lb.Ingress)
+	}
+	for i := range lb.Ingresses {
+		ingresses = append(ingresses, &lb.Ingresses[i])
+	}
+	return ingresses
+}
+
+// IngressTLSSecretName returns the name of the Secret holding the certificate
+// of an Ingress, <Ingress>-tls by default.
+func IngressTLSSecretName(ingressLB *appv1.IngressLoadBalance) string {
+	if ingressLB.TLS.SecretName != "" {
+		return ingressLB.TLS.SecretName
+	}
+	return ingressLB.Name + "-tls"
+}
+
+// makeIngressTLS returns the IngressSpec of ingressLB with its TLS settings,
+// and the cert-manager annotations of the Ingress. The certificate covers the
+// hosts of the rules unless the TLS settings list them.
+func makeIngressTLS(ingressLB *appv1.IngressLoadBalance) (*networkingv1.IngressSpec, map[string]string) {
+	spec := ingressLB.Spec.DeepCopy()
+	if ingressLB.TLS == nil {
+		return spec, nil
+	}
+
+	annotations := make(map[string]string)
+	if ingressLB.TLS.Issuer != "" {
+		annotations[CertManagerIssuerAnnotation] = ingressLB.TLS.Issuer
+	} else if ingressLB.TLS.ClusterIssuer != "" {
+		annotations[CertManagerClusterIssuerAnnotation] = ingressLB.TLS.ClusterIssuer
+	}
+
+	secretName := IngressTLSSecretName(ingressLB)
+	for _, tls := range spec.TLS {
+		if tls.SecretName == secretName {
+			return spec, annotations
+		}
+	}
+
+	hosts := ingressLB.TLS.Hosts
+	if len(hosts) == 0 {
+		for _, rule := range spec.Rules {
+			if rule.Host != "" && !containsString(hosts, rule.Host) {
+				hosts = append(hosts, rule.Host)
+			}
+		}
+	}
+	spec.TLS = append(spec.TLS, networkingv1.IngressTLS{Hosts: hosts, SecretName: secretName})
+	return spec, annotations
+}
+
+// makeCanaryIngress renders the canary Ingress of version named name. The
+// backends pointing to a Service of the LoadBalance are rewritten to the
+// version's own Service according to serviceNames. It returns nil when no
+// backend of ingressSpec points to a Service of the LoadBalance.
+func makeCanaryIngress(cfg *configv1alpha1.ManagerConfig, microService *appv1.MicroService, name string, ingressSpec *networkingv1.IngressSpec, version *appv1.DeployVersion, serviceNames map[string]string) *networkingv1.Ingress {
+	// TODO nginx ingress controller support ONLY now
+	canary := version.Canary
+	annotations := map[string]string{
+		cfg.NginxAnnotation("canary"):        "true",
+		cfg.NginxAnnotation("canary-weight"): strconv.Itoa(canary.Weight),
+	}
+
+	if canary.Header != "" {
+		annotations[cfg.NginxAnnotation("canary-by-header")] = canary.Header
+		annotations[cfg.NginxAnnotation("canary-by-header-value")] = canary.HeaderValue
+	}
+
+	if canary.Cookie != "" {
+		annotations[cfg.NginxAnnotation("canary-by-cookie")] = canary.Cookie
+	}
+
+	ingressSpec = ingressSpec.DeepCopy()
+
+	referenced := false
+	rewrite := func(backend *networkingv1.IngressBackend) {
+		if backend == nil || backend.Service == nil {
+			return
+		}
+		if serviceName, ok := serviceNames[backend.Service.Name]; ok {
+			backend.Service.Name = serviceName
+			referenced = true
+		}
+	}
+	rewrite(ingressSpec.DefaultBackend)
+	for i := range ingressSpec.Rules {
+		http := ingressSpec.Rules[i].IngressRuleValue.HTTP
+		if http == nil {
+			continue
+		}
+		for j := range http.Paths {
+			rewrite(&http.Paths[j].Backend)
+		}
+	}
+	if !referenced {
+		return nil
+	}
+
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   microService.Namespace,
+			Labels:      microServiceLabels(cfg, microService),
+			Annotations: annotations,
+		},
+		Spec: *ingressSpec,
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"testing"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	networkingv1 "canary-crd/pkg/networking/v1"
+
+	"github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLoadBalanceServicesAndIngresses(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	lb := &appv1.LoadBalance{
+		Service:   &appv1.ServiceLoadBalance{Name: "voting"},
+		Services:  []appv1.ServiceLoadBalance{{Name: "voting-grpc"}},
+		Ingresses: []appv1.IngressLoadBalance{{Name: "voting-internal"}, {Name: "voting-public"}},
+	}
+
+	services := LoadBalanceServices(lb)
+	g.Expect(services).To(gomega.HaveLen(2))
+	g.Expect(services[0]).To(gomega.BeIdenticalTo(lb.Service))
+	g.Expect(services[1].Name).To(gomega.Equal("voting-grpc"))
+
+	ingresses := LoadBalanceIngresses(lb)
+	g.Expect(ingresses).To(gomega.HaveLen(2))
+	g.Expect(ingresses[1]).To(gomega.BeIdenticalTo(&lb.Ingresses[1]))
+}
+
+func TestMakeCanaryIngress(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	ms := &appv1.MicroService{ObjectMeta: metav1.ObjectMeta{Name: "voting", Namespace: "default"}}
+	version := &appv1.DeployVersion{Name: "v2", Canary: &appv1.Canary{Weight: 20, Header: "canary", HeaderValue: "always"}}
+	backend := func(name string) networkingv1.IngressBackend {
+		return networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: name, Port: networkingv1.ServiceBackendPort{Number: 80}}}
+	}
+	spec := &networkingv1.IngressSpec{
+		Rules: []networkingv1.IngressRule{{
+			Host: "voting.example.com",
+			IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{Paths: []networkingv1.HTTPIngressPath{
+				{Path: "/", Backend: backend("voting")},
+				{Path: "/grpc", Backend: backend("voting-grpc")},
+				{Path: "/static", Backend: backend("static")},
+			}}},
+		}},
+	}
+	serviceNames := map[string]string{"voting": "voting-v2", "voting-grpc": "voting-grpc-v2"}
+
+	ingress := makeCanaryIngress(configv1alpha1.Default(), ms, "voting-public-v2-canary", spec, version, serviceNames)
+	g.Expect(ingress.Name).To(gomega.Equal("voting-public-v2-canary"))
+	g.Expect(ingress.Annotations).To(gomega.HaveKeyWithValue("nginx.ingress.kubernetes.io/canary-weight", "20"))
+	g.Expect(ingress.Annotations).To(gomega.HaveKeyWithValue("nginx.ingress.kubernetes.io/canary-by-header", "canary"))
+	paths := ingress.Spec.Rules[0].IngressRuleValue.HTTP.Paths
+	g.Expect(paths[0].Backend.Service.Name).To(gomega.Equal("voting-v2"))
+	g.Expect(paths[1].Backend.Service.Name).To(gomega.Equal("voting-grpc-v2"))
+	g.Expect(paths[2].Backend.Service.Name).To(gomega.Equal("static"))
+	// the spec of the managed Ingress is left untouched
+	g.Expect(spec.Rules[0].IngressRuleValue.HTTP.Paths[0].Backend.Service.Name).To(gomega.Equal("voting"))
+
+	unrelated := &networkingv1.IngressSpec{DefaultBackend: &networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: "static"}}}
+	ingress = makeCanaryIngress(configv1alpha1.Default(), ms, "static-v2-canary", unrelated, version, serviceNames)
+	g.Expect(ingress).To(gomega.BeNil())
+
+	// the annotation prefix and the label domain come from the manager configuration
+	cfg := configv1alpha1.Default()
+	cfg.LabelDomain = "example.com"
+	cfg.Ingress.NginxAnnotationPrefix = "nginx.example.com"
+	ingress = makeCanaryIngress(cfg, ms, "voting-public-v2-canary", spec, version, serviceNames)
+	g.Expect(ingress.Annotations).To(gomega.HaveKeyWithValue("nginx.example.com/canary-weight", "20"))
+	g.Expect(ingress.Labels).To(gomega.Equal(map[string]string{"example.com/service": "voting"}))
+}
+
+func TestMakeIngressTLS(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	backend := networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: "voting", Port: networkingv1.ServiceBackendPort{Number: 80}}}
+	ingressLB := &appv1.IngressLoadBalance{
+		Name: "voting-public",
+		Spec: networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{
+			{Host: "voting.example.com", IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{Paths: []networkingv1.HTTPIngressPath{{Path: "/", Backend: backend}}}}},
+			{Host: "www.voting.example.com", IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{Paths: []networkingv1.HTTPIngressPath{{Path: "/", Backend: backend}}}}},
+		}},
+		TLS: &appv1.IngressTLS{ClusterIssuer: "letsencrypt"},
+	}
+
+	spec, annotations := makeIngressTLS(ingressLB)
+	g.Expect(annotations).To(gomega.Equal(map[string]string{CertManagerClusterIssuerAnnotation: "letsencrypt"}))
+	g.Expect(spec.TLS).To(gomega.Equal([]networkingv1.IngressTLS{{Hosts: []string{"voting.example.com", "www.voting.example.com"}, SecretName: "voting-public-tls"}}))
+	g.Expect(ingressLB.Spec.TLS).To(gomega.BeEmpty())
+
+	// the canary Ingress reuses the secret of the primary without requesting a certificate
+	version := &appv1.DeployVersion{Name: "v2", Canary: &appv1.Canary{Weight: 10}}
+	ms := &appv1.MicroService{ObjectMeta: metav1.ObjectMeta{Name: "voting", Namespace: "default"}}
+	canary := makeCanaryIngress(configv1alpha1.Default(), ms, "voting-public-v2-canary", spec, version, map[string]string{"voting": "voting-v2"})
+	g.Expect(canary.Spec.TLS).To(gomega.Equal(spec.TLS))
+	g.Expect(canary.Annotations).NotTo(gomega.HaveKey(CertManagerClusterIssuerAnnotation))
+
+	ingressLB.TLS = nil
+	spec, annotations = makeIngressTLS(ingressLB)
+	g.Expect(annotations).To(gomega.BeNil())
+	g.Expect(spec.TLS).To(gomega.BeEmpty())
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+	"regexp"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// The kinds of prometheus-operator. They are rendered as unstructured objects,
+// so that the controller does not depend on the prometheus-operator types.
+var (
+	ServiceMonitorGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "ServiceMonitor"}
+	PrometheusRuleGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "PrometheusRule"}
+)
+
+const (
+	defaultRequestsMetric = "http_requests_total"
+	defaultErrorSelector  = `code=~"5.."`
+)
+
+// invalidPrometheusLabelChars matches the characters not allowed in a Prometheus label name
+var invalidPrometheusLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// makeServiceMonitor renders the ServiceMonitor selecting the Services of every
+// version of microService. The version and service labels of the Services are
+// relabeled to version and microservice, so every series carries its version.
+func makeServiceMonitor(cfg *configv1alpha1.ManagerConfig, microService *appv1.MicroService) *unstructured.Unstructured {
+	metrics := microService.Spec.Metrics
+
+	endpoint := map[string]interface{}{
+		"port": metrics.Port,
+		"relabelings": []interface{}{
+			map[string]interface{}{
+				"sourceLabels": []interface{}{serviceLabelMeta(cfg.VersionLabel())},
+				"targetLabel":  "version",
+			},
+			map[string]interface{}{
+				"sourceLabels": []interface{}{serviceLabelMeta(cfg.ServiceLabel())},
+				"targetLabel":  "microservice",
+			},
+		},
+	}
+	if metrics.Path != "" {
+		endpoint["path"] = metrics.Path
+	}
+	if metrics.Interval != "" {
+		endpoint["interval"] = metrics.Interval
+	}
+
+	obj := newMonitoringObject(cfg, microService, ServiceMonitorGVK)
+	obj.Object["spec"] = map[string]interface{}{
+		"selector": map[string]interface{}{
+			"matchLabels": map[string]interface{}{cfg.ServiceLabel(): microService.Name},
+			"matchExpressions": []interface{}{
+				map[string]interface{}{"key": cfg.VersionLabel(), "operator": "Exists"},
+			},
+		},
+		"namespaceSelector": map[string]interface{}{
+			"matchNames": []interface{}{microService.Namespace},
+		},
+		"endpoints": []interface{}{endpoint},
+	}
+	return obj
+}
+
+// makePrometheusRule renders the PrometheusRule recording the request rate,
+// error rate and error ratio of each version of microService.
+func makePrometheusRule(cfg *configv1alpha1.ManagerConfig, microService *appv1.MicroService) *unstructured.Unstructured {
+	rules := microService.Spec.Metrics.Rules
+	metric := rules.RequestsMetric
+	if metric == "" {
+		metric = defaultRequestsMetric
+	}
+	errorSelector := rules.ErrorSelector
+	if errorSelector == "" {
+		errorSelector = defaultErrorSelector
+	}
+
+	selector := fmt.Sprintf(`namespace=%q,microservice=%q`, microService.Namespace, microService.Name)
+	requests := fmt.Sprintf(`sum by (microservice, version) (rate(%s{%s}[5m]))`, metric, selector)
+	failures := fmt.Sprintf(`sum by (microservice, version) (rate(%s{%s,%s}[5m]))`, metric, selector, errorSelector)
+
+	obj := newMonitoringObject(cfg, microService, PrometheusRuleGVK)
+	obj.Object["spec"] = map[string]interface{}{
+		"groups": []interface{}{
+			map[string]interface{}{
+				"name": microService.Namespace + "." + microService.Name + ".versions",
+				"rules": []interface{}{
+					map[string]interface{}{"record": "microservice_version:requests:rate5m", "expr": requests},
+					map[string]interface{}{"record": "microservice_version:errors:rate5m", "expr": failures},
+					map[string]interface{}{"record": "microservice_version:error_ratio:rate5m", "expr": failures + " / " + requests},
+				},
+			},
+		},
+	}
+	return obj
+}
+
+func newMonitoringObject(cfg *configv1alpha1.ManagerConfig, microService *appv1.MicroService, gvk schema.GroupVersionKind) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetName(microService.Name)
+	obj.SetNamespace(microService.Namespace)
+	obj.SetLabels(microServiceLabels(cfg, microService))
+	return obj
+}
+
+// serviceLabelMeta returns the meta label of a Service label in the Prometheus
+// service discovery.
+func serviceLabelMeta(label string) string {
+	return "__meta_kubernetes_service_label_" + invalidPrometheusLabelChars.ReplaceAllString(label, "_")
+}
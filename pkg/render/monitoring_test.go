@@ -0,0 +1,63 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"testing"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	"github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestMakeMonitoringObjects(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	ms := &appv1.MicroService{
+		ObjectMeta: metav1.ObjectMeta{Name: "voting-web", Namespace: "default"},
+		Spec: appv1.MicroServiceSpec{
+			Metrics: &appv1.Metrics{Port: "metrics", Path: "/metrics", Rules: &appv1.MetricsRules{}},
+		},
+	}
+
+	sm := makeServiceMonitor(configv1alpha1.Default(), ms)
+	g.Expect(sm.GetKind()).To(gomega.Equal("ServiceMonitor"))
+	g.Expect(sm.GetAPIVersion()).To(gomega.Equal("monitoring.coreos.com/v1"))
+	g.Expect(sm.GetLabels()).To(gomega.HaveKeyWithValue("app.o0w0o.cn/service", "voting-web"))
+	selector, _, _ := unstructured.NestedMap(sm.Object, "spec", "selector", "matchLabels")
+	g.Expect(selector).To(gomega.Equal(map[string]interface{}{"app.o0w0o.cn/service": "voting-web"}))
+	endpoints, _, _ := unstructured.NestedSlice(sm.Object, "spec", "endpoints")
+	g.Expect(endpoints).To(gomega.HaveLen(1))
+	endpoint := endpoints[0].(map[string]interface{})
+	g.Expect(endpoint["port"]).To(gomega.Equal("metrics"))
+	g.Expect(endpoint["path"]).To(gomega.Equal("/metrics"))
+	g.Expect(endpoint["relabelings"]).To(gomega.ContainElement(map[string]interface{}{
+		"sourceLabels": []interface{}{"__meta_kubernetes_service_label_app_o0w0o_cn_version"},
+		"targetLabel":  "version",
+	}))
+
+	rule := makePrometheusRule(configv1alpha1.Default(), ms)
+	groups, _, _ := unstructured.NestedSlice(rule.Object, "spec", "groups")
+	g.Expect(groups).To(gomega.HaveLen(1))
+	rules := groups[0].(map[string]interface{})["rules"].([]interface{})
+	g.Expect(rules).To(gomega.HaveLen(3))
+	g.Expect(rules[1].(map[string]interface{})["expr"]).To(gomega.Equal(
+		`sum by (microservice, version) (rate(http_requests_total{namespace="default",microservice="voting-web",code=~"5.."}[5m]))`))
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	k8snetworkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// makeNetworkPolicy renders the NetworkPolicy of microService. It is named
+// after the MicroService, selects the pods of all its versions and allows the
+// traffic from the MicroServices, namespaces and Ingress controller listed in
+// MicroServiceSpec.NetworkPolicy.
+func makeNetworkPolicy(cfg *configv1alpha1.ManagerConfig, microService *appv1.MicroService) *k8snetworkingv1.NetworkPolicy {
+	spec := microService.Spec.NetworkPolicy
+
+	var peers []k8snetworkingv1.NetworkPolicyPeer
+	for _, name := range NetworkPolicyPeers(cfg, microService) {
+		peers = append(peers, k8snetworkingv1.NetworkPolicyPeer{
+			PodSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{cfg.ServiceLabel(): name},
+			},
+		})
+	}
+	if spec.FromNamespaces != nil {
+		peers = append(peers, k8snetworkingv1.NetworkPolicyPeer{NamespaceSelector: spec.FromNamespaces.DeepCopy()})
+	}
+	if ingress := spec.FromIngressController; ingress != nil {
+		peer := k8snetworkingv1.NetworkPolicyPeer{
+			NamespaceSelector: ingress.NamespaceSelector.DeepCopy(),
+			PodSelector:       ingress.PodSelector.DeepCopy(),
+		}
+		// An empty peer selects the pods of the namespace of the MicroService,
+		// an empty namespace selector would admit the pods of every namespace
+		if peer.NamespaceSelector == nil && peer.PodSelector == nil {
+			peer.PodSelector = &metav1.LabelSelector{}
+		}
+		peers = append(peers, peer)
+	}
+
+	var rules []k8snetworkingv1.NetworkPolicyIngressRule
+	if len(peers) > 0 {
+		rules = append(rules, k8snetworkingv1.NetworkPolicyIngressRule{
+			From:  peers,
+			Ports: spec.Ports,
+		})
+	}
+
+	return &k8snetworkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      microService.Name,
+			Namespace: microService.Namespace,
+			Labels:    microServiceLabels(cfg, microService),
+		},
+		Spec: k8snetworkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{cfg.ServiceLabel(): microService.Name},
+			},
+			Ingress:     rules,
+			PolicyTypes: []k8snetworkingv1.PolicyType{k8snetworkingv1.PolicyTypeIngress},
+		},
+	}
+}
+
+// NetworkPolicyPeers returns the names of the MicroService objects whose traffic
+// the NetworkPolicy of microService allows.
+func NetworkPolicyPeers(cfg *configv1alpha1.ManagerConfig, microService *appv1.MicroService) []string {
+	if microService.Spec.NetworkPolicy == nil {
+		return nil
+	}
+	var peers []string
+	for _, name := range microService.Spec.NetworkPolicy.FromMicroServices {
+		peers = append(peers, peerMicroServiceName(cfg, microService, name))
+	}
+	return peers
+}
+
+// SelectedByNetworkPolicy reports whether NetworkPolicies select the pods of
+// microService by their labels: its own NetworkPolicy, the default deny policy of
+// its App, or the NetworkPolicy of one of microServices allowing its traffic.
+// app is nil when microService does not belong to an App.
+func SelectedByNetworkPolicy(cfg *configv1alpha1.ManagerConfig, microService *appv1.MicroService, app *appv1.App, microServices []*appv1.MicroService) bool {
+	if !cfg.Enabled(configv1alpha1.NetworkPolicy) {
+		return false
+	}
+	if microService.Spec.NetworkPolicy != nil || app != nil && app.Spec.DefaultDeny {
+		return true
+	}
+	for _, other := range microServices {
+		if other.Namespace != microService.Namespace {
+			continue
+		}
+		for _, peer := range NetworkPolicyPeers(cfg, other) {
+			if peer == microService.Name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// peerMicroServiceName returns the name of the MicroService object called name
+// in the App of microService. The MicroServices of an App are named
+// <App>-<MicroService>; outside of an App, name is used as is.
+func peerMicroServiceName(cfg *configv1alpha1.ManagerConfig, microService *appv1.MicroService, name string) string {
+	if app, ok := microService.Labels[cfg.AppLabel()]; ok && app != "" {
+		return cfg.Name(app, name)
+	}
+	return name
+}
+
+// DefaultDenyName returns the name of the default deny NetworkPolicy of app.
+func DefaultDenyName(cfg *configv1alpha1.ManagerConfig, app *appv1.App) string {
+	return cfg.Name(app.Name, "default-deny")
+}
+
+// makeDefaultDenyPolicy renders the NetworkPolicy selecting all the pods of app
+// and allowing no ingress traffic.
+func makeDefaultDenyPolicy(cfg *configv1alpha1.ManagerConfig, app *appv1.App) *k8snetworkingv1.NetworkPolicy {
+	return &k8snetworkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DefaultDenyName(cfg, app),
+			Namespace: app.Namespace,
+			Labels:    map[string]string{cfg.AppLabel(): app.Name},
+		},
+		Spec: k8snetworkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{cfg.AppLabel(): app.Name},
+			},
+			PolicyTypes: []k8snetworkingv1.PolicyType{k8snetworkingv1.PolicyTypeIngress},
+		},
+	}
+}
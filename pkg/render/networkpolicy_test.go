@@ -0,0 +1,138 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"testing"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	"github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMakeNetworkPolicy(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	ms := &appv1.MicroService{
+		ObjectMeta: metav1.ObjectMeta{Name: "voting-result", Namespace: "default", Labels: map[string]string{"app.o0w0o.cn/app": "voting"}},
+		Spec: appv1.MicroServiceSpec{
+			NetworkPolicy: &appv1.NetworkPolicy{
+				FromMicroServices:     []string{"web"},
+				FromIngressController: &appv1.NetworkPolicyPeer{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"name": "ingress-nginx"}}},
+			},
+			Versions: []appv1.DeployVersion{{Name: "v1", Template: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"version": "v1"}},
+				Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"version": "v1"}}},
+			}}},
+		},
+	}
+
+	policy := makeNetworkPolicy(configv1alpha1.Default(), ms)
+	g.Expect(policy.Name).To(gomega.Equal("voting-result"))
+	g.Expect(policy.Spec.PodSelector.MatchLabels).To(gomega.Equal(map[string]string{"app.o0w0o.cn/service": "voting-result"}))
+	g.Expect(policy.Spec.Ingress).To(gomega.HaveLen(1))
+	from := policy.Spec.Ingress[0].From
+	g.Expect(from).To(gomega.HaveLen(2))
+	g.Expect(from[0].PodSelector.MatchLabels).To(gomega.Equal(map[string]string{"app.o0w0o.cn/service": "voting-web"}))
+	g.Expect(from[1].NamespaceSelector.MatchLabels).To(gomega.Equal(map[string]string{"name": "ingress-nginx"}))
+
+	// An empty Ingress controller peer only admits the pods of the namespace
+	ms.Spec.NetworkPolicy = &appv1.NetworkPolicy{FromIngressController: &appv1.NetworkPolicyPeer{}}
+	from = makeNetworkPolicy(configv1alpha1.Default(), ms).Spec.Ingress[0].From
+	g.Expect(from).To(gomega.HaveLen(1))
+	g.Expect(from[0].NamespaceSelector).To(gomega.BeNil())
+	g.Expect(from[0].PodSelector).To(gomega.Equal(&metav1.LabelSelector{}))
+
+	// No caller declared denies all traffic
+	ms.Spec.NetworkPolicy = &appv1.NetworkPolicy{}
+	g.Expect(makeNetworkPolicy(configv1alpha1.Default(), ms).Spec.Ingress).To(gomega.BeEmpty())
+
+	// The pods carry the labels the policies select
+	objects, err := MicroService(configv1alpha1.Default(), ms, nil)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(objects.Versions[0].Workload.(*appsv1.Deployment).Spec.Template.Labels).To(gomega.Equal(map[string]string{
+		"version":              "v1",
+		"app.o0w0o.cn/service": "voting-result",
+		"app.o0w0o.cn/app":     "voting",
+	}))
+}
+
+func TestSelectedByNetworkPolicy(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	cfg := configv1alpha1.Default()
+	labels := map[string]string{"app.o0w0o.cn/app": "voting"}
+	result := &appv1.MicroService{
+		ObjectMeta: metav1.ObjectMeta{Name: "voting-result", Namespace: "default", Labels: labels},
+		Spec:       appv1.MicroServiceSpec{NetworkPolicy: &appv1.NetworkPolicy{FromMicroServices: []string{"web"}}},
+	}
+	web := &appv1.MicroService{ObjectMeta: metav1.ObjectMeta{Name: "voting-web", Namespace: "default", Labels: labels}}
+	worker := &appv1.MicroService{ObjectMeta: metav1.ObjectMeta{Name: "voting-worker", Namespace: "default", Labels: labels}}
+	app := &appv1.App{ObjectMeta: metav1.ObjectMeta{Name: "voting", Namespace: "default"}}
+	microServices := []*appv1.MicroService{result, web, worker}
+
+	g.Expect(NetworkPolicyPeers(cfg, result)).To(gomega.Equal([]string{"voting-web"}))
+	g.Expect(NetworkPolicyPeers(cfg, web)).To(gomega.BeEmpty())
+
+	// Its own policy, or the policy of a MicroService it may call
+	g.Expect(SelectedByNetworkPolicy(cfg, result, app, microServices)).To(gomega.BeTrue())
+	g.Expect(SelectedByNetworkPolicy(cfg, web, app, microServices)).To(gomega.BeTrue())
+	g.Expect(SelectedByNetworkPolicy(cfg, worker, app, microServices)).To(gomega.BeFalse())
+
+	// The policies of other namespaces do not select the pods
+	other := result.DeepCopy()
+	other.Namespace = "prod"
+	g.Expect(SelectedByNetworkPolicy(cfg, web, app, []*appv1.MicroService{other})).To(gomega.BeFalse())
+
+	// The default deny policy of the App selects all its pods
+	app.Spec.DefaultDeny = true
+	g.Expect(SelectedByNetworkPolicy(cfg, worker, app, microServices)).To(gomega.BeTrue())
+
+	cfg.FeatureGates = map[configv1alpha1.Feature]bool{configv1alpha1.NetworkPolicy: false}
+	g.Expect(SelectedByNetworkPolicy(cfg, result, app, microServices)).To(gomega.BeFalse())
+}
+
+func TestPodTemplateLabels(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	ms := &appv1.MicroService{
+		ObjectMeta: metav1.ObjectMeta{Name: "voting-worker", Namespace: "default", Labels: map[string]string{"app.o0w0o.cn/app": "voting"}},
+		Spec: appv1.MicroServiceSpec{Versions: []appv1.DeployVersion{{Name: "v1", Template: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"version": "v1"}},
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"version": "v1"}}},
+		}}}},
+	}
+	templateLabels := func(inputs *Inputs) map[string]string {
+		objects, err := MicroService(configv1alpha1.Default(), ms, inputs)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		return objects.Versions[0].Workload.(*appsv1.Deployment).Spec.Template.Labels
+	}
+
+	// Pod templates no policy selects are left as they are, so that upgrading
+	// the manager does not restart the pods
+	g.Expect(templateLabels(nil)).To(gomega.Equal(map[string]string{"version": "v1"}))
+
+	g.Expect(templateLabels(&Inputs{SelectedByNetworkPolicy: true})).To(gomega.Equal(map[string]string{
+		"version":              "v1",
+		"app.o0w0o.cn/service": "voting-worker",
+		"app.o0w0o.cn/app":     "voting",
+	}))
+}
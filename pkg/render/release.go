@@ -0,0 +1,117 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+	"time"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ReleaseWaitInterval is how often a release waiting for its participants is
+// checked again.
+const ReleaseWaitInterval = 10 * time.Second
+
+// ParticipantStatus is the state of the workload of the target version of a
+// release participant.
+type ParticipantStatus struct {
+	// Name is the name of the workload.
+	Name string
+	Kind appv1.WorkloadKind
+	// Created is false until the workload exists.
+	Created bool
+	Ready   bool
+	// ProgressDeadlineExceeded is set once a Deployment stopped progressing.
+	ProgressDeadlineExceeded bool
+}
+
+// AdvanceRelease moves the release status of app on at now, given the state of
+// the participants of its release in the order of the release. The release
+// moves to the next step once every participant is ready and the pause of the
+// step has passed. It is rolled back when a Deployment exceeds its progress
+// deadline, or a StatefulSet is not ready statefulSetDeadline after the step
+// started. Steps shortened below the current step leave the release on the
+// last step. AdvanceRelease returns whether the status changed and when the
+// release should be checked again, 0 when it waits for no time.
+func AdvanceRelease(app *appv1.App, participants []ParticipantStatus, statefulSetDeadline time.Duration, now time.Time) (bool, time.Duration) {
+	release := app.Spec.Release
+	if release == nil {
+		if app.Status.Release == nil {
+			return false, 0
+		}
+		app.Status.Release = nil
+		return true, 0
+	}
+
+	status := app.Status.Release
+	if status == nil || status.Name != release.Name {
+		app.Status.Release = &appv1.AppReleaseStatus{
+			Name:         release.Name,
+			Phase:        appv1.ReleaseProgressing,
+			LastStepTime: metav1.NewTime(now),
+		}
+		return true, ReleaseWaitInterval
+	}
+	if status.Phase != appv1.ReleaseProgressing {
+		return false, 0
+	}
+	if last := int32(len(release.Steps) - 1); status.CurrentStep > last {
+		status.CurrentStep = last
+		status.LastStepTime = metav1.NewTime(now)
+		return true, ReleaseWaitInterval
+	}
+	if release.Paused {
+		return false, 0
+	}
+
+	elapsed := now.Sub(status.LastStepTime.Time)
+	ready := true
+	for _, p := range participants {
+		if p.ProgressDeadlineExceeded {
+			status.Phase = appv1.ReleaseRolledBack
+			status.Message = fmt.Sprintf("Deployment %s exceeded its progress deadline.", p.Name)
+			return true, 0
+		}
+		if p.Kind == appv1.StatefulSetKind && p.Created && !p.Ready && elapsed > statefulSetDeadline {
+			status.Phase = appv1.ReleaseRolledBack
+			status.Message = fmt.Sprintf("StatefulSet %s was not ready within %s.", p.Name, statefulSetDeadline)
+			return true, 0
+		}
+		if !p.Ready {
+			ready = false
+		}
+	}
+	if !ready {
+		return false, ReleaseWaitInterval
+	}
+
+	step := release.Steps[status.CurrentStep]
+	if pause := time.Duration(step.PauseSeconds) * time.Second; elapsed < pause {
+		return false, pause - elapsed
+	}
+	if int(status.CurrentStep) >= len(release.Steps)-1 {
+		status.Phase = appv1.ReleaseSucceeded
+		status.Message = ""
+		return true, 0
+	}
+	status.CurrentStep++
+	status.LastStepTime = metav1.NewTime(now)
+	return true, ReleaseWaitInterval
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"testing"
+	"time"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+
+	"github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAdvanceRelease(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	app := &appv1.App{Spec: appv1.AppSpec{Release: &appv1.AppRelease{
+		Name:         "spring",
+		Participants: []appv1.ReleaseParticipant{{MicroService: "web", Version: "v2"}, {MicroService: "db", Version: "v2"}},
+		Steps:        []appv1.ReleaseStep{{Weight: 10, PauseSeconds: 60}, {Weight: 50}, {Weight: 100}},
+	}}}
+	deadline := 10 * time.Minute
+	now := time.Now()
+	web := ParticipantStatus{Name: "shop-web-v2", Kind: appv1.DeploymentKind}
+	db := ParticipantStatus{Name: "shop-db-v2", Kind: appv1.StatefulSetKind}
+	advance := func(participants ...ParticipantStatus) (bool, time.Duration) {
+		return AdvanceRelease(app, participants, deadline, now)
+	}
+
+	// the release starts at the first step
+	changed, requeue := advance(web, db)
+	g.Expect(changed).To(gomega.BeTrue())
+	g.Expect(requeue).To(gomega.Equal(ReleaseWaitInterval))
+	status := app.Status.Release
+	g.Expect(status.Phase).To(gomega.Equal(appv1.ReleaseProgressing))
+	g.Expect(status.CurrentStep).To(gomega.Equal(int32(0)))
+
+	// and waits for the participants to be created and ready
+	changed, requeue = advance(web, db)
+	g.Expect(changed).To(gomega.BeFalse())
+	g.Expect(requeue).To(gomega.Equal(ReleaseWaitInterval))
+	web.Created, web.Ready = true, true
+	db.Created = true
+	changed, _ = advance(web, db)
+	g.Expect(changed).To(gomega.BeFalse())
+
+	// then for the pause of the step
+	db.Ready = true
+	now = now.Add(20 * time.Second)
+	changed, requeue = advance(web, db)
+	g.Expect(changed).To(gomega.BeFalse())
+	g.Expect(requeue).To(gomega.Equal(40 * time.Second))
+
+	now = now.Add(time.Minute)
+	changed, _ = advance(web, db)
+	g.Expect(changed).To(gomega.BeTrue())
+	g.Expect(status.CurrentStep).To(gomega.Equal(int32(1)))
+	g.Expect(status.LastStepTime.Time).To(gomega.Equal(now))
+
+	// a paused release stays on its step
+	app.Spec.Release.Paused = true
+	changed, requeue = advance(web, db)
+	g.Expect(changed).To(gomega.BeFalse())
+	g.Expect(requeue).To(gomega.BeZero())
+	app.Spec.Release.Paused = false
+
+	// shortening the steps of the running release moves it to the last step
+	// instead of indexing past the end
+	status.CurrentStep = 2
+	app.Spec.Release.Steps = app.Spec.Release.Steps[:2]
+	changed, requeue = advance(web, db)
+	g.Expect(changed).To(gomega.BeTrue())
+	g.Expect(requeue).To(gomega.Equal(ReleaseWaitInterval))
+	g.Expect(status.CurrentStep).To(gomega.Equal(int32(1)))
+
+	// the last step completes the release
+	changed, requeue = advance(web, db)
+	g.Expect(changed).To(gomega.BeTrue())
+	g.Expect(requeue).To(gomega.BeZero())
+	g.Expect(status.Phase).To(gomega.Equal(appv1.ReleaseSucceeded))
+	changed, _ = advance(web, db)
+	g.Expect(changed).To(gomega.BeFalse())
+
+	// removing the release clears its status
+	app.Spec.Release = nil
+	changed, _ = advance()
+	g.Expect(changed).To(gomega.BeTrue())
+	g.Expect(app.Status.Release).To(gomega.BeNil())
+}
+
+func TestAdvanceReleaseRollback(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	deadline := 10 * time.Minute
+	now := time.Now()
+	newApp := func() *appv1.App {
+		return &appv1.App{
+			Spec: appv1.AppSpec{Release: &appv1.AppRelease{Name: "spring", Steps: []appv1.ReleaseStep{{Weight: 10}, {Weight: 100}}}},
+			Status: appv1.AppStatus{Release: &appv1.AppReleaseStatus{
+				Name:         "spring",
+				Phase:        appv1.ReleaseProgressing,
+				LastStepTime: metav1.NewTime(now.Add(-5 * time.Minute)),
+			}},
+		}
+	}
+
+	// a Deployment exceeding its progress deadline rolls the release back
+	app := newApp()
+	changed, _ := AdvanceRelease(app, []ParticipantStatus{
+		{Name: "shop-web-v2", Kind: appv1.DeploymentKind, Created: true, ProgressDeadlineExceeded: true},
+	}, deadline, now)
+	g.Expect(changed).To(gomega.BeTrue())
+	g.Expect(app.Status.Release.Phase).To(gomega.Equal(appv1.ReleaseRolledBack))
+	g.Expect(app.Status.Release.Message).To(gomega.Equal("Deployment shop-web-v2 exceeded its progress deadline."))
+
+	// a StatefulSet has no progress deadline, the release waits for it until
+	// the deadline of the step
+	db := ParticipantStatus{Name: "shop-db-v2", Kind: appv1.StatefulSetKind, Created: true}
+	app = newApp()
+	changed, requeue := AdvanceRelease(app, []ParticipantStatus{db}, deadline, now)
+	g.Expect(changed).To(gomega.BeFalse())
+	g.Expect(requeue).To(gomega.Equal(ReleaseWaitInterval))
+	changed, _ = AdvanceRelease(app, []ParticipantStatus{db}, deadline, now.Add(6*time.Minute))
+	g.Expect(changed).To(gomega.BeTrue())
+	g.Expect(app.Status.Release.Phase).To(gomega.Equal(appv1.ReleaseRolledBack))
+	g.Expect(app.Status.Release.Message).To(gomega.Equal("StatefulSet shop-db-v2 was not ready within 10m0s."))
+
+	// a rolled back release is left alone
+	changed, requeue = AdvanceRelease(app, []ParticipantStatus{db}, deadline, now)
+	g.Expect(changed).To(gomega.BeFalse())
+	g.Expect(requeue).To(gomega.BeZero())
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package render computes the desired state of Apps and MicroServices.
+//
+// Everything the controllers create in the cluster, the MicroServices of an
+// App and the workloads, config snapshots, Services, Ingresses, HPAs, PDBs,
+// NetworkPolicies and monitoring objects of a MicroService, is computed here
+// from the custom resource, the manager configuration and the content of the
+// objects it references. The functions of this package do not talk to the API
+// Server, so the reconcilers only diff and apply their result, and the same
+// output can be inspected offline or checked by golden files.
+package render
+
+import (
+	"fmt"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	networkingv1 "canary-crd/pkg/networking/v1"
+
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	corev1 "k8s.io/api/core/v1"
+	k8snetworkingv1 "k8s.io/api/networking/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Object is a rendered Kubernetes object.
+type Object interface {
+	metav1.Object
+	runtime.Object
+}
+
+// Inputs holds the cluster state a MicroService depends on: the ConfigMaps and
+// Secrets of its namespace referenced by its versions, keyed by name. Objects
+// that do not exist are left out. References returns the names to fill in.
+type Inputs struct {
+	ConfigMaps map[string]*corev1.ConfigMap
+	Secrets    map[string]*corev1.Secret
+
+	// SelectedByNetworkPolicy is set when NetworkPolicies select the pods of the
+	// MicroService by their labels, see SelectedByNetworkPolicy.
+	SelectedByNetworkPolicy bool
+}
+
+// MicroServiceObjects is the desired state of a MicroService.
+type MicroServiceObjects struct {
+	// Spec is the spec of the MicroService with the defaults the controller
+	// writes back, such as the Service name of each version and the name of
+	// the canary Ingress.
+	Spec appv1.MicroServiceSpec
+	// Versions holds the objects of each version, in the order of Spec.Versions.
+	Versions []VersionObjects
+	// Services holds the Services of the LoadBalance followed by the Services
+	// of each version.
+	Services []*corev1.Service
+	// Ingresses holds the Ingresses of the LoadBalance followed by the canary
+	// Ingresses of each version.
+	Ingresses []*networkingv1.Ingress
+	// NetworkPolicy is nil when the MicroService declares no network policy or
+	// the NetworkPolicy feature is disabled.
+	NetworkPolicy *k8snetworkingv1.NetworkPolicy
+	// ServiceMonitor and PrometheusRule are nil when the MicroService declares
+	// no metrics or rules, or the Monitoring feature is disabled.
+	ServiceMonitor *unstructured.Unstructured
+	PrometheusRule *unstructured.Unstructured
+}
+
+// VersionObjects is the desired state of a version of a MicroService.
+type VersionObjects struct {
+	Name string
+	Kind appv1.WorkloadKind
+	// Workload is the Deployment or StatefulSet of the version.
+	Workload Object
+	// Snapshots holds the ConfigMaps and Secrets of the config snapshots
+	// mounted into the pod template of Workload.
+	Snapshots []Object
+	// HPA is nil when autoscaling is disabled for the version.
+	HPA *autoscalingv2beta2.HorizontalPodAutoscaler
+	PDB *policyv1beta1.PodDisruptionBudget
+	// TemplateHash is the hash of the rendered pod template.
+	TemplateHash string
+}
+
+// Objects returns all the objects of o: the objects of each version, followed
+// by the Services, the Ingresses, the NetworkPolicy and the monitoring objects.
+func (o *MicroServiceObjects) Objects() []Object {
+	var objects []Object
+	for _, version := range o.Versions {
+		objects = append(objects, version.Workload)
+		objects = append(objects, version.Snapshots...)
+		if version.HPA != nil {
+			objects = append(objects, version.HPA)
+		}
+		objects = append(objects, version.PDB)
+	}
+	for _, svc := range o.Services {
+		objects = append(objects, svc)
+	}
+	for _, ingress := range o.Ingresses {
+		objects = append(objects, ingress)
+	}
+	if o.NetworkPolicy != nil {
+		objects = append(objects, o.NetworkPolicy)
+	}
+	if o.ServiceMonitor != nil {
+		objects = append(objects, o.ServiceMonitor)
+	}
+	if o.PrometheusRule != nil {
+		objects = append(objects, o.PrometheusRule)
+	}
+	return objects
+}
+
+// MicroService renders the desired state of microService. It neither modifies
+// microService nor the objects of inputs.
+func MicroService(cfg *configv1alpha1.ManagerConfig, microService *appv1.MicroService, inputs *Inputs) (*MicroServiceObjects, error) {
+	microService = microService.DeepCopy()
+	if inputs == nil {
+		inputs = &Inputs{}
+	}
+	objects := &MicroServiceObjects{}
+
+	for i := range microService.Spec.Versions {
+		version := &microService.Spec.Versions[i]
+		versionObjects, err := renderVersion(cfg, version, microService, inputs)
+		if err != nil {
+			return nil, err
+		}
+		objects.Versions = append(objects.Versions, *versionObjects)
+	}
+
+	renderLoadBalance(cfg, microService, objects)
+
+	if microService.Spec.NetworkPolicy != nil && cfg.Enabled(configv1alpha1.NetworkPolicy) {
+		objects.NetworkPolicy = makeNetworkPolicy(cfg, microService)
+	}
+	if metrics := microService.Spec.Metrics; metrics != nil && cfg.Enabled(configv1alpha1.Monitoring) {
+		objects.ServiceMonitor = makeServiceMonitor(cfg, microService)
+		if metrics.Rules != nil {
+			objects.PrometheusRule = makePrometheusRule(cfg, microService)
+		}
+	}
+
+	objects.Spec = microService.Spec
+	return objects, nil
+}
+
+// renderVersion renders the workload, config snapshots, HPA and PDB of version.
+func renderVersion(cfg *configv1alpha1.ManagerConfig, version *appv1.DeployVersion, microService *appv1.MicroService, inputs *Inputs) (*VersionObjects, error) {
+	configHash := versionConfigHash(version, microService, inputs)
+	obj, err := makeVersionWorkload(cfg, version, microService, configHash)
+	if err != nil {
+		return nil, fmt.Errorf("render workload of version %q: %v", version.Name, err)
+	}
+
+	objects := &VersionObjects{Name: version.Name, Kind: version.GetKind(), Workload: obj}
+	tpl := workloadPodTemplate(obj)
+	ownPolicy := microService.Spec.NetworkPolicy != nil && cfg.Enabled(configv1alpha1.NetworkPolicy)
+	if inputs.SelectedByNetworkPolicy || ownPolicy || len(version.Config) > 0 {
+		labelPodTemplate(cfg, tpl, microService)
+	}
+	snapshots := make([]versionSnapshot, 0, len(version.Config))
+	for i := range version.Config {
+		snapshot, err := makeConfigSnapshot(cfg, &version.Config[i], version, microService, inputs)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+		objects.Snapshots = append(objects.Snapshots, snapshot.object())
+	}
+	mountConfigSnapshots(tpl, snapshots)
+	objects.TemplateHash = TemplateHash(tpl)
+
+	if autoscaling := versionAutoscaling(version, microService); autoscaling != nil {
+		objects.HPA = makeVersionHPA(cfg, version, autoscaling, microService)
+	}
+	if objects.PDB, err = makeVersionPDB(cfg, version, microService); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// microServiceLabels returns the labels shared by the objects of a MicroService.
+// The controller finds the objects it owns by the service label.
+func microServiceLabels(cfg *configv1alpha1.ManagerConfig, microService *appv1.MicroService) map[string]string {
+	labels := make(map[string]string, len(microService.Labels)+1)
+	for k, v := range microService.Labels {
+		labels[k] = v
+	}
+	labels[cfg.ServiceLabel()] = microService.Name
+	return labels
+}
+
+// versionLabels returns the labels of the workload and the other per-version
+// objects of a MicroService.
+func versionLabels(cfg *configv1alpha1.ManagerConfig, version *appv1.DeployVersion, microService *appv1.MicroService) map[string]string {
+	labels := microServiceLabels(cfg, microService)
+	labels[cfg.VersionLabel()] = version.Name
+	return labels
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
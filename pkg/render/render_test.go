@@ -0,0 +1,121 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+	networkingv1 "canary-crd/pkg/networking/v1"
+
+	"github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestGolden renders the samples of config/samples and compares the objects
+// with testdata/<sample>.golden. Run the test with -update after an intended
+// change of the rendering.
+func TestGolden(t *testing.T) {
+	scheme := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{kubescheme.AddToScheme, appv1.AddToScheme, networkingv1.AddToScheme} {
+		if err := add(scheme); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	samples, err := filepath.Glob("../../config/samples/app_v1_*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range samples {
+		name := strings.TrimSuffix(filepath.Base(sample), ".yaml")
+		t.Run(name, func(t *testing.T) {
+			g := gomega.NewGomegaWithT(t)
+
+			data, err := ioutil.ReadFile(sample)
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			objects, err := renderSample(data)
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			var buf bytes.Buffer
+			g.Expect(Encode(&buf, scheme, objects)).To(gomega.Succeed())
+			got := buf.Bytes()
+
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				g.Expect(ioutil.WriteFile(golden, got, 0644)).To(gomega.Succeed())
+			}
+			want, err := ioutil.ReadFile(golden)
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			g.Expect(string(got)).To(gomega.Equal(string(want)))
+		})
+	}
+}
+
+// renderSample renders an App or a MicroService in the default namespace, with
+// the default manager configuration. The MicroServices of an App are rendered
+// as well.
+func renderSample(data []byte) ([]Object, error) {
+	cfg := configv1alpha1.Default()
+	meta := &metav1.TypeMeta{}
+	if err := yaml.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+
+	var microServices []*appv1.MicroService
+	var objects []Object
+	switch meta.Kind {
+	case "App":
+		app := &appv1.App{}
+		if err := yaml.UnmarshalStrict(data, app); err != nil {
+			return nil, err
+		}
+		app.Namespace = "default"
+		appObjects, err := App(cfg, app)
+		if err != nil {
+			return nil, err
+		}
+		objects = appObjects.Objects()
+		microServices = appObjects.MicroServices
+	default:
+		ms := &appv1.MicroService{}
+		if err := yaml.UnmarshalStrict(data, ms); err != nil {
+			return nil, err
+		}
+		ms.Namespace = "default"
+		microServices = append(microServices, ms)
+	}
+
+	for _, ms := range microServices {
+		msObjects, err := MicroService(cfg, ms, nil)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, msObjects.Objects()...)
+	}
+	return objects, nil
+}
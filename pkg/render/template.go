@@ -0,0 +1,144 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"sigs.k8s.io/yaml"
+)
+
+// PodTemplate renders the pod template of version.
+//
+// When the MicroService declares a BaseTemplate, it is the starting point and
+// the labels and annotations of the version's own pod template are merged into
+// it; otherwise the version's own pod template is used. The strategic merge
+// patch, the JSON patch and the image of the version's Overrides are applied
+// on top, in that order.
+func PodTemplate(version *appv1.DeployVersion, microService *appv1.MicroService) (*corev1.PodTemplateSpec, error) {
+	own := version.PodTemplate()
+
+	var tpl *corev1.PodTemplateSpec
+	if base := microService.Spec.BaseTemplate; base != nil {
+		tpl = base.DeepCopy()
+		if own != nil {
+			tpl.Labels = mergeStringMap(tpl.Labels, own.Labels)
+			tpl.Annotations = mergeStringMap(tpl.Annotations, own.Annotations)
+		}
+	} else if own != nil {
+		tpl = own.DeepCopy()
+	} else {
+		return nil, fmt.Errorf("version %q has no pod template", version.Name)
+	}
+
+	overrides := version.Overrides
+	if overrides == nil {
+		return tpl, nil
+	}
+
+	if overrides.StrategicMergePatch != "" || overrides.JSONPatch != "" {
+		data, err := json.Marshal(tpl)
+		if err != nil {
+			return nil, err
+		}
+		if overrides.StrategicMergePatch != "" {
+			patch, err := yaml.YAMLToJSON([]byte(overrides.StrategicMergePatch))
+			if err != nil {
+				return nil, fmt.Errorf("invalid strategicMergePatch of version %q: %v", version.Name, err)
+			}
+			if data, err = strategicpatch.StrategicMergePatch(data, patch, corev1.PodTemplateSpec{}); err != nil {
+				return nil, fmt.Errorf("apply strategicMergePatch of version %q: %v", version.Name, err)
+			}
+		}
+		if overrides.JSONPatch != "" {
+			raw, err := yaml.YAMLToJSON([]byte(overrides.JSONPatch))
+			if err != nil {
+				return nil, fmt.Errorf("invalid jsonPatch of version %q: %v", version.Name, err)
+			}
+			patch, err := jsonpatch.DecodePatch(raw)
+			if err != nil {
+				return nil, fmt.Errorf("invalid jsonPatch of version %q: %v", version.Name, err)
+			}
+			if data, err = patch.Apply(data); err != nil {
+				return nil, fmt.Errorf("apply jsonPatch of version %q: %v", version.Name, err)
+			}
+		}
+		tpl = &corev1.PodTemplateSpec{}
+		if err := json.Unmarshal(data, tpl); err != nil {
+			return nil, err
+		}
+	}
+
+	if overrides.Image != "" {
+		if err := overrideImage(tpl, overrides.Container, overrides.Image); err != nil {
+			return nil, fmt.Errorf("override image of version %q: %v", version.Name, err)
+		}
+	}
+	return tpl, nil
+}
+
+// overrideImage replaces the image of the container named container, or of the
+// first container when container is empty.
+func overrideImage(tpl *corev1.PodTemplateSpec, container, image string) error {
+	containers := tpl.Spec.Containers
+	if len(containers) == 0 {
+		return fmt.Errorf("pod template has no containers")
+	}
+	if container == "" {
+		containers[0].Image = image
+		return nil
+	}
+	for i := range containers {
+		if containers[i].Name == container {
+			containers[i].Image = image
+			return nil
+		}
+	}
+	return fmt.Errorf("container %q not found", container)
+}
+
+// TemplateHash returns the hash of a rendered pod template. The controller
+// records it in the status of the MicroService.
+func TemplateHash(tpl *corev1.PodTemplateSpec) string {
+	hasher := fnv.New32a()
+	data, _ := json.Marshal(tpl)
+	hasher.Write(data)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
+// mergeStringMap returns base merged with overlay; values of overlay win.
+func mergeStringMap(base, overlay map[string]string) map[string]string {
+	if len(overlay) == 0 {
+		return base
+	}
+	merged := make(map[string]string, len(base)+len(overlay))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range overlay {
+		merged[k] = v
+	}
+	return merged
+}
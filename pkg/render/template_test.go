@@ -0,0 +1,79 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"testing"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	"github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodTemplate(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	ms := &appv1.MicroService{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: appv1.MicroServiceSpec{
+			BaseTemplate: &corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"tier": "frontend"}},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{
+					{Name: "web", Image: "web:v1", Env: []corev1.EnvVar{{Name: "MODE", Value: "stable"}}},
+					{Name: "proxy", Image: "proxy:v1"},
+				}},
+			},
+		},
+	}
+	version := &appv1.DeployVersion{
+		Name: "v2",
+		Template: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"version": "v2"}}},
+		},
+		Overrides: &appv1.TemplateOverrides{
+			Image:               "web:v2",
+			StrategicMergePatch: "spec:\n  containers:\n  - name: web\n    env:\n    - name: MODE\n      value: canary\n",
+			JSONPatch:           `[{"op": "replace", "path": "/spec/containers/1/image", "value": "proxy:v2"}]`,
+		},
+	}
+
+	deploy, err := makeVersionDeployment(configv1alpha1.Default(), version, ms, "")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	tpl := deploy.Spec.Template
+	g.Expect(tpl.Labels).To(gomega.Equal(map[string]string{"tier": "frontend", "version": "v2"}))
+	g.Expect(tpl.Spec.Containers).To(gomega.HaveLen(2))
+	g.Expect(tpl.Spec.Containers[0].Image).To(gomega.Equal("web:v2"))
+	g.Expect(tpl.Spec.Containers[0].Env).To(gomega.Equal([]corev1.EnvVar{{Name: "MODE", Value: "canary"}}))
+	g.Expect(tpl.Spec.Containers[1].Image).To(gomega.Equal("proxy:v2"))
+	g.Expect(ms.Spec.BaseTemplate.Spec.Containers[0].Image).To(gomega.Equal("web:v1"))
+
+	// A change to the base template changes the hash of every version
+	hash := TemplateHash(&tpl)
+	ms.Spec.BaseTemplate.Spec.Containers[1].Name = "sidecar"
+	version.Overrides.JSONPatch = ""
+	deploy, err = makeVersionDeployment(configv1alpha1.Default(), version, ms, "")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(TemplateHash(&deploy.Spec.Template)).NotTo(gomega.Equal(hash))
+
+	version.Overrides = &appv1.TemplateOverrides{Container: "missing", Image: "web:v3"}
+	_, err = makeVersionDeployment(configv1alpha1.Default(), version, ms, "")
+	g.Expect(err).To(gomega.HaveOccurred())
+}
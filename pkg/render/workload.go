@@ -0,0 +1,213 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"fmt"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// makeVersionWorkload renders the Deployment or StatefulSet of version,
+// according to its kind. A non-empty configHash is recorded in the annotations
+// of the pod template.
+func makeVersionWorkload(cfg *configv1alpha1.ManagerConfig, version *appv1.DeployVersion, microService *appv1.MicroService, configHash string) (Object, error) {
+	switch version.GetKind() {
+	case appv1.DeploymentKind:
+		return makeVersionDeployment(cfg, version, microService, configHash)
+	case appv1.StatefulSetKind:
+		return makeVersionStatefulSet(cfg, version, microService, configHash)
+	default:
+		return nil, fmt.Errorf("unsupported workload kind %q", version.Kind)
+	}
+}
+
+// workloadPodTemplate returns the pod template of a Deployment or StatefulSet.
+func workloadPodTemplate(obj Object) *corev1.PodTemplateSpec {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		return &o.Spec.Template
+	case *appsv1.StatefulSet:
+		return &o.Spec.Template
+	}
+	return nil
+}
+
+// labelPodTemplate adds the service and app labels to tpl. They are only added
+// when something selects the pods by them: NetworkPolicies, or the lookup of the
+// ReplicaSets still mounting old config snapshots. Other pod templates are left
+// as they are, so that upgrading the manager does not restart their pods.
+func labelPodTemplate(cfg *configv1alpha1.ManagerConfig, tpl *corev1.PodTemplateSpec, microService *appv1.MicroService) {
+	if tpl.Labels == nil {
+		tpl.Labels = make(map[string]string)
+	}
+	tpl.Labels[cfg.ServiceLabel()] = microService.Name
+	if app, ok := microService.Labels[cfg.AppLabel()]; ok {
+		tpl.Labels[cfg.AppLabel()] = app
+	}
+}
+
+// makeVersionDeployment renders the Deployment of version.
+func makeVersionDeployment(cfg *configv1alpha1.ManagerConfig, version *appv1.DeployVersion, microService *appv1.MicroService, configHash string) (*appsv1.Deployment, error) {
+	deploySpec := *version.Template.DeepCopy()
+	tpl, err := PodTemplate(version, microService)
+	if err != nil {
+		return nil, err
+	}
+	stampConfigHash(tpl, configHash)
+	deploySpec.Template = *tpl
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cfg.Name(microService.Name, version.Name),
+			Namespace: microService.Namespace,
+			Labels:    versionLabels(cfg, version, microService),
+		},
+		Spec: deploySpec,
+	}, nil
+}
+
+// makeVersionStatefulSet renders the StatefulSet of version. Its serviceName
+// defaults to the name of the version's own Service, <MicroService>-<Version>.
+func makeVersionStatefulSet(cfg *configv1alpha1.ManagerConfig, version *appv1.DeployVersion, microService *appv1.MicroService, configHash string) (*appsv1.StatefulSet, error) {
+	if version.StatefulSetTemplate == nil {
+		return nil, fmt.Errorf("version %q of kind StatefulSet has no statefulSetTemplate", version.Name)
+	}
+
+	stsSpec := *version.StatefulSetTemplate.DeepCopy()
+	tpl, err := PodTemplate(version, microService)
+	if err != nil {
+		return nil, err
+	}
+	stampConfigHash(tpl, configHash)
+	stsSpec.Template = *tpl
+	if stsSpec.ServiceName == "" {
+		stsSpec.ServiceName = cfg.Name(microService.Name, version.Name)
+	}
+
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cfg.Name(microService.Name, version.Name),
+			Namespace: microService.Namespace,
+			Labels:    versionLabels(cfg, version, microService),
+		},
+		Spec: stsSpec,
+	}, nil
+}
+
+// versionAutoscaling returns the Autoscaling of version, defaulting to the one
+// of the MicroService.
+func versionAutoscaling(version *appv1.DeployVersion, microService *appv1.MicroService) *appv1.Autoscaling {
+	if version.Autoscaling != nil {
+		return version.Autoscaling
+	}
+	return microService.Spec.Autoscaling
+}
+
+// makeVersionHPA renders the HPA of version; it is named after the workload.
+// With ScaleWithWeight, the replicas of a canary version are scaled by its weight.
+func makeVersionHPA(cfg *configv1alpha1.ManagerConfig, version *appv1.DeployVersion, autoscaling *appv1.Autoscaling, microService *appv1.MicroService) *autoscalingv2beta2.HorizontalPodAutoscaler {
+	name := cfg.Name(microService.Name, version.Name)
+
+	minReplicas := int32(1)
+	if autoscaling.MinReplicas != nil {
+		minReplicas = *autoscaling.MinReplicas
+	}
+	maxReplicas := autoscaling.MaxReplicas
+	if autoscaling.ScaleWithWeight && version.Canary != nil {
+		minReplicas = scaleReplicas(minReplicas, version.Canary.Weight)
+		maxReplicas = scaleReplicas(maxReplicas, version.Canary.Weight)
+	}
+	if maxReplicas < minReplicas {
+		maxReplicas = minReplicas
+	}
+
+	return &autoscalingv2beta2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: microService.Namespace,
+			Labels:    versionLabels(cfg, version, microService),
+		},
+		Spec: autoscalingv2beta2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2beta2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       string(version.GetKind()),
+				Name:       name,
+			},
+			MinReplicas: &minReplicas,
+			MaxReplicas: maxReplicas,
+			Metrics:     autoscaling.Metrics,
+		},
+	}
+}
+
+// scaleReplicas scales replicas by a canary weight, rounding up to at least 1.
+func scaleReplicas(replicas int32, weight int) int32 {
+	scaled := (replicas*int32(weight) + 99) / 100
+	if scaled < 1 {
+		scaled = 1
+	}
+	return scaled
+}
+
+// versionDisruptionBudget returns the DisruptionBudget of version, defaulting
+// to the one of the MicroService.
+func versionDisruptionBudget(version *appv1.DeployVersion, microService *appv1.MicroService) *appv1.DisruptionBudget {
+	if version.DisruptionBudget != nil {
+		return version.DisruptionBudget
+	}
+	return microService.Spec.DisruptionBudget
+}
+
+// makeVersionPDB renders the PDB of version; it is named after the workload
+// and allows one unavailable pod unless a DisruptionBudget says otherwise.
+func makeVersionPDB(cfg *configv1alpha1.ManagerConfig, version *appv1.DeployVersion, microService *appv1.MicroService) (*policyv1beta1.PodDisruptionBudget, error) {
+	selector := version.PodSelector()
+	if selector == nil {
+		return nil, fmt.Errorf("version %q has no pod selector", version.Name)
+	}
+
+	spec := policyv1beta1.PodDisruptionBudgetSpec{Selector: selector.DeepCopy()}
+	budget := versionDisruptionBudget(version, microService)
+	switch {
+	case budget == nil || (budget.MinAvailable == nil && budget.MaxUnavailable == nil):
+		maxUnavailable := intstr.FromInt(1)
+		spec.MaxUnavailable = &maxUnavailable
+	case budget.MinAvailable != nil && budget.MaxUnavailable != nil:
+		return nil, fmt.Errorf("version %q sets both minAvailable and maxUnavailable", version.Name)
+	default:
+		spec.MinAvailable = budget.MinAvailable
+		spec.MaxUnavailable = budget.MaxUnavailable
+	}
+
+	return &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cfg.Name(microService.Name, version.Name),
+			Namespace: microService.Namespace,
+			Labels:    versionLabels(cfg, version, microService),
+		},
+		Spec: spec,
+	}, nil
+}
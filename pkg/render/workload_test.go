@@ -0,0 +1,141 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package render
+
+import (
+	"testing"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	"github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestMakeVersionWorkload(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	ms := &appv1.MicroService{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+	}
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db", "version": "v1"}}
+
+	deployVersion := &appv1.DeployVersion{
+		Name:     "v1",
+		Template: appsv1.DeploymentSpec{Selector: selector},
+	}
+	obj, err := makeVersionWorkload(configv1alpha1.Default(), deployVersion, ms, "")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(obj).To(gomega.BeAssignableToTypeOf(&appsv1.Deployment{}))
+	g.Expect(obj.GetName()).To(gomega.Equal("db-v1"))
+	g.Expect(deployVersion.PodLabels()).To(gomega.Equal(selector.MatchLabels))
+
+	stsVersion := &appv1.DeployVersion{
+		Name:                "v1",
+		Kind:                appv1.StatefulSetKind,
+		StatefulSetTemplate: &appsv1.StatefulSetSpec{Selector: selector},
+	}
+	obj, err = makeVersionWorkload(configv1alpha1.Default(), stsVersion, ms, "")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	sts, ok := obj.(*appsv1.StatefulSet)
+	g.Expect(ok).To(gomega.BeTrue())
+	g.Expect(sts.Name).To(gomega.Equal("db-v1"))
+	g.Expect(sts.Labels).To(gomega.HaveKeyWithValue("app.o0w0o.cn/version", "v1"))
+	g.Expect(sts.Spec.ServiceName).To(gomega.Equal("db-v1"))
+	g.Expect(stsVersion.StatefulSetTemplate.ServiceName).To(gomega.BeEmpty())
+	g.Expect(stsVersion.PodLabels()).To(gomega.Equal(selector.MatchLabels))
+
+	_, err = makeVersionWorkload(configv1alpha1.Default(), &appv1.DeployVersion{Name: "v2", Kind: appv1.StatefulSetKind}, ms, "")
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+func TestMakeVersionHPA(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	minReplicas := int32(4)
+	ms := &appv1.MicroService{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: appv1.MicroServiceSpec{
+			Autoscaling: &appv1.Autoscaling{MinReplicas: &minReplicas, MaxReplicas: 10, ScaleWithWeight: true},
+			Versions: []appv1.DeployVersion{
+				{Name: "v1"},
+				{Name: "v2", Canary: &appv1.Canary{Weight: 30}},
+				{Name: "v3", Kind: appv1.StatefulSetKind, Autoscaling: &appv1.Autoscaling{MaxReplicas: 3}},
+			},
+		},
+	}
+
+	stable := &ms.Spec.Versions[0]
+	hpa := makeVersionHPA(configv1alpha1.Default(), stable, versionAutoscaling(stable, ms), ms)
+	g.Expect(hpa.Name).To(gomega.Equal("web-v1"))
+	g.Expect(hpa.Spec.ScaleTargetRef.Kind).To(gomega.Equal("Deployment"))
+	g.Expect(hpa.Spec.ScaleTargetRef.Name).To(gomega.Equal("web-v1"))
+	g.Expect(*hpa.Spec.MinReplicas).To(gomega.Equal(int32(4)))
+	g.Expect(hpa.Spec.MaxReplicas).To(gomega.Equal(int32(10)))
+
+	canary := &ms.Spec.Versions[1]
+	hpa = makeVersionHPA(configv1alpha1.Default(), canary, versionAutoscaling(canary, ms), ms)
+	g.Expect(*hpa.Spec.MinReplicas).To(gomega.Equal(int32(2)))
+	g.Expect(hpa.Spec.MaxReplicas).To(gomega.Equal(int32(3)))
+
+	sts := &ms.Spec.Versions[2]
+	hpa = makeVersionHPA(configv1alpha1.Default(), sts, versionAutoscaling(sts, ms), ms)
+	g.Expect(hpa.Spec.ScaleTargetRef.Kind).To(gomega.Equal("StatefulSet"))
+	g.Expect(*hpa.Spec.MinReplicas).To(gomega.Equal(int32(1)))
+	g.Expect(hpa.Spec.MaxReplicas).To(gomega.Equal(int32(3)))
+}
+
+func TestMakeVersionPDB(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+	minAvailable := intstr.FromString("50%")
+	maxUnavailable := intstr.FromInt(2)
+	ms := &appv1.MicroService{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: appv1.MicroServiceSpec{
+			Versions: []appv1.DeployVersion{
+				{Name: "v1", Template: appsv1.DeploymentSpec{Selector: selector}},
+				{Name: "v2", Template: appsv1.DeploymentSpec{Selector: selector},
+					DisruptionBudget: &appv1.DisruptionBudget{MaxUnavailable: &maxUnavailable}},
+			},
+		},
+	}
+
+	pdb, err := makeVersionPDB(configv1alpha1.Default(), &ms.Spec.Versions[0], ms)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(pdb.Name).To(gomega.Equal("web-v1"))
+	g.Expect(pdb.Spec.Selector).To(gomega.Equal(selector))
+	g.Expect(*pdb.Spec.MaxUnavailable).To(gomega.Equal(intstr.FromInt(1)))
+
+	ms.Spec.DisruptionBudget = &appv1.DisruptionBudget{MinAvailable: &minAvailable}
+	pdb, err = makeVersionPDB(configv1alpha1.Default(), &ms.Spec.Versions[0], ms)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(*pdb.Spec.MinAvailable).To(gomega.Equal(minAvailable))
+	g.Expect(pdb.Spec.MaxUnavailable).To(gomega.BeNil())
+
+	pdb, err = makeVersionPDB(configv1alpha1.Default(), &ms.Spec.Versions[1], ms)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(pdb.Spec.MinAvailable).To(gomega.BeNil())
+	g.Expect(*pdb.Spec.MaxUnavailable).To(gomega.Equal(maxUnavailable))
+
+	ms.Spec.Versions[1].DisruptionBudget.MinAvailable = &minAvailable
+	_, err = makeVersionPDB(configv1alpha1.Default(), &ms.Spec.Versions[1], ms)
+	g.Expect(err).To(gomega.HaveOccurred())
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scopedcache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// multiNamespaceCache combines one cache per watched namespace. Namespaced
+// objects are read from the cache of their namespace and lists without a
+// namespace are merged over all the caches. Cluster-scoped objects are held by
+// every cache and are read from the cache of the first namespace.
+type multiNamespaceCache struct {
+	namespaces []string
+	caches     map[string]cache.Cache
+	scheme     *runtime.Scheme
+	mapper     apimeta.RESTMapper
+}
+
+var _ cache.Cache = &multiNamespaceCache{}
+
+// namespaced returns whether the objects of the kind live in a namespace.
+func (c *multiNamespaceCache) namespaced(gvk schema.GroupVersionKind) (bool, error) {
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return false, err
+	}
+	return mapping.Scope.Name() != apimeta.RESTScopeNameRoot, nil
+}
+
+// cacheFor returns the cache of the namespace, or the cache of the first
+// namespace for cluster-scoped objects.
+func (c *multiNamespaceCache) cacheFor(namespace string) (cache.Cache, error) {
+	if namespace == "" {
+		return c.caches[c.namespaces[0]], nil
+	}
+	ca, ok := c.caches[namespace]
+	if !ok {
+		return nil, fmt.Errorf("namespace %q is not watched by the manager", namespace)
+	}
+	return ca, nil
+}
+
+func (c *multiNamespaceCache) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	ca, err := c.cacheFor(key.Namespace)
+	if err != nil {
+		return err
+	}
+	return ca.Get(ctx, key, obj)
+}
+
+func (c *multiNamespaceCache) List(ctx context.Context, opts *client.ListOptions, list runtime.Object) error {
+	if opts != nil && opts.Namespace != "" {
+		ca, err := c.cacheFor(opts.Namespace)
+		if err != nil {
+			return err
+		}
+		return ca.List(ctx, opts, list)
+	}
+
+	gvk, err := apiutil.GVKForObject(list, c.scheme)
+	if err != nil {
+		return err
+	}
+	gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	if namespaced, err := c.namespaced(gvk); err != nil {
+		return err
+	} else if !namespaced {
+		return c.caches[c.namespaces[0]].List(ctx, opts, list)
+	}
+
+	var items []runtime.Object
+	for _, namespace := range c.namespaces {
+		nsList := list.DeepCopyObject()
+		if err := c.caches[namespace].List(ctx, opts, nsList); err != nil {
+			return err
+		}
+		nsItems, err := apimeta.ExtractList(nsList)
+		if err != nil {
+			return err
+		}
+		items = append(items, nsItems...)
+	}
+	return apimeta.SetList(list, items)
+}
+
+func (c *multiNamespaceCache) GetInformer(obj runtime.Object) (toolscache.SharedIndexInformer, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return nil, err
+	}
+	return c.informer(gvk, func(ca cache.Cache) (toolscache.SharedIndexInformer, error) {
+		return ca.GetInformer(obj)
+	})
+}
+
+func (c *multiNamespaceCache) GetInformerForKind(gvk schema.GroupVersionKind) (toolscache.SharedIndexInformer, error) {
+	return c.informer(gvk, func(ca cache.Cache) (toolscache.SharedIndexInformer, error) {
+		return ca.GetInformerForKind(gvk)
+	})
+}
+
+// informer returns the informer of the first namespace for cluster-scoped kinds,
+// so that the handlers are not called once per namespace, and an informer
+// combining the informers of all the namespaces otherwise.
+func (c *multiNamespaceCache) informer(gvk schema.GroupVersionKind, get func(cache.Cache) (toolscache.SharedIndexInformer, error)) (toolscache.SharedIndexInformer, error) {
+	if namespaced, err := c.namespaced(gvk); err != nil {
+		return nil, err
+	} else if !namespaced {
+		return get(c.caches[c.namespaces[0]])
+	}
+
+	informers := make([]toolscache.SharedIndexInformer, 0, len(c.namespaces))
+	for _, namespace := range c.namespaces {
+		informer, err := get(c.caches[namespace])
+		if err != nil {
+			return nil, err
+		}
+		informers = append(informers, informer)
+	}
+	return &multiNamespaceInformer{SharedIndexInformer: informers[0], informers: informers}, nil
+}
+
+func (c *multiNamespaceCache) IndexField(obj runtime.Object, field string, extractValue client.IndexerFunc) error {
+	for _, namespace := range c.namespaces {
+		if err := c.caches[namespace].IndexField(obj, field, extractValue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start runs the informers of all the namespaces until stop is closed.
+func (c *multiNamespaceCache) Start(stop <-chan struct{}) error {
+	for _, namespace := range c.namespaces {
+		go func(namespace string) {
+			if err := c.caches[namespace].Start(stop); err != nil {
+				log.Error(err, "unable to start the cache", "namespace", namespace)
+			}
+		}(namespace)
+	}
+	<-stop
+	return nil
+}
+
+func (c *multiNamespaceCache) WaitForCacheSync(stop <-chan struct{}) bool {
+	for _, namespace := range c.namespaces {
+		if !c.caches[namespace].WaitForCacheSync(stop) {
+			return false
+		}
+	}
+	return true
+}
+
+// multiNamespaceInformer adds the event handlers and indexers to the informers
+// of all the namespaces. The store and the indexer are the ones of the first
+// namespace; the objects are read through the cache instead.
+type multiNamespaceInformer struct {
+	toolscache.SharedIndexInformer
+
+	informers []toolscache.SharedIndexInformer
+}
+
+func (i *multiNamespaceInformer) AddEventHandler(handler toolscache.ResourceEventHandler) {
+	for _, informer := range i.informers {
+		informer.AddEventHandler(handler)
+	}
+}
+
+func (i *multiNamespaceInformer) AddEventHandlerWithResyncPeriod(handler toolscache.ResourceEventHandler, resyncPeriod time.Duration) {
+	for _, informer := range i.informers {
+		informer.AddEventHandlerWithResyncPeriod(handler, resyncPeriod)
+	}
+}
+
+func (i *multiNamespaceInformer) AddIndexers(indexers toolscache.Indexers) error {
+	for _, informer := range i.informers {
+		if err := informer.AddIndexers(indexers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *multiNamespaceInformer) HasSynced() bool {
+	for _, informer := range i.informers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+func (i *multiNamespaceInformer) Run(stop <-chan struct{}) {
+	for _, informer := range i.informers {
+		go informer.Run(stop)
+	}
+	<-stop
+}
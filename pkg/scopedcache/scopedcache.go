@@ -0,0 +1,108 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package scopedcache builds the cache of the manager so that it only holds the
+// objects the controllers reconcile or own.
+//
+// The informers of controller-runtime v0.1 watch either a single namespace or
+// the whole cluster, and always list every object of a kind. The cache returned
+// by New watches a list of namespaces, and lists and watches the kinds of
+// SelectorsByObject only with their label selector, so that the manager does not
+// keep every workload of a shared cluster in memory.
+package scopedcache
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var log = logf.Log.WithName("scopedcache")
+
+// Options configures the cache.
+type Options struct {
+	// Namespaces the cache watches, all namespaces when empty.
+	Namespaces []string
+	// SelectorsByObject restricts the cached objects of a kind to the objects
+	// matching the label selector. The other kinds are cached unfiltered.
+	SelectorsByObject map[runtime.Object]labels.Selector
+}
+
+// New returns a function creating the cache of the manager. It is set as the
+// NewCache option of the manager.
+func New(opts Options) manager.NewCacheFunc {
+	return func(config *rest.Config, cacheOpts cache.Options) (cache.Cache, error) {
+		selectors := make(map[schema.GroupVersionKind]labels.Selector, len(opts.SelectorsByObject))
+		for obj, selector := range opts.SelectorsByObject {
+			gvk, err := apiutil.GVKForObject(obj, cacheOpts.Scheme)
+			if err != nil {
+				return nil, err
+			}
+			if selector != nil && !selector.Empty() {
+				selectors[gvk] = selector
+			}
+		}
+
+		var namespaces []string
+		for _, namespace := range opts.Namespaces {
+			if namespace == "" {
+				return nil, fmt.Errorf("empty namespace in %q", opts.Namespaces)
+			}
+			if !containsString(namespaces, namespace) {
+				namespaces = append(namespaces, namespace)
+			}
+		}
+		if len(namespaces) == 0 {
+			namespaces = []string{cacheOpts.Namespace}
+		}
+
+		caches := make(map[string]cache.Cache, len(namespaces))
+		for _, namespace := range namespaces {
+			nsOpts := cacheOpts
+			nsOpts.Namespace = namespace
+			c, err := newSelectorCache(config, nsOpts, selectors)
+			if err != nil {
+				return nil, err
+			}
+			caches[namespace] = c
+		}
+		if len(namespaces) == 1 {
+			return caches[namespaces[0]], nil
+		}
+		return &multiNamespaceCache{
+			namespaces: namespaces,
+			caches:     caches,
+			scheme:     cacheOpts.Scheme,
+			mapper:     cacheOpts.Mapper,
+		}, nil
+	}
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
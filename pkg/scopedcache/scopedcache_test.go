@@ -0,0 +1,151 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scopedcache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllertest"
+)
+
+// fakeCache reads from a fake client holding the objects of one namespace.
+type fakeCache struct {
+	*informertest.FakeInformers
+	reader client.Client
+}
+
+func (c *fakeCache) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	return c.reader.Get(ctx, key, obj)
+}
+
+func (c *fakeCache) List(ctx context.Context, opts *client.ListOptions, list runtime.Object) error {
+	return c.reader.List(ctx, opts, list)
+}
+
+func newMultiNamespaceCache(objsByNamespace map[string][]runtime.Object, namespaces ...string) *multiNamespaceCache {
+	mapper := apimeta.NewDefaultRESTMapper(nil)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Service"), apimeta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Node"), apimeta.RESTScopeRoot)
+
+	caches := make(map[string]cache.Cache)
+	for _, namespace := range namespaces {
+		caches[namespace] = &fakeCache{
+			FakeInformers: &informertest.FakeInformers{},
+			reader:        fake.NewFakeClient(objsByNamespace[namespace]...),
+		}
+	}
+	return &multiNamespaceCache{namespaces: namespaces, caches: caches, scheme: scheme.Scheme, mapper: mapper}
+}
+
+func makeService(namespace, name string) *corev1.Service {
+	return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+}
+
+func TestMultiNamespaceCache(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+	c := newMultiNamespaceCache(map[string][]runtime.Object{
+		"team-a": {makeService("team-a", "voting"), node},
+		"team-b": {makeService("team-b", "result"), node},
+	}, "team-a", "team-b")
+	ctx := context.TODO()
+
+	// objects are read from the cache of their namespace
+	svc := &corev1.Service{}
+	g.Expect(c.Get(ctx, types.NamespacedName{Namespace: "team-b", Name: "result"}, svc)).To(gomega.Succeed())
+	g.Expect(c.Get(ctx, types.NamespacedName{Namespace: "team-c", Name: "result"}, svc)).NotTo(gomega.Succeed())
+
+	// lists without namespace are merged, namespaced lists are not
+	services := &corev1.ServiceList{}
+	g.Expect(c.List(ctx, &client.ListOptions{}, services)).To(gomega.Succeed())
+	g.Expect(services.Items).To(gomega.HaveLen(2))
+	g.Expect(c.List(ctx, client.InNamespace("team-a"), services)).To(gomega.Succeed())
+	g.Expect(services.Items).To(gomega.HaveLen(1))
+	g.Expect(services.Items[0].Name).To(gomega.Equal("voting"))
+
+	// cluster-scoped objects are listed once
+	nodes := &corev1.NodeList{}
+	g.Expect(c.List(ctx, &client.ListOptions{}, nodes)).To(gomega.Succeed())
+	g.Expect(nodes.Items).To(gomega.HaveLen(1))
+}
+
+func TestMultiNamespaceInformer(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	c := newMultiNamespaceCache(nil, "team-a", "team-b")
+
+	informer, err := c.GetInformer(&corev1.Service{})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	var added []string
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) { added = append(added, obj.(*corev1.Service).Namespace) },
+	})
+
+	var fakes []*controllertest.FakeInformer
+	for _, namespace := range c.namespaces {
+		fi, err := c.caches[namespace].(*fakeCache).FakeInformerFor(&corev1.Service{})
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		fakes = append(fakes, fi)
+	}
+	fakes[0].Add(makeService("team-a", "voting"))
+	fakes[1].Add(makeService("team-b", "result"))
+	g.Expect(added).To(gomega.Equal([]string{"team-a", "team-b"}))
+
+	// the informer has synced once the informers of all the namespaces have
+	fakes[0].Synced = true
+	g.Expect(informer.HasSynced()).To(gomega.BeFalse())
+	fakes[1].Synced = true
+	g.Expect(informer.HasSynced()).To(gomega.BeTrue())
+}
+
+func TestWithLabelSelector(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+	queries := make(chan map[string][]string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.Query()
+	}))
+	defer server.Close()
+
+	selector, err := labels.Parse("app.o0w0o.cn/service")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	config := withLabelSelector(&rest.Config{Host: server.URL}, selector)
+	rt, err := rest.TransportFor(config)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	resp, err := (&http.Client{Transport: rt}).Get(server.URL + "/api/v1/services?watch=true")
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	resp.Body.Close()
+	query := <-queries
+	g.Expect(query).To(gomega.HaveKeyWithValue("labelSelector", []string{"app.o0w0o.cn/service"}))
+	g.Expect(query).To(gomega.HaveKeyWithValue("watch", []string{"true"}))
+}
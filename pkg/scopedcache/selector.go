@@ -0,0 +1,183 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package scopedcache
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// selectorCache serves the kinds with a label selector from a cache whose
+// requests carry the selector, and the other kinds from the embedded cache.
+type selectorCache struct {
+	cache.Cache
+
+	scheme *runtime.Scheme
+	// filtered holds one cache per distinct label selector.
+	filtered []cache.Cache
+	// cachesByGVK maps the kinds with a label selector to their cache.
+	cachesByGVK map[schema.GroupVersionKind]cache.Cache
+}
+
+var _ cache.Cache = &selectorCache{}
+
+func newSelectorCache(config *rest.Config, opts cache.Options, selectors map[schema.GroupVersionKind]labels.Selector) (*selectorCache, error) {
+	unfiltered, err := cache.New(config, opts)
+	if err != nil {
+		return nil, err
+	}
+	c := &selectorCache{
+		Cache:       unfiltered,
+		scheme:      opts.Scheme,
+		cachesByGVK: make(map[schema.GroupVersionKind]cache.Cache, len(selectors)),
+	}
+
+	bySelector := make(map[string]cache.Cache)
+	for gvk, selector := range selectors {
+		filtered, ok := bySelector[selector.String()]
+		if !ok {
+			filtered, err = cache.New(withLabelSelector(config, selector), opts)
+			if err != nil {
+				return nil, err
+			}
+			bySelector[selector.String()] = filtered
+			c.filtered = append(c.filtered, filtered)
+		}
+		c.cachesByGVK[gvk] = filtered
+	}
+	return c, nil
+}
+
+// cacheForKind returns the cache holding the objects of the kind.
+func (c *selectorCache) cacheForKind(gvk schema.GroupVersionKind) cache.Cache {
+	if filtered, ok := c.cachesByGVK[gvk]; ok {
+		return filtered
+	}
+	return c.Cache
+}
+
+// cacheFor returns the cache holding obj, or the items of obj if it is a list.
+func (c *selectorCache) cacheFor(obj runtime.Object) (cache.Cache, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return nil, err
+	}
+	if apimeta.IsListType(obj) {
+		gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+	}
+	return c.cacheForKind(gvk), nil
+}
+
+func (c *selectorCache) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	ca, err := c.cacheFor(obj)
+	if err != nil {
+		return err
+	}
+	return ca.Get(ctx, key, obj)
+}
+
+func (c *selectorCache) List(ctx context.Context, opts *client.ListOptions, list runtime.Object) error {
+	ca, err := c.cacheFor(list)
+	if err != nil {
+		return err
+	}
+	return ca.List(ctx, opts, list)
+}
+
+func (c *selectorCache) GetInformer(obj runtime.Object) (toolscache.SharedIndexInformer, error) {
+	ca, err := c.cacheFor(obj)
+	if err != nil {
+		return nil, err
+	}
+	return ca.GetInformer(obj)
+}
+
+func (c *selectorCache) GetInformerForKind(gvk schema.GroupVersionKind) (toolscache.SharedIndexInformer, error) {
+	return c.cacheForKind(gvk).GetInformerForKind(gvk)
+}
+
+func (c *selectorCache) IndexField(obj runtime.Object, field string, extractValue client.IndexerFunc) error {
+	ca, err := c.cacheFor(obj)
+	if err != nil {
+		return err
+	}
+	return ca.IndexField(obj, field, extractValue)
+}
+
+// Start runs the informers of all the caches until stop is closed.
+func (c *selectorCache) Start(stop <-chan struct{}) error {
+	for _, filtered := range c.filtered {
+		go func(filtered cache.Cache) {
+			if err := filtered.Start(stop); err != nil {
+				log.Error(err, "unable to start the filtered cache")
+			}
+		}(filtered)
+	}
+	return c.Cache.Start(stop)
+}
+
+func (c *selectorCache) WaitForCacheSync(stop <-chan struct{}) bool {
+	for _, filtered := range c.filtered {
+		if !filtered.WaitForCacheSync(stop) {
+			return false
+		}
+	}
+	return c.Cache.WaitForCacheSync(stop)
+}
+
+// withLabelSelector returns a copy of config whose requests carry the label
+// selector. The caches only list and watch, so every request of a cache created
+// with this config is restricted to the objects matching the selector.
+func withLabelSelector(config *rest.Config, selector labels.Selector) *rest.Config {
+	config = rest.CopyConfig(config)
+	wrap := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return &selectorRoundTripper{selector: selector.String(), delegate: rt}
+	}
+	return config
+}
+
+// selectorRoundTripper sets the labelSelector parameter of the requests.
+type selectorRoundTripper struct {
+	selector string
+	delegate http.RoundTripper
+}
+
+func (rt *selectorRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = utilnet.CloneRequest(req)
+	u := *req.URL
+	query := u.Query()
+	query.Set("labelSelector", rt.selector)
+	u.RawQuery = query.Encode()
+	req.URL = &u
+	return rt.delegate.RoundTrip(req)
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conversion
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"canary-crd/pkg/apis/app/v1"
+	"canary-crd/pkg/apis/app/v2"
+
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+//handler.go: 这个文件实现了 CRD 的转换 webhook。API Server 在读写非存储版本（v2）的 App 和 MicroService 时，
+//会把 ConversionReview 发送到这里，由 Handler 调用 v2 包中的 ConvertTo 和 ConvertFrom 完成转换。
+
+// Handler serves the ConversionReview requests of the App and MicroService CRDs.
+type Handler struct{}
+
+var _ http.Handler = &Handler{}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	review := &apiextensionsv1beta1.ConversionReview{}
+	if err := json.NewDecoder(req.Body).Decode(review); err != nil {
+		log.Error(err, "unable to decode ConversionReview")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(w, "ConversionReview has no request", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = h.convertReview(review.Request)
+	review.Request = nil
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		log.Error(err, "unable to encode ConversionReview")
+	}
+}
+
+func (h *Handler) convertReview(req *apiextensionsv1beta1.ConversionRequest) *apiextensionsv1beta1.ConversionResponse {
+	resp := &apiextensionsv1beta1.ConversionResponse{UID: req.UID}
+	for _, obj := range req.Objects {
+		converted, err := Convert(obj.Raw, req.DesiredAPIVersion)
+		if err != nil {
+			log.Error(err, "unable to convert object", "desiredAPIVersion", req.DesiredAPIVersion)
+			resp.ConvertedObjects = nil
+			resp.Result = metav1.Status{Status: metav1.StatusFailure, Message: err.Error()}
+			return resp
+		}
+		resp.ConvertedObjects = append(resp.ConvertedObjects, runtime.RawExtension{Raw: converted})
+	}
+	resp.Result = metav1.Status{Status: metav1.StatusSuccess}
+	return resp
+}
+
+// Convert converts the JSON encoded App or MicroService in data to desiredAPIVersion.
+func Convert(data []byte, desiredAPIVersion string) ([]byte, error) {
+	typeMeta := &metav1.TypeMeta{}
+	if err := json.Unmarshal(data, typeMeta); err != nil {
+		return nil, err
+	}
+	from, err := schema.ParseGroupVersion(typeMeta.APIVersion)
+	if err != nil {
+		return nil, err
+	}
+	to, err := schema.ParseGroupVersion(desiredAPIVersion)
+	if err != nil {
+		return nil, err
+	}
+	if from == to {
+		return data, nil
+	}
+
+	var out runtime.Object
+	switch {
+	case from == v1.SchemeGroupVersion && to == v2.SchemeGroupVersion:
+		out, err = convertFromV1(typeMeta.Kind, data)
+	case from == v2.SchemeGroupVersion && to == v1.SchemeGroupVersion:
+		out, err = convertToV1(typeMeta.Kind, data)
+	default:
+		err = fmt.Errorf("unsupported conversion from %s to %s", from, to)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out.GetObjectKind().SetGroupVersionKind(to.WithKind(typeMeta.Kind))
+	return json.Marshal(out)
+}
+
+func convertFromV1(kind string, data []byte) (runtime.Object, error) {
+	switch kind {
+	case "App":
+		src, dst := &v1.App{}, &v2.App{}
+		if err := json.Unmarshal(data, src); err != nil {
+			return nil, err
+		}
+		return dst, dst.ConvertFrom(src)
+	case "MicroService":
+		src, dst := &v1.MicroService{}, &v2.MicroService{}
+		if err := json.Unmarshal(data, src); err != nil {
+			return nil, err
+		}
+		return dst, dst.ConvertFrom(src)
+	}
+	return nil, fmt.Errorf("unsupported kind %q", kind)
+}
+
+func convertToV1(kind string, data []byte) (runtime.Object, error) {
+	switch kind {
+	case "App":
+		src, dst := &v2.App{}, &v1.App{}
+		if err := json.Unmarshal(data, src); err != nil {
+			return nil, err
+		}
+		return dst, src.ConvertTo(dst)
+	case "MicroService":
+		src, dst := &v2.MicroService{}, &v1.MicroService{}
+		if err := json.Unmarshal(data, src); err != nil {
+			return nil, err
+		}
+		return dst, src.ConvertTo(dst)
+	}
+	return nil, fmt.Errorf("unsupported kind %q", kind)
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conversion
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"canary-crd/pkg/apis/app/v1"
+	"canary-crd/pkg/apis/app/v2"
+
+	"github.com/onsi/gomega"
+	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestHandler(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	ms := &v1.MicroService{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "app.o0w0o.cn/v1", Kind: "MicroService"},
+		ObjectMeta: metav1.ObjectMeta{Name: "voting-web", Namespace: "default"},
+		Spec: v1.MicroServiceSpec{
+			Versions:           []v1.DeployVersion{{Name: "v1", ServiceName: "voting-web-v1"}},
+			CurrentVersionName: "v1",
+		},
+	}
+	raw, err := json.Marshal(ms)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	review := &apiextensionsv1beta1.ConversionReview{
+		Request: &apiextensionsv1beta1.ConversionRequest{
+			UID:               "42",
+			DesiredAPIVersion: "app.o0w0o.cn/v2",
+			Objects:           []runtime.RawExtension{{Raw: raw}},
+		},
+	}
+	body, err := json.Marshal(review)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	w := httptest.NewRecorder()
+	(&Handler{}).ServeHTTP(w, httptest.NewRequest(http.MethodPost, Path, bytes.NewReader(body)))
+	g.Expect(w.Code).To(gomega.Equal(http.StatusOK))
+
+	result := &apiextensionsv1beta1.ConversionReview{}
+	g.Expect(json.Unmarshal(w.Body.Bytes(), result)).NotTo(gomega.HaveOccurred())
+	g.Expect(result.Response.UID).To(gomega.BeEquivalentTo("42"))
+	g.Expect(result.Response.Result.Status).To(gomega.Equal(metav1.StatusSuccess))
+	g.Expect(result.Response.ConvertedObjects).To(gomega.HaveLen(1))
+
+	converted := &v2.MicroService{}
+	g.Expect(json.Unmarshal(result.Response.ConvertedObjects[0].Raw, converted)).NotTo(gomega.HaveOccurred())
+	g.Expect(converted.APIVersion).To(gomega.Equal("app.o0w0o.cn/v2"))
+	g.Expect(converted.Name).To(gomega.Equal("voting-web"))
+	g.Expect(converted.Status.Versions).To(gomega.Equal([]v2.VersionStatus{{Name: "v1", ServiceName: "voting-web-v1"}}))
+}
+
+func TestConvertUnsupportedKind(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	_, err := Convert([]byte(`{"apiVersion":"app.o0w0o.cn/v1","kind":"Release"}`), "app.o0w0o.cn/v2")
+	g.Expect(err).To(gomega.HaveOccurred())
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conversion
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+var log = logf.Log.WithName("conversion-webhook")
+
+const (
+	// Path is the path the conversion webhook is served on.
+	Path = "/convert"
+
+	serverName  = "conversion-webhook-server"
+	serverPort  = 9876
+	certDir     = "/tmp/cert"
+	serviceName = "canary-crd-webhook-server-service"
+
+	// caCertName is the key of the CA certificate in the secret written by the webhook server.
+	caCertName = "ca-cert.pem"
+)
+
+// crdNames are the CRDs served in more than one version.
+var crdNames = []string{"apps.app.o0w0o.cn", "microservices.app.o0w0o.cn"}
+
+// Add creates the conversion webhook server and adds it to the Manager. The server is only
+// started inside the cluster, where the manager knows the secret mounted at /tmp/cert.
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;watch;update;patch
+func Add(mgr manager.Manager) error {
+	namespace := os.Getenv("POD_NAMESPACE")
+	secretName := os.Getenv("SECRET_NAME")
+	if namespace == "" || secretName == "" {
+		log.Info("POD_NAMESPACE or SECRET_NAME not set, conversion webhook disabled")
+		return nil
+	}
+
+	disableInstaller := false
+	svr, err := webhook.NewServer(serverName, mgr, webhook.ServerOptions{
+		Port:                          serverPort,
+		CertDir:                       certDir,
+		DisableWebhookConfigInstaller: &disableInstaller,
+		BootstrapOptions: &webhook.BootstrapOptions{
+			Secret: &types.NamespacedName{Namespace: namespace, Name: secretName},
+			Service: &webhook.Service{
+				Namespace: namespace,
+				Name:      serviceName,
+				Selectors: map[string]string{
+					"control-plane":           "controller-manager",
+					"controller-tools.k8s.io": "1.0",
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	svr.Handle(Path, &Handler{})
+	if err := mgr.Add(svr); err != nil {
+		return err
+	}
+
+	// The CRDs and the secret are read only once, so skip the manager's cache
+	c, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()})
+	if err != nil {
+		return err
+	}
+	return mgr.Add(&caInjector{
+		client: c,
+		secret: types.NamespacedName{Namespace: namespace, Name: secretName},
+		service: map[string]interface{}{
+			"namespace": namespace,
+			"name":      serviceName,
+			"path":      Path,
+		},
+	})
+}
+
+// caInjector points the conversion of the multi-version CRDs at the webhook server,
+// once the server has written its CA certificate into the secret.
+type caInjector struct {
+	client  client.Client
+	secret  types.NamespacedName
+	service map[string]interface{}
+}
+
+var _ manager.Runnable = &caInjector{}
+
+func (c *caInjector) Start(stop <-chan struct{}) error {
+	var caCert []byte
+	err := wait.PollUntil(5*time.Second, func() (bool, error) {
+		secret := &corev1.Secret{}
+		if err := c.client.Get(context.TODO(), c.secret, secret); err != nil {
+			log.Info("waiting for the webhook server secret", "secret", c.secret, "error", err.Error())
+			return false, nil
+		}
+		caCert = secret.Data[caCertName]
+		return len(caCert) != 0, nil
+	}, stop)
+	if err == wait.ErrWaitTimeout {
+		// stopped before the certificate was provisioned
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, name := range crdNames {
+		if err := c.inject(name, caCert); err != nil {
+			log.Error(err, "unable to configure CRD conversion", "crd", name)
+			return err
+		}
+	}
+	<-stop
+	return nil
+}
+
+func (c *caInjector) inject(name string, caCert []byte) error {
+	crd := &unstructured.Unstructured{}
+	crd.SetAPIVersion("apiextensions.k8s.io/v1beta1")
+	crd.SetKind("CustomResourceDefinition")
+	if err := c.client.Get(context.TODO(), types.NamespacedName{Name: name}, crd); err != nil {
+		return err
+	}
+
+	// The API server only accepts webhook conversion when unknown fields are pruned
+	preserve, found, _ := unstructured.NestedBool(crd.Object, "spec", "preserveUnknownFields")
+	caBundle, _, _ := unstructured.NestedString(crd.Object, "spec", "conversion", "webhookClientConfig", "caBundle")
+	if existing, err := base64.StdEncoding.DecodeString(caBundle); err == nil && bytes.Equal(existing, caCert) && found && !preserve {
+		return nil
+	}
+
+	conversion := map[string]interface{}{
+		"strategy": "Webhook",
+		"webhookClientConfig": map[string]interface{}{
+			"caBundle": base64.StdEncoding.EncodeToString(caCert),
+			"service":  c.service,
+		},
+	}
+	if err := unstructured.SetNestedField(crd.Object, false, "spec", "preserveUnknownFields"); err != nil {
+		return err
+	}
+	if err := unstructured.SetNestedField(crd.Object, conversion, "spec", "conversion"); err != nil {
+		return err
+	}
+	log.Info("Configuring CRD conversion webhook", "crd", name)
+	return c.client.Update(context.TODO(), crd)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package workload reads the readiness of the Deployments and StatefulSets the
+// versions of MicroServices run as. The controllers, the dashboard and canaryctl
+// share it, so that they agree on when a version is ready.
+package workload
+
+import (
+	"context"
+
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Status is the readiness of the workload of a version.
+type Status struct {
+	// Created is false until the workload exists.
+	Created       bool
+	Replicas      int32
+	ReadyReplicas int32
+	// Ready is true once every replica runs the latest pod template and is
+	// available.
+	Ready bool
+}
+
+// Read returns the status of the workload of version. A missing workload is not
+// an error, Created is false then.
+func Read(c client.Reader, cfg *configv1alpha1.ManagerConfig, ms *appv1.MicroService, version *appv1.DeployVersion) (Status, error) {
+	key := types.NamespacedName{Namespace: ms.Namespace, Name: cfg.Name(ms.Name, version.Name)}
+	var obj runtime.Object = &appsv1.Deployment{}
+	if version.GetKind() == appv1.StatefulSetKind {
+		obj = &appsv1.StatefulSet{}
+	}
+	if err := c.Get(context.TODO(), key, obj); err != nil {
+		if errors.IsNotFound(err) {
+			return Status{}, nil
+		}
+		return Status{}, err
+	}
+	switch o := obj.(type) {
+	case *appsv1.StatefulSet:
+		return Status{Created: true, Replicas: desiredReplicas(o.Spec.Replicas), ReadyReplicas: o.Status.ReadyReplicas, Ready: StatefulSetReady(o)}, nil
+	case *appsv1.Deployment:
+		return Status{Created: true, Replicas: desiredReplicas(o.Spec.Replicas), ReadyReplicas: o.Status.AvailableReplicas, Ready: DeploymentReady(o)}, nil
+	}
+	return Status{}, nil
+}
+
+// DeploymentReady returns whether every replica of deploy is updated and available.
+func DeploymentReady(deploy *appsv1.Deployment) bool {
+	if deploy.Status.ObservedGeneration < deploy.Generation {
+		return false
+	}
+	replicas := desiredReplicas(deploy.Spec.Replicas)
+	return deploy.Status.UpdatedReplicas >= replicas && deploy.Status.AvailableReplicas >= replicas
+}
+
+// StatefulSetReady returns whether every replica of sts runs the latest revision
+// and is ready.
+func StatefulSetReady(sts *appsv1.StatefulSet) bool {
+	if sts.Status.ObservedGeneration < sts.Generation {
+		return false
+	}
+	if sts.Status.UpdateRevision != "" && sts.Status.CurrentRevision != sts.Status.UpdateRevision {
+		return false
+	}
+	return sts.Status.ReadyReplicas >= desiredReplicas(sts.Spec.Replicas)
+}
+
+// desiredReplicas returns the replicas of a workload spec, which default to 1.
+func desiredReplicas(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2019 Hypo.
+
+Licensed under the GNU General Public License, Version 3 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/Coderhypo/canary-crd/blob/master/LICENSE
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package workload
+
+import (
+	"testing"
+
+	"canary-crd/pkg/apis"
+	appv1 "canary-crd/pkg/apis/app/v1"
+	configv1alpha1 "canary-crd/pkg/config/v1alpha1"
+
+	"github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRead(t *testing.T) {
+	g := gomega.NewGomegaWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(kubescheme.AddToScheme(scheme)).To(gomega.Succeed())
+	g.Expect(apis.AddToScheme(scheme)).To(gomega.Succeed())
+
+	replicas := int32(2)
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-v1", Namespace: "default", Generation: 2},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{ObservedGeneration: 2, UpdatedReplicas: 2, AvailableReplicas: 2},
+	}
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "web-v2", Namespace: "default"},
+		Status:     appsv1.StatefulSetStatus{ReadyReplicas: 1, CurrentRevision: "web-v2-1", UpdateRevision: "web-v2-2"},
+	}
+	c := fake.NewFakeClientWithScheme(scheme, deploy, sts)
+	cfg := configv1alpha1.Default()
+	ms := &appv1.MicroService{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: appv1.MicroServiceSpec{Versions: []appv1.DeployVersion{
+			{Name: "v1"},
+			{Name: "v2", Kind: appv1.StatefulSetKind},
+			{Name: "v3"},
+		}},
+	}
+
+	status, err := Read(c, cfg, ms, &ms.Spec.Versions[0])
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(status).To(gomega.Equal(Status{Created: true, Replicas: 2, ReadyReplicas: 2, Ready: true}))
+
+	// the ready pods still run the previous revision
+	status, err = Read(c, cfg, ms, &ms.Spec.Versions[1])
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(status).To(gomega.Equal(Status{Created: true, Replicas: 1, ReadyReplicas: 1}))
+
+	status, err = Read(c, cfg, ms, &ms.Spec.Versions[2])
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(status).To(gomega.Equal(Status{}))
+
+	// a Deployment whose controller has not seen the latest spec is not ready
+	deploy.Generation = 3
+	g.Expect(DeploymentReady(deploy)).To(gomega.BeFalse())
+}